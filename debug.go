@@ -0,0 +1,73 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Copyright 2024 Oxide Computer Company
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	"github.com/rancher/machine/libmachine/log"
+)
+
+// debugDumpDirEnvVar is the environment variable naming a directory that the
+// instance create request and response are written to for support cases.
+const debugDumpDirEnvVar = "OXIDE_DEBUG_DUMP_DIR"
+
+// redacted replaces sensitive values in debug dumps.
+const redacted = "[redacted]"
+
+// instanceCreateDump is the response half of an instance create debug dump.
+type instanceCreateDump struct {
+	Instance *oxide.Instance `json:"instance,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// dumpInstanceCreate writes the instance create request and its response or
+// error as JSON files into the `OXIDE_DEBUG_DUMP_DIR` directory, if set. The
+// user data is redacted since it commonly carries registration tokens, and the
+// API token is never part of the request. Failing to write the dump is logged
+// rather than failing the create.
+func (d *Driver) dumpInstanceCreate(icp oxide.InstanceCreateParams, instance *oxide.Instance, createErr error) {
+	dir := os.Getenv(debugDumpDirEnvVar)
+	if dir == "" {
+		return
+	}
+
+	request := icp
+	if icp.Body != nil {
+		body := *icp.Body
+		if body.UserData != "" {
+			body.UserData = redacted
+		}
+		request.Body = &body
+	}
+
+	response := instanceCreateDump{Instance: instance}
+	if createErr != nil {
+		response.Error = createErr.Error()
+	}
+
+	// Nanoseconds keep the dumps of retried creates from overwriting each other.
+	prefix := fmt.Sprintf("%s-%s-instance-create", d.GetMachineName(), time.Now().UTC().Format("20060102T150405.000000000Z"))
+	for suffix, v := range map[string]any{"request": request, "response": response} {
+		if err := writeJSONFile(filepath.Join(dir, prefix+"-"+suffix+".json"), v); err != nil {
+			log.Warnf("Failed writing debug dump: %v", err)
+		}
+	}
+}
+
+// writeJSONFile writes v as indented JSON to path, readable only by the owner.
+func writeJSONFile(path string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o600)
+}
@@ -0,0 +1,88 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Copyright 2024 Oxide Computer Company
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/oxidecomputer/oxide.go/oxide"
+)
+
+var _ = Describe("dumpInstanceCreate", func() {
+	var (
+		api     *fakeOxideAPI
+		SUT     *Driver
+		dumpDir string
+	)
+
+	BeforeEach(func() {
+		api = newFakeOxideAPI()
+		DeferCleanup(api.Close)
+		api.stubCreateDependencies()
+		SUT = api.driver("bob", GinkgoT().TempDir())
+
+		dumpDir = GinkgoT().TempDir()
+		GinkgoT().Setenv(debugDumpDirEnvVar, dumpDir)
+	})
+
+	readDump := func(suffix string, v any) {
+		matches, err := filepath.Glob(filepath.Join(dumpDir, "bob-*-instance-create-"+suffix+".json"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(matches).To(HaveLen(1))
+
+		b, err := os.ReadFile(matches[0])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(json.Unmarshal(b, v)).To(Succeed())
+	}
+
+	It("should write the request and response with the user data redacted", func() {
+		api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+			respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+		})
+		SUT.InjectKeyViaUserData = true
+
+		Expect(SUT.Create()).To(Succeed())
+
+		var request oxide.InstanceCreateParams
+		readDump("request", &request)
+		Expect(request.Project).To(Equal(oxide.NameOrId("project")))
+		Expect(request.Body.UserData).To(Equal(redacted))
+
+		var response instanceCreateDump
+		readDump("response", &response)
+		Expect(response.Instance.Id).To(Equal("instance-id"))
+		Expect(response.Error).To(BeEmpty())
+	})
+
+	It("should write the error when the create fails", func() {
+		api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+			respondError(w, http.StatusBadRequest, "InvalidRequest")
+		})
+
+		Expect(SUT.Create()).NotTo(Succeed())
+
+		var response instanceCreateDump
+		readDump("response", &response)
+		Expect(response.Instance).To(BeNil())
+		Expect(response.Error).To(ContainSubstring("InvalidRequest"))
+	})
+
+	It("should not overwrite the dumps of earlier attempts", func() {
+		icp := oxide.InstanceCreateParams{Project: "project", Body: &oxide.InstanceCreate{}}
+		SUT.dumpInstanceCreate(icp, nil, errors.New("conflict"))
+		SUT.dumpInstanceCreate(icp, &oxide.Instance{Id: "instance-id"}, nil)
+
+		matches, err := filepath.Glob(filepath.Join(dumpDir, "bob-*-instance-create-response.json"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(matches).To(HaveLen(2))
+	})
+})
@@ -1,6 +1,14 @@
 package main
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	"github.com/rancher/machine/libmachine/state"
+)
 
 // RequiredFlagError represents the error returned when a value for required
 // flag has not been provided.
@@ -41,3 +49,135 @@ func (p *FlagParseError) Unwrap() error {
 func NewFlagParseError(flag string, err error) *FlagParseError {
 	return &FlagParseError{Flag: flag, Err: err}
 }
+
+// NotConfiguredError represents the error returned when the driver is missing
+// the configuration needed to create an Oxide client (e.g., state deserialized
+// without secrets).
+type NotConfiguredError struct {
+	Missing []string
+}
+
+// Error implements the error interface.
+func (n *NotConfiguredError) Error() string {
+	return fmt.Sprintf("driver not configured: missing %s", strings.Join(n.Missing, "/"))
+}
+
+// NewNotConfiguredError constructs a `NotConfiguredError` for the given missing
+// configuration.
+func NewNotConfiguredError(missing ...string) *NotConfiguredError {
+	return &NotConfiguredError{Missing: missing}
+}
+
+// DriverError represents an error encountered by a driver operation while the
+// instance was in a particular state. The underlying error is embedded for
+// unwrapping.
+type DriverError struct {
+	Op    string
+	State state.State
+	Err   error
+}
+
+// Error implements the error interface.
+func (d *DriverError) Error() string {
+	return fmt.Sprintf("%s failed with instance in state %q: %s", d.Op, d.State.String(), d.Err.Error())
+}
+
+// Unwrap allows the `DriverError` to be unwrapped using `errors.Is` and
+// `errors.As` to get access to its embedded error.
+func (d *DriverError) Unwrap() error {
+	return d.Err
+}
+
+// NewDriverError constructs a `DriverError` for the given operation, last
+// observed instance state, and embedded err.
+func NewDriverError(op string, st state.State, err error) *DriverError {
+	return &DriverError{Op: op, State: st, Err: err}
+}
+
+// errAdditionalDisksExist is returned when additional disk names derived from
+// the instance name already exist in the project.
+var errAdditionalDisksExist = errors.New("additional disks already exist")
+
+// errInstanceNotOwned is returned when the instance found by name after an
+// ambiguous create was not created by the driver.
+var errInstanceNotOwned = errors.New("instance already exists and was not created by the driver")
+
+// isObjectAlreadyExists reports whether err is an Oxide API error indicating
+// that a resource with the requested name already exists.
+func isObjectAlreadyExists(err error) bool {
+	var httpErr *oxide.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.ErrorResponse == nil {
+		return false
+	}
+	return httpErr.ErrorResponse.ErrorCode == "ObjectAlreadyExists"
+}
+
+// isNotFound reports whether err is an Oxide API error indicating that the
+// requested resource does not exist.
+func isNotFound(err error) bool {
+	var httpErr *oxide.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.HTTPResponse == nil {
+		return false
+	}
+	return httpErr.HTTPResponse.StatusCode == http.StatusNotFound
+}
+
+// isUnauthorized reports whether err is an Oxide API error indicating that the
+// request was not authenticated (e.g., the token expired).
+func isUnauthorized(err error) bool {
+	var httpErr *oxide.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.HTTPResponse == nil {
+		return false
+	}
+	return httpErr.HTTPResponse.StatusCode == http.StatusUnauthorized
+}
+
+// isForbidden reports whether err is an Oxide API error indicating that the
+// token is not allowed to access the requested resource.
+func isForbidden(err error) bool {
+	var httpErr *oxide.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.HTTPResponse == nil {
+		return false
+	}
+	return httpErr.HTTPResponse.StatusCode == http.StatusForbidden
+}
+
+// isServiceUnavailable reports whether err is an Oxide API error indicating
+// that the API is temporarily unable to handle requests (e.g., during
+// maintenance).
+func isServiceUnavailable(err error) bool {
+	var httpErr *oxide.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.HTTPResponse == nil {
+		return false
+	}
+	return httpErr.HTTPResponse.StatusCode == http.StatusServiceUnavailable
+}
+
+// isAmbiguousRequestError reports whether err leaves it unknown whether the
+// Oxide API processed the request: sending the request or receiving its
+// response failed (e.g., the connection timed out or was dropped), or a gateway
+// in front of the API timed out. The Oxide SDK reports transport failures only
+// by message, so they're matched by its prefix.
+func isAmbiguousRequestError(err error) bool {
+	var httpErr *oxide.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.HTTPResponse != nil && httpErr.HTTPResponse.StatusCode == http.StatusGatewayTimeout
+	}
+	return strings.HasPrefix(err.Error(), "error sending request")
+}
+
+// isTransient reports whether err is likely to succeed when retried: the Oxide
+// API was unavailable or overloaded, or the request failed to be sent or its
+// response to be received. Client errors, such as a conflicting instance
+// state, are not transient.
+func isTransient(err error) bool {
+	var httpErr *oxide.HTTPError
+	if errors.As(err, &httpErr) {
+		if httpErr.HTTPResponse == nil {
+			return false
+		}
+		status := httpErr.HTTPResponse.StatusCode
+		return status == http.StatusTooManyRequests || (status >= http.StatusInternalServerError && status != http.StatusNotImplemented)
+	}
+	return strings.HasPrefix(err.Error(), "error sending request")
+}
@@ -0,0 +1,68 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Copyright 2024 Oxide Computer Company
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// nodeFacts is the JSON document written to `FactsOutputFile` after `Create`
+// so that downstream automation can consume the provisioning results.
+type nodeFacts struct {
+	InstanceID        string   `json:"instance_id"`
+	InstanceName      string   `json:"instance_name"`
+	IPAddress         string   `json:"ip_address"`
+	InternalIPAddress string   `json:"internal_ip_address,omitempty"`
+	ExternalIPAddress string   `json:"external_ip_address,omitempty"`
+	BootDiskID        string   `json:"boot_disk_id"`
+	AdditionalDiskIDs []string `json:"additional_disk_ids"`
+	SSHPublicKeyID    string   `json:"ssh_public_key_id"`
+}
+
+// writeFacts writes the driver's provisioning results to `FactsOutputFile`,
+// if set.
+func (d *Driver) writeFacts() error {
+	if d.FactsOutputFile == "" {
+		return nil
+	}
+
+	facts := nodeFacts{
+		InstanceID:        d.InstanceID,
+		InstanceName:      d.InstanceName,
+		IPAddress:         d.IPAddress,
+		InternalIPAddress: d.InternalIPAddress,
+		ExternalIPAddress: d.ExternalIPAddress,
+		BootDiskID:        d.BootDiskID,
+		AdditionalDiskIDs: d.AdditionalDiskIDs,
+		SSHPublicKeyID:    d.SSHPublicKeyID,
+	}
+	if facts.AdditionalDiskIDs == nil {
+		facts.AdditionalDiskIDs = []string{}
+	}
+
+	if err := writeJSONFile(d.FactsOutputFile, facts); err != nil {
+		return fmt.Errorf("failed writing facts output file: %w", err)
+	}
+	return nil
+}
+
+// checkFactsOutputDir verifies that the directory `FactsOutputFile` is written
+// to exists and is writable, so a provisioned instance isn't left without its
+// facts being recorded.
+func (d *Driver) checkFactsOutputDir() error {
+	if d.FactsOutputFile == "" {
+		return nil
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(d.FactsOutputFile), ".oxide-facts-*")
+	if err != nil {
+		return fmt.Errorf("facts output directory is not writable: %w", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
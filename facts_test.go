@@ -0,0 +1,70 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Copyright 2024 Oxide Computer Company
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/oxidecomputer/oxide.go/oxide"
+)
+
+var _ = Describe("facts output file", func() {
+	var (
+		api *fakeOxideAPI
+		SUT *Driver
+	)
+
+	BeforeEach(func() {
+		api = newFakeOxideAPI()
+		DeferCleanup(api.Close)
+		api.stubCreateDependencies()
+		SUT = api.driver("bob", GinkgoT().TempDir())
+		SUT.FactsOutputFile = filepath.Join(GinkgoT().TempDir(), "facts.json")
+	})
+
+	It("should write the driver state after Create", func() {
+		api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+			respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+		})
+		api.handle("GET /v1/instances/{instance}/disks", func(w http.ResponseWriter, r *http.Request) {
+			respondJSON(w, http.StatusOK, oxide.DiskResultsPage{
+				Items: []oxide.Disk{{Id: "boot-disk-id"}, {Id: "data-disk-id"}},
+			})
+		})
+
+		Expect(SUT.Create()).To(Succeed())
+
+		b, err := os.ReadFile(SUT.FactsOutputFile)
+		Expect(err).NotTo(HaveOccurred())
+
+		var facts nodeFacts
+		Expect(json.Unmarshal(b, &facts)).To(Succeed())
+		Expect(facts).To(Equal(nodeFacts{
+			InstanceID:        SUT.InstanceID,
+			InstanceName:      SUT.InstanceName,
+			IPAddress:         SUT.IPAddress,
+			InternalIPAddress: SUT.InternalIPAddress,
+			BootDiskID:        SUT.BootDiskID,
+			AdditionalDiskIDs: SUT.AdditionalDiskIDs,
+			SSHPublicKeyID:    SUT.SSHPublicKeyID,
+		}))
+		Expect(facts.InstanceID).To(Equal("instance-id"))
+		Expect(facts.IPAddress).To(Equal("172.30.0.5"))
+		Expect(facts.AdditionalDiskIDs).To(Equal([]string{"data-disk-id"}))
+	})
+
+	It("should fail PreCreateCheck when the directory does not exist", func() {
+		SUT.FactsOutputFile = filepath.Join(GinkgoT().TempDir(), "missing", "facts.json")
+		SUT.SkipPreCreateValidation = true
+
+		Expect(SUT.PreCreateCheck()).To(MatchError(ContainSubstring("facts output directory is not writable")))
+	})
+})
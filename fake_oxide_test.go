@@ -0,0 +1,186 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Copyright 2024 Oxide Computer Company
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+
+	. "github.com/onsi/gomega"
+	"github.com/oxidecomputer/oxide.go/oxide"
+)
+
+// fakeOxideAPI is a stand-in for the Oxide API that tests use to exercise the
+// driver methods without a real silo. Handlers are registered per route using
+// `http.ServeMux` patterns (e.g., `POST /v1/instances`) and every request is
+// recorded so tests can make assertions about the calls the driver made.
+type fakeOxideAPI struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	mux      *http.ServeMux
+	handlers map[string]http.HandlerFunc
+	requests []fakeRequest
+}
+
+// fakeRequest is a request received by the fake Oxide API.
+type fakeRequest struct {
+	Method string
+	Path   string
+	Query  string
+	Body   []byte
+}
+
+// newFakeOxideAPI starts a fake Oxide API. Callers must call `Close` when
+// finished.
+func newFakeOxideAPI() *fakeOxideAPI {
+	f := &fakeOxideAPI{
+		mux:      http.NewServeMux(),
+		handlers: make(map[string]http.HandlerFunc),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	return f
+}
+
+// serveHTTP records the request and dispatches it to the registered handler.
+func (f *fakeOxideAPI) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	// Handlers are serialized so they can keep state without synchronization.
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests = append(f.requests, fakeRequest{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.RawQuery,
+		Body:   body,
+	})
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	f.mux.ServeHTTP(w, r)
+}
+
+// handle registers handler for the given `http.ServeMux` pattern, replacing
+// any handler previously registered for it (e.g., by a stub).
+func (f *fakeOxideAPI) handle(pattern string, handler http.HandlerFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.handlers[pattern]; !ok {
+		f.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			f.handlers[pattern](w, r)
+		})
+	}
+	f.handlers[pattern] = handler
+}
+
+// requestsFor returns the recorded requests matching method and path.
+func (f *fakeOxideAPI) requestsFor(method, path string) []fakeRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var matched []fakeRequest
+	for _, r := range f.requests {
+		if r.Method == method && r.Path == path {
+			matched = append(matched, r)
+		}
+	}
+	return matched
+}
+
+// stubCreateDependencies registers handlers for the API calls `Create` makes
+// around instance creation: looking up the boot disk image, uploading the SSH
+// public key, listing the project's disks, and listing the instance's network
+// interfaces and disks.
+func (f *fakeOxideAPI) stubCreateDependencies() {
+	f.handle("GET /v1/images/{image}", func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, http.StatusOK, oxide.Image{Id: r.PathValue("image"), Name: oxide.Name(r.PathValue("image"))})
+	})
+	f.handle("GET /v1/disks", func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, http.StatusOK, oxide.DiskResultsPage{Items: []oxide.Disk{}})
+	})
+	f.handle("POST /v1/me/ssh-keys", func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, http.StatusCreated, oxide.SshKey{Id: "ssh-key-id"})
+	})
+	f.handle("GET /v1/network-interfaces", func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, http.StatusOK, oxide.InstanceNetworkInterfaceResultsPage{
+			Items: []oxide.InstanceNetworkInterface{
+				{
+					Id:   "nic-id",
+					Name: "nic",
+					IpStack: oxide.PrivateIpStack{
+						Value: &oxide.PrivateIpStackV4{
+							Value: oxide.PrivateIpv4Stack{Ip: "172.30.0.5"},
+						},
+					},
+				},
+			},
+		})
+	})
+	f.handle("GET /v1/instances/{instance}/disks", func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, http.StatusOK, oxide.DiskResultsPage{Items: []oxide.Disk{}})
+	})
+}
+
+// stubRemoveDependencies registers handlers for the API calls `Remove` makes:
+// stopping the instance, observing it stopped, and deleting the SSH public key,
+// instance, and disks.
+func (f *fakeOxideAPI) stubRemoveDependencies() {
+	f.handle("POST /v1/instances/{instance}/stop", func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, http.StatusAccepted, oxide.Instance{Id: r.PathValue("instance")})
+	})
+	f.handle("GET /v1/instances/{instance}", func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, http.StatusOK, oxide.Instance{Id: r.PathValue("instance"), RunState: oxide.InstanceStateStopped})
+	})
+	f.handle("DELETE /v1/me/ssh-keys/{key}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	f.handle("DELETE /v1/instances/{instance}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	f.handle("DELETE /v1/disks/{disk}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// driver returns a driver configured to talk to the fake Oxide API. The
+// machine directory within storePath is created so SSH keys can be written.
+func (f *fakeOxideAPI) driver(machineName, storePath string) *Driver {
+	Expect(os.MkdirAll(filepath.Join(storePath, "machines", machineName), 0o700)).To(Succeed())
+
+	d := newDriver(machineName, storePath)
+	d.Host = f.URL
+	d.Token = "token"
+	d.Project = "project"
+	d.VPC = "default"
+	d.Subnet = "default"
+	d.BootDiskImageID = "image"
+	return d
+}
+
+// respondJSON writes v as a JSON response with the given status code.
+func respondJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// respondError writes an Oxide API error response with the given status code
+// and error code.
+func respondError(w http.ResponseWriter, status int, errorCode string) {
+	respondJSON(w, status, map[string]string{
+		"error_code": errorCode,
+		"message":    errorCode,
+		"request_id": "fake",
+	})
+}
@@ -1,9 +1,22 @@
 package main
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/rancher/machine/libmachine/drivers/plugin"
 )
 
 func main() {
+	// Rancher invokes the driver without arguments so this does not interfere
+	// with serving the driver over RPC.
+	if len(os.Args) == 2 && os.Args[1] == versionFlag {
+		if err := writeVersionInfo(os.Stdout, newDriver("", "")); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	plugin.RegisterDriver(newDriver("", ""))
 }
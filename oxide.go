@@ -6,19 +6,33 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 
 	"github.com/dustin/go-humanize"
 	"github.com/oxidecomputer/oxide.go/oxide"
 	"github.com/rancher/machine/libmachine/drivers"
+	"github.com/rancher/machine/libmachine/log"
 	"github.com/rancher/machine/libmachine/mcnflag"
 	"github.com/rancher/machine/libmachine/ssh"
 	"github.com/rancher/machine/libmachine/state"
@@ -27,31 +41,247 @@ import (
 const (
 	defaultSSHUser      = "oxide"
 	defaultSSHPort      = 22
+	defaultDockerPort   = 2376
 	defaultDescription  = "Managed by the Oxide Rancher machine driver."
 	defaultMemory       = "4 GiB"
 	defaultBootDiskSize = "20 GiB"
+
+	defaultStopPollInterval    = time.Second
+	defaultStopPollMaxInterval = 15 * time.Second
+	defaultStopReissueAfter    = 30 * time.Second
+	defaultRemoveTimeout       = 2 * time.Minute
+	defaultCreateInitialDelay  = 3 * time.Second
+
+	defaultDiskDeleteConcurrency = 4
+	defaultStartMaxRetries       = 3
+)
+
+const (
+	flagHost                        = "oxide-host"
+	flagToken                       = "oxide-token"
+	flagProject                     = "oxide-project"
+	flagVCPUs                       = "oxide-vcpus"
+	flagMemory                      = "oxide-memory"
+	flagBootDiskSize                = "oxide-boot-disk-size"
+	flagBootDiskImageID             = "oxide-boot-disk-image-id"
+	flagAdditionalDisk              = "oxide-additional-disk"
+	flagVPC                         = "oxide-vpc"
+	flagSubnet                      = "oxide-subnet"
+	flagUserDataFile                = "oxide-user-data-file"
+	flagSSHUser                     = "oxide-ssh-user"
+	flagSSHPublicKey                = "oxide-ssh-public-key"
+	flagAntiAffinityGroup           = "oxide-anti-affinity-group"
+	flagEphemeralIPAttach           = "oxide-ephemeral-ip-attach"
+	flagEphemeralIPPool             = "oxide-ephemeral-ip-pool"
+	flagUserAgent                   = "oxide-user-agent"
+	flagNameConflictRetry           = "oxide-name-conflict-retry"
+	flagIPPreference                = "oxide-ip-preference"
+	flagEnsureAntiAffinityGroup     = "oxide-ensure-anti-affinity-group"
+	flagAntiAffinityGroupCleanup    = "oxide-anti-affinity-group-cleanup"
+	flagPreCreateWait               = "oxide-precreate-wait"
+	flagSSHKeyDescription           = "oxide-ssh-key-description"
+	flagStopPollInterval            = "oxide-stop-poll-interval"
+	flagStopPollMaxInterval         = "oxide-stop-poll-max-interval"
+	flagBootDiskDescription         = "oxide-boot-disk-description"
+	flagWaitOnStart                 = "oxide-wait-on-start"
+	flagTransitIPs                  = "oxide-transit-ips"
+	flagWaitForDiskDeletion         = "oxide-wait-for-disk-deletion"
+	flagSilo                        = "oxide-silo"
+	flagDomain                      = "oxide-domain"
+	flagProjectTemplate             = "oxide-project-template"
+	flagTokenFile                   = "oxide-token-file"
+	flagNICIPWait                   = "oxide-nic-ip-wait"
+	flagInjectKeyViaUserData        = "oxide-inject-key-via-user-data"
+	flagVerifySSHAuth               = "oxide-verify-ssh-auth"
+	flagDiskDeleteConcurrency       = "oxide-disk-delete-concurrency"
+	flagDNSServers                  = "oxide-dns-servers"
+	flagDNSSearch                   = "oxide-dns-search"
+	flagAffinityGroup               = "oxide-affinity-group"
+	flagInstanceDescription         = "oxide-instance-description"
+	flagAdoptExistingDisks          = "oxide-adopt-existing-disks"
+	flagStopReissueAfter            = "oxide-stop-reissue-after"
+	flagOperationTimeout            = "oxide-operation-timeout"
+	flagInitialState                = "oxide-initial-state"
+	flagStaticIP                    = "oxide-static-ip"
+	flagGateway                     = "oxide-gateway"
+	flagSSHKeyOrder                 = "oxide-ssh-key-order"
+	flagSkipPreCreateValidation     = "oxide-skip-precreate-validation"
+	flagCloneBootDiskFrom           = "oxide-clone-boot-disk-from"
+	flagCloneKeepSnapshot           = "oxide-clone-keep-snapshot"
+	flagLabels                      = "oxide-labels"
+	flagInstanceNameSeed            = "oxide-instance-name-seed"
+	flagDiskCreateConcurrency       = "oxide-disk-create-concurrency"
+	flagFactsOutputFile             = "oxide-facts-output-file"
+	flagCreateInitialDelay          = "oxide-create-initial-delay"
+	flagSSHPort                     = "oxide-ssh-port"
+	flagDockerPort                  = "oxide-docker-port"
+	flagAdditionalDiskFailurePolicy = "oxide-additional-disk-failure-policy"
+	flagTLSMinVersion               = "oxide-tls-min-version"
+	flagIPFamily                    = "oxide-ip-family"
+	flagPreserveOnFailure           = "oxide-preserve-on-failure"
+	flagStartMaxRetries             = "oxide-start-max-retries"
+	flagVerifyRemove                = "oxide-verify-remove"
+	flagEnableConsoleLogging        = "oxide-enable-console-logging"
+	flagAPIBasePath                 = "oxide-api-base-path"
+	flagRemoveTimeout               = "oxide-remove-timeout"
+	flagRespectMaintenance          = "oxide-respect-maintenance"
+	flagExternalIP                  = "oxide-external-ip"
+	flagDeleteAllDisks              = "oxide-delete-all-disks"
+	flagNameSuffixStrategy          = "oxide-name-suffix-strategy"
+	flagNameSuffixAlways            = "oxide-name-suffix-always"
+	flagPrimaryNIC                  = "oxide-primary-nic"
+	flagUserDataAsSeedISO           = "oxide-user-data-as-seed-iso"
+	flagResourceReferences          = "oxide-resource-references"
+)
+
+// bootDiskSizeAuto is the `oxide-boot-disk-size` value that sizes the boot disk
+// to fit its image.
+const bootDiskSizeAuto = "auto"
+
+// bootDiskSizeDeltaPrefix prefixes an `oxide-boot-disk-size` value that sizes
+// the boot disk to fit its image plus the given size (e.g., +10 GiB).
+const bootDiskSizeDeltaPrefix = "+"
+
+// imageSSHUsers maps operating system families to the default user of their
+// cloud images. Families are matched in order against the image's operating
+// system and then its name, so more specific families (e.g., Fedora CoreOS)
+// come first.
+var imageSSHUsers = []struct {
+	family string
+	user   string
+}{
+	{"coreos", "core"},
+	{"flatcar", "core"},
+	{"ubuntu", "ubuntu"},
+	{"debian", "debian"},
+	{"fedora", "fedora"},
+	{"rocky", "rocky"},
+	{"almalinux", "almalinux"},
+	{"alpine", "alpine"},
+}
+
+// maxDiskSize is the largest disk the Oxide API creates.
+const maxDiskSize = 1023 << 30
+
+// Values for `oxide-ip-preference`.
+const (
+	ipPreferenceExternal = "external"
+	ipPreferenceInternal = "internal"
+)
+
+// Values for `oxide-ssh-key-order`.
+const (
+	sshKeyOrderGeneratedFirst  = "generated-first"
+	sshKeyOrderAdditionalFirst = "additional-first"
+)
+
+// Values for `oxide-additional-disk-failure-policy`.
+const (
+	additionalDiskFailurePolicyFail = "fail"
+	additionalDiskFailurePolicyWarn = "warn"
+)
+
+// Values for `oxide-ip-family`.
+const (
+	ipFamilyIPv4 = "ipv4"
+	ipFamilyIPv6 = "ipv6"
+	ipFamilyDual = "dual"
+)
+
+// Values for `oxide-tls-min-version`.
+const (
+	tlsVersion12 = "1.2"
+	tlsVersion13 = "1.3"
+)
+
+// tlsVersions maps the `oxide-tls-min-version` values to TLS versions.
+var tlsVersions = map[string]uint16{
+	tlsVersion12: tls.VersionTLS12,
+	tlsVersion13: tls.VersionTLS13,
+}
+
+// apiRequestTimeout bounds each API request made with a custom HTTP client,
+// matching the Oxide SDK default client.
+const apiRequestTimeout = 600 * time.Second
+
+// Values for `oxide-name-suffix-strategy`.
+const (
+	nameSuffixStrategyNone      = "none"
+	nameSuffixStrategyRandom    = "random"
+	nameSuffixStrategyTimestamp = "timestamp"
+)
+
+// Values for `oxide-resource-references`.
+const (
+	resourceReferencesAuto = "auto"
+	resourceReferencesName = "name"
+	resourceReferencesID   = "id"
+)
+
+// Values for `oxide-initial-state`.
+const (
+	initialStateRunning = "running"
+	initialStateStopped = "stopped"
+)
+
+// clusterNameEnvVar is the environment variable holding the Rancher cluster
+// name made available to `oxide-project-template`.
+const clusterNameEnvVar = "RANCHER_CLUSTER_NAME"
+
+// labelsDescriptionPrefix precedes the JSON encoded `oxide-labels` appended to
+// the instance description so tooling can find and parse them.
+const labelsDescriptionPrefix = "labels="
+
+// nodeLabelPrefix is the prefix of the Kubernetes node labels returned by
+// `NodeLabels`.
+const nodeLabelPrefix = "oxide.computer/"
+
+// ownerDescriptionPrefix precedes the name of the instance appended to the
+// default description of the disks created with it, so disks whose owning
+// instance no longer exists can be identified.
+const ownerDescriptionPrefix = "owner="
+
+// Environment variables operators can set to bound the instance size.
+const (
+	minMemoryEnvVar = "OXIDE_MIN_MEMORY"
+	maxMemoryEnvVar = "OXIDE_MAX_MEMORY"
+	minVCPUsEnvVar  = "OXIDE_MIN_VCPUS"
+	maxVCPUsEnvVar  = "OXIDE_MAX_VCPUS"
 )
 
+// maxConcurrentCreatesEnvVar is the environment variable that bounds the
+// number of concurrent `InstanceCreate` calls across drivers in the same
+// process.
+const maxConcurrentCreatesEnvVar = "OXIDE_MAX_CONCURRENT_CREATES"
+
+// createSemaphore bounds the number of concurrent `InstanceCreate` calls across
+// drivers in the same process. A nil semaphore is unbounded.
+var createSemaphore = newCreateSemaphore(os.Getenv(maxConcurrentCreatesEnvVar))
+
+// nameConflictRetries is the number of times instance creation is retried with
+// a name suffix when `oxide-name-conflict-retry` is set.
+const nameConflictRetries = 2
+
+// ambiguousCreateRetries is the number of times instance creation is retried
+// when the outcome of a create was ambiguous and the instance doesn't exist.
+const ambiguousCreateRetries = 2
+
+// Parameters of the seed disk holding the user data for
+// `oxide-user-data-as-seed-iso`. The disk is the smallest Oxide allows, and its
+// contents are written in chunks no larger than the API accepts per request.
 const (
-	flagHost              = "oxide-host"
-	flagToken             = "oxide-token"
-	flagProject           = "oxide-project"
-	flagVCPUs             = "oxide-vcpus"
-	flagMemory            = "oxide-memory"
-	flagBootDiskSize      = "oxide-boot-disk-size"
-	flagBootDiskImageID   = "oxide-boot-disk-image-id"
-	flagAdditionalDisk    = "oxide-additional-disk"
-	flagVPC               = "oxide-vpc"
-	flagSubnet            = "oxide-subnet"
-	flagUserDataFile      = "oxide-user-data-file"
-	flagSSHUser           = "oxide-ssh-user"
-	flagSSHPublicKey      = "oxide-ssh-public-key"
-	flagAntiAffinityGroup = "oxide-anti-affinity-group"
-	flagEphemeralIPAttach = "oxide-ephemeral-ip-attach"
-	flagEphemeralIPPool   = "oxide-ephemeral-ip-pool"
-	flagUserAgent         = "oxide-user-agent"
+	seedDiskSize           = 1 << 30
+	seedDiskBlockSize      = 512
+	seedDiskWriteChunkSize = 512 << 10
 )
 
+// maxNameLength is the maximum length of an Oxide resource name.
+const maxNameLength = 63
+
+// seededNameSuffixLength is the number of hex characters of the seed's hash
+// appended to the machine name by `oxide-instance-name-seed`.
+const seededNameSuffixLength = 8
+
 // make sure Driver implements the drivers.Driver interface.
 var _ drivers.Driver = &Driver{}
 
@@ -66,24 +296,81 @@ type Driver struct {
 	// `OXIDE_HOST` when authenticating via the Oxide CLI.
 	Host string
 
+	// Oxide silo shortname (e.g., silo01). Combined with Domain to construct
+	// Host when Host is not given.
+	Silo string
+
+	// DNS suffix appended to Silo to construct Host (e.g., oxide.example.com).
+	Domain string
+
 	// Oxide API token. This is `OXIDE_TOKEN` when authenticating via the Oxide CLI.
 	Token string
 
+	// Optional path to a file containing the Oxide API token. The token is
+	// reloaded from this file when the Oxide API rejects it as unauthorized.
+	TokenFile string
+
 	// Oxide project to create instances within.
 	Project string
 
+	// ID of `Project`, resolved by `PreCreateCheck`. API calls refer to the
+	// project by ID when set so that they aren't affected by it being renamed.
+	ProjectID string
+
+	// How the project, VPC, subnet, and image references are given. Either
+	// `auto`, which treats references formatted as UUIDs as IDs and others as
+	// names, `name`, or `id`. References of the other kind are rejected.
+	ResourceReferences string
+
 	// Number of vCPUs to give the instance.
 	VCPUS int
 
 	// Amount of memory, in bytes, to give the instance.
 	Memory uint64
 
+	// Description of the instance.
+	InstanceDescription string
+
+	// Labels appended to the instance description as a JSON object so that
+	// external tooling (e.g., a CMDB) can parse them.
+	Labels map[string]string
+
 	// Size of the instance's boot disk, in bytes.
 	BootDiskSize uint64
 
-	// Image ID to use for the instance's boot disk.
+	// Whether `PreCreateCheck` sizes the boot disk to the size of its image
+	// plus `BootDiskSizeDelta`, overwriting `BootDiskSize`.
+	BootDiskSizeAuto bool
+
+	// Size added to the size of the boot disk image when `BootDiskSizeAuto` is
+	// set, in bytes.
+	BootDiskSizeDelta uint64
+
+	// Image name or ID to use for the instance's boot disk. A name is looked up
+	// among the project's images and the silo's images, and is replaced with
+	// the image's ID by `PreCreateCheck` or `Create`. The flag may be a
+	// template referencing environment variables, which is rendered by
+	// `SetConfigFromFlags`.
 	BootDiskImageID string
 
+	// Name or ID of an existing disk in the project to clone the boot disk
+	// from instead of `BootDiskImageID`. The disk is cloned via a snapshot.
+	CloneBootDiskFrom string
+
+	// Should the snapshot taken to clone the boot disk be kept after the
+	// instance is created. Kept snapshots are deleted by `Remove`.
+	CloneKeepSnapshot bool
+
+	// ID of the snapshot taken to clone the boot disk, while it exists.
+	CloneSnapshotID string
+
+	// ID of the disk holding the seed ISO for `UserDataAsSeedISO`. Used to
+	// delete the disk during `Remove`.
+	SeedDiskID string
+
+	// Description of the instance's boot disk.
+	BootDiskDescription string
+
 	// VPC for the instance.
 	VPC string
 
@@ -96,22 +383,231 @@ type Driver struct {
 	// pool for the ephemeral IP
 	EphemeralIPPool string
 
+	// External IPs to attach to the instance: pre-allocated floating IPs or an
+	// ephemeral IP from a specific pool.
+	ExternalIPs []ExternalIP
+
+	// Additional IPv4 networks, in CIDR notation, that the instance's network
+	// interface may send and receive traffic on. This allows the instance to
+	// forward traffic for those networks (e.g., pod networks for a CNI).
+	TransitIPs []string
+
+	// Name of the network interface whose IP address is used to reach the
+	// instance, for instances with network interfaces attached out of band.
+	// Empty is the network interface the driver creates.
+	PrimaryNIC string
+
+	// Whether `Create` verifies that the instance accepts the generated SSH key
+	// by authenticating over SSH.
+	VerifySSHAuth bool
+
+	// Whether `PreCreateCheck` sets the SSH user to the default user of the
+	// boot disk image's operating system, overwriting `SSHUser`. Set when no
+	// SSH user is given.
+	SSHUserAuto bool
+
+	// DNS servers to configure on the instance via the cloud-init user data.
+	DNSServers []string
+
+	// DNS search domains to configure on the instance via the cloud-init user
+	// data.
+	DNSSearch []string
+
+	// Static IPv4 address, in CIDR notation, for the instance's network
+	// interface. The network interface is created with the address and the
+	// instance is configured with it via the cloud-init user data.
+	StaticIP string
+
+	// IP address family of the instance's network interface. Either `ipv4`,
+	// `ipv6`, or `dual`. The instance is reached over IPv6 only for `ipv6`.
+	IPFamily string
+
+	// Default gateway to configure on the instance alongside `StaticIP`.
+	Gateway string
+
+	// Whether to add the generated SSH public key to `ssh_authorized_keys` in
+	// the cloud-init user data for images that ignore Oxide SSH keys.
+	InjectKeyViaUserData bool
+
+	// Whether to configure the instance via the cloud-init user data to log
+	// to the serial console and keep the journal across reboots.
+	EnableConsoleLogging bool
+
 	// Path to file containing user data for the instance.
 	UserDataFile string
 
+	// Should the user data be provided on a NoCloud seed ISO attached as a disk
+	// rather than through the instance's user data, for images that don't read
+	// Oxide's user data.
+	UserDataAsSeedISO bool
+
+	// Path to write the provisioning results to as JSON after `Create`.
+	FactsOutputFile string
+
 	// Additional SSH public keys Name or ID to inject into the instance.
 	SSHPublicKeys []string
 
+	// Whether the generated SSH public key is passed to the instance before or
+	// after `SSHPublicKeys`. Either `generated-first` or `additional-first`.
+	SSHKeyOrder string
+
+	// Port the Docker daemon on the instance listens on, used by `GetURL`.
+	// Zero uses the default port for drivers created before it was configurable.
+	DockerPort int
+
+	// Description of the generated SSH public key uploaded to Oxide.
+	SSHKeyDescription string
+
 	// Anti-affinity groups the instance will be a member of. The values can be IDs
 	// or names of anti-affinity groups.
 	AntiAffinityGroups []string
 
+	// Affinity groups the instance will be a member of. The values can be IDs or
+	// names of affinity groups. Instances cannot be added to affinity groups
+	// while running, so the instance is created stopped and started once it's a
+	// member.
+	AffinityGroups []string
+
+	// Name of an anti-affinity group the instance will be a member of. The group
+	// is created if it doesn't exist.
+	EnsureAntiAffinityGroup string
+
+	// Should the anti-affinity group created for `EnsureAntiAffinityGroup` be
+	// deleted during `Remove` once it has no members.
+	AntiAffinityGroupCleanup bool
+
 	// Additional disks to attach to the instance.
 	AdditionalDisks []AdditionalDisk
 
+	// Whether additional disks whose names already exist in the project (e.g.,
+	// left behind by a failed run) are attached rather than reported as a
+	// conflict.
+	AdoptExistingDisks bool
+
+	// Maximum number of additional disks created concurrently before the
+	// instance is created, to be attached by name. Zero creates them as part of
+	// instance creation.
+	DiskCreateConcurrency int
+
+	// What happens when creating an additional disk beforehand fails. Either
+	// `fail`, which deletes the disks that were created and fails `Create`, or
+	// `warn`, which creates the instance without the disks that failed.
+	AdditionalDiskFailurePolicy string
+
 	// Custom user agent string for API requests.
 	UserAgent string
 
+	// Minimum TLS version for API requests. Either `1.2` or `1.3`. Empty uses
+	// the Go default.
+	TLSMinVersion string
+
+	// Path prefix the Oxide API is served under (e.g., `/oxide` when a gateway
+	// serves the API at `https://gw.example.com/oxide`). Empty when the API is
+	// served at the root of `Host`.
+	APIBasePath string
+
+	// Should `Start` wait for the instance to be running before returning.
+	WaitOnStart bool
+
+	// Maximum number of times `Start` retries starting the instance when it
+	// fails with a transient error.
+	StartMaxRetries int
+
+	// Initial interval between instance state checks while waiting for the
+	// instance to stop. The interval doubles after every check up to
+	// `StopPollMaxInterval`.
+	StopPollInterval time.Duration
+
+	// Maximum interval between instance state checks while waiting for the
+	// instance to stop.
+	StopPollMaxInterval time.Duration
+
+	// How long the instance may be stopping before `Remove` re-issues the stop.
+	// Zero disables re-issuing the stop.
+	StopReissueAfter time.Duration
+
+	// How long `Remove` waits for the instance to stop before deleting it.
+	RemoveTimeout time.Duration
+
+	// Should `Remove` wait for each deleted disk to no longer exist before
+	// continuing.
+	WaitForDiskDeletion bool
+
+	// Maximum number of disks `Remove` deletes concurrently.
+	DiskDeleteConcurrency int
+
+	// Should `Remove` delete every additional disk attached to the instance,
+	// including disks attached out of band, rather than only the disks the
+	// driver created.
+	DeleteAllDisks bool
+
+	// Whether `Remove` verifies that the instance, its disks, and the generated
+	// SSH public key no longer exist once they've been deleted.
+	VerifyRemove bool
+
+	// How long `PreCreateCheck` waits for the project, VPC, subnet, and boot
+	// disk image to appear when they are not found. Zero disables waiting.
+	PreCreateWait time.Duration
+
+	// Should `PreCreateCheck` skip validating that the project, VPC, subnet,
+	// and other resources exist and are usable via the API. Failures are then
+	// only found partway through `Create`.
+	SkipPreCreateValidation bool
+
+	// Should `PreCreateCheck` check that the Oxide API is available, failing
+	// when it's unavailable (e.g., during a maintenance window) before any
+	// resources are created.
+	RespectMaintenance bool
+
+	// How long `Create` waits for the network interface to be assigned an IP
+	// address. Zero disables waiting.
+	NICIPWait time.Duration
+
+	// Which IP address Rancher should use to connect to the instance when it
+	// has both an internal and an external IP address. Either `external` or
+	// `internal`.
+	IPPreference string
+
+	// Which state `Create` leaves the instance in. Either `running` or
+	// `stopped`. Rancher requires `running` to provision the machine.
+	InitialState string
+
+	// How long `Create` waits after starting the instance before returning, so
+	// that the first `GetState` of the running wait that follows doesn't act on
+	// a transient state reported right after creation.
+	CreateInitialDelay time.Duration
+
+	// Should an instance be kept for debugging when `Create` fails after it
+	// was created, rather than being deleted by `Remove`.
+	PreserveOnFailure bool
+
+	// Whether the instance was preserved for debugging after `Create` failed.
+	// `Remove` leaves a preserved instance and its disks in place.
+	InstancePreserved bool
+
+	// Should instance creation be retried with a name suffix when the derived
+	// instance, disk, or network interface names already exist.
+	NameConflictRetry bool
+
+	// How the suffix appended to the instance name is generated. One of
+	// `none`, `random`, or `timestamp`. Empty is `random`.
+	NameSuffixStrategy string
+
+	// Should the name suffix always be appended to the instance name rather
+	// than only when retrying a name conflict.
+	NameSuffixAlways bool
+
+	// Seed from which a deterministic instance name is derived so that re-runs
+	// with the same seed use the same name. The Oxide API assigns instance IDs
+	// itself, so a deterministic name is the closest equivalent.
+	InstanceNameSeed string
+
+	// Name of the created instance. This is the machine name unless a name
+	// suffix was appended, either always or when a name conflict was retried.
+	// The boot disk, additional disks, and network interface names are derived
+	// from it.
+	InstanceName string
+
 	// ID of the created instance. Used to retrieve instance state during
 	// `GetState` and to delete the instance during `Remove`.
 	InstanceID string
@@ -128,7 +624,42 @@ type Driver struct {
 	// additional disks during `Remove`.
 	AdditionalDiskIDs []string
 
+	// IDs of the additional disks the driver created for `AdditionalDisks`, as
+	// opposed to disks discovered attached to the instance. Only these disks
+	// are deleted during `Remove` unless `DeleteAllDisks` is set. Nil for
+	// machines saved before they were tracked, whose additional disks are all
+	// deleted as before.
+	CreatedAdditionalDiskIDs []string
+
+	// ID of the anti-affinity group created for `EnsureAntiAffinityGroup`. Empty
+	// when the group already existed. Used to delete the group during `Remove`.
+	CreatedAntiAffinityGroupID string
+
+	// IDs of the floating IPs attached to the instance. Used to detach the
+	// floating IPs during `Remove`. They're never deleted since they're
+	// allocated independently of the machine.
+	FloatingIPIDs []string
+
+	// VPC-private IP address of the instance's network interface.
+	InternalIPAddress string
+
+	// External IP address attached to the instance, if any.
+	ExternalIPAddress string
+
+	// How long `Create` or `Remove` may take as a whole, including every API
+	// call and wait. Zero leaves the operations unbounded.
+	OperationTimeout time.Duration
+
 	oxideClient *oxide.Client
+
+	// User data read from stdin when `UserDataFile` is `-`. Stdin can only be
+	// read once, so it's kept for the rest of the process.
+	stdinUserData []byte
+
+	// Names of the additional disks `Create` created for the instance, as
+	// opposed to the disks it adopted. Set by `Create` and used to record
+	// `CreatedAdditionalDiskIDs` once the disks are attached.
+	createdDiskNames []string
 }
 
 // newDriver creates a new Oxide rancher machine driver.
@@ -140,12 +671,79 @@ func newDriver(machineName, storePath string) *Driver {
 			SSHPort:     defaultSSHPort,
 			StorePath:   storePath,
 		},
+		InstanceDescription: defaultDescription,
+		BootDiskDescription: defaultDescription,
+		SSHKeyDescription:   defaultDescription,
+		StopPollInterval:    defaultStopPollInterval,
+		StopPollMaxInterval: defaultStopPollMaxInterval,
+		StopReissueAfter:    defaultStopReissueAfter,
+		RemoveTimeout:       defaultRemoveTimeout,
+
+		DiskDeleteConcurrency: defaultDiskDeleteConcurrency,
 	}
 }
 
+// Clone returns a copy of the driver that shares no slices or maps with it, for
+// tooling that configures many similar machines from a template. The state of
+// the machine's instance (e.g., `InstanceID`, the disk IDs, and the IP
+// addresses) is reset so that `Create` creates a new instance. The machine
+// name is kept and should be changed before creating the instance.
+func (d *Driver) Clone() *Driver {
+	c := *d
+
+	baseDriver := *d.BaseDriver
+	baseDriver.IPAddress = ""
+	baseDriver.SSHKeyPath = ""
+	c.BaseDriver = &baseDriver
+
+	c.Labels = maps.Clone(d.Labels)
+	c.TransitIPs = slices.Clone(d.TransitIPs)
+	c.DNSServers = slices.Clone(d.DNSServers)
+	c.DNSSearch = slices.Clone(d.DNSSearch)
+	c.SSHPublicKeys = slices.Clone(d.SSHPublicKeys)
+	c.AntiAffinityGroups = slices.Clone(d.AntiAffinityGroups)
+	c.AffinityGroups = slices.Clone(d.AffinityGroups)
+	c.AdditionalDisks = slices.Clone(d.AdditionalDisks)
+	c.ExternalIPs = slices.Clone(d.ExternalIPs)
+	c.stdinUserData = slices.Clone(d.stdinUserData)
+
+	c.InstanceName = ""
+	c.InstanceID = ""
+	c.InstancePreserved = false
+	c.BootDiskID = ""
+	c.AdditionalDiskIDs = nil
+	c.CreatedAdditionalDiskIDs = nil
+	c.createdDiskNames = nil
+	c.CloneSnapshotID = ""
+	c.SeedDiskID = ""
+	c.SSHPublicKeyID = ""
+	c.CreatedAntiAffinityGroupID = ""
+	c.FloatingIPIDs = nil
+	c.InternalIPAddress = ""
+	c.ExternalIPAddress = ""
+
+	// The client is created again from the clone's configuration, which may be
+	// changed.
+	c.oxideClient = nil
+
+	return &c
+}
+
 // createOxideClient creates an Oxide client from the machine driver
-// configuration.
+// configuration. It returns a `NotConfiguredError` rather than falling back to
+// the environment when the host or token is missing.
 func (d *Driver) createOxideClient() (*oxide.Client, error) {
+	var missing []string
+	if d.Host == "" {
+		missing = append(missing, "host")
+	}
+	if d.Token == "" {
+		missing = append(missing, "token")
+	}
+	if len(missing) > 0 {
+		return nil, NewNotConfiguredError(missing...)
+	}
+
 	opts := []oxide.ClientOption{
 		oxide.WithHost(d.Host),
 		oxide.WithToken(d.Token),
@@ -153,9 +751,174 @@ func (d *Driver) createOxideClient() (*oxide.Client, error) {
 	if d.UserAgent != "" {
 		opts = append(opts, oxide.WithUserAgent(d.UserAgent))
 	}
+	if httpClient := d.httpClient(); httpClient != nil {
+		opts = append(opts, oxide.WithHTTPClient(httpClient))
+	}
 	return oxide.NewClient(opts...)
 }
 
+// httpClient returns the HTTP client for API requests enforcing
+// `TLSMinVersion` and `APIBasePath` and reloading the token from `TokenFile`,
+// or nil to use the Oxide SDK default client.
+func (d *Driver) httpClient() *http.Client {
+	minVersion, ok := tlsVersions[d.TLSMinVersion]
+	if !ok && d.APIBasePath == "" && d.TokenFile == "" {
+		return nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if ok {
+		transport.TLSClientConfig = &tls.Config{MinVersion: minVersion}
+	}
+
+	var roundTripper http.RoundTripper = transport
+	if d.APIBasePath != "" {
+		roundTripper = &basePathTransport{basePath: d.APIBasePath, next: roundTripper}
+	}
+	if d.TokenFile != "" {
+		roundTripper = &tokenFileTransport{
+			path: d.TokenFile,
+			next: roundTripper,
+			reloaded: func(token string) {
+				d.Token = token
+			},
+		}
+	}
+
+	return &http.Client{
+		Timeout:   apiRequestTimeout,
+		Transport: roundTripper,
+	}
+}
+
+// basePathTransport prefixes the path of every request with basePath. The
+// Oxide SDK resolves API paths against the root of the host, dropping any path
+// in it, so the prefix is added to each request instead.
+type basePathTransport struct {
+	basePath string
+	next     http.RoundTripper
+}
+
+// RoundTrip implements the `http.RoundTripper` interface.
+func (t *basePathTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Path = t.basePath + req.URL.Path
+	if req.URL.RawPath != "" {
+		req.URL.RawPath = t.basePath + req.URL.RawPath
+	}
+	return t.next.RoundTrip(req)
+}
+
+// tokenFileTransport reloads the token from the file at path when the Oxide API
+// rejects a request as unauthorized, and retries the request once with it. The
+// Oxide SDK sets the token it was created with on each request, so later
+// requests are sent with the reloaded token instead. reloaded is called with
+// each reloaded token.
+type tokenFileTransport struct {
+	path     string
+	next     http.RoundTripper
+	reloaded func(token string)
+
+	mu    sync.Mutex
+	token string
+}
+
+// RoundTrip implements the `http.RoundTripper` interface.
+func (t *tokenFileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	token := t.token
+	t.mu.Unlock()
+
+	if token != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// Requests whose body can't be read again are not retried.
+	retry := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return resp, nil
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+
+	token, err = readTokenFile(t.path)
+	if err != nil {
+		log.Warnf("Failed reloading token from %s: %v", t.path, err)
+		return resp, nil
+	}
+
+	t.mu.Lock()
+	t.token = token
+	t.reloaded(token)
+	t.mu.Unlock()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	log.Infof("Retrying with token reloaded from %s", t.path)
+	retry.Header.Set("Authorization", "Bearer "+token)
+	return t.next.RoundTrip(retry)
+}
+
+// validateAPIBasePath validates that path is an absolute URL path without a
+// query or fragment.
+func validateAPIBasePath(path string) error {
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("invalid base path %q, must start with /", path)
+	}
+
+	u, err := url.Parse(path)
+	if err != nil || u.Path != path || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("invalid base path %q, must only be a URL path", path)
+	}
+
+	return nil
+}
+
+// withOperationTimeout calls fn with a context that bounds every API call and
+// wait it makes by `OperationTimeout`. The returned error names op when the
+// timeout expired.
+func (d *Driver) withOperationTimeout(op string, fn func(ctx context.Context) error) error {
+	if d.OperationTimeout <= 0 {
+		return fn(context.Background())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.OperationTimeout)
+	defer cancel()
+
+	err := fn(ctx)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s exceeded the operation timeout of %s: %w", op, d.OperationTimeout, err)
+	}
+	return err
+}
+
+// readTokenFile reads an Oxide API token from path.
+func readTokenFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+
+	return token, nil
+}
+
 // Create creates the instance and any necessary dependencies (e.g., SSH keys,
 // disks) and updates the machine driver with state for use by other methods.
 // Create must start the instance otherwise the machine driver will time out
@@ -169,370 +932,2444 @@ func (d *Driver) Create() error {
 		d.oxideClient = client
 	}
 
-	pubKey, err := d.createSSHKeyPair()
+	return d.withOperationTimeout("create", d.create)
+}
+
+// create implements `Create` once the Oxide client has been created.
+func (d *Driver) create(ctx context.Context) error {
+	// The image is only resolved to its ID by `PreCreateCheck` when it's not
+	// skipped.
+	if d.BootDiskImageID != "" && !isUUID(d.BootDiskImageID) {
+		image, err := d.viewImage(ctx, d.BootDiskImageID)
+		if err != nil {
+			return fmt.Errorf("image %q not found: %w", d.BootDiskImageID, err)
+		}
+		d.BootDiskImageID = image.Id
+	}
+
+	if d.EnsureAntiAffinityGroup != "" {
+		if err := d.ensureAntiAffinityGroup(ctx); err != nil {
+			return err
+		}
+	}
+
+	pubKey, err := d.createSSHKeyPair(ctx)
 	if err != nil {
 		return err
 	}
 
 	d.SSHPublicKeyID = pubKey.Id
 
-	sshPublicKeys := []oxide.NameOrId{
-		oxide.NameOrId(d.SSHPublicKeyID),
+	userData, err := d.readUserData()
+	if err != nil {
+		return err
 	}
-	for _, sshPubKey := range d.SSHPublicKeys {
-		sshPublicKeys = append(sshPublicKeys, oxide.NameOrId(sshPubKey))
+
+	if d.CloneBootDiskFrom != "" {
+		if err := d.createCloneSnapshot(ctx); err != nil {
+			return errors.Join(err, d.deleteCloneSnapshot(ctx))
+		}
 	}
 
-	var userData []byte
-	if d.UserDataFile != "" {
-		b, err := os.ReadFile(d.UserDataFile)
-		if err != nil {
+	if d.UserDataAsSeedISO {
+		if err := d.createSeedDisk(ctx, userData); err != nil {
+			return errors.Join(err, d.deleteSeedDisk(ctx), d.deleteCloneSnapshot(ctx))
+		}
+		userData = nil
+	}
+
+	instance, err := d.createInstance(ctx, d.sshPublicKeyIDs(), userData)
+	if err != nil {
+		// The snapshot is only useful to the boot disk it was taken for, and
+		// the seed disk to the instance it was built for.
+		return errors.Join(err, d.deleteCloneSnapshot(ctx), d.deleteSeedDisk(ctx))
+	}
+
+	d.InstanceID = instance.Id
+	d.BootDiskID = instance.BootDiskId
+
+	if err := d.setUpInstance(ctx); err != nil {
+		if d.PreserveOnFailure {
+			d.InstancePreserved = true
+			log.Warnf("Preserving instance %s for debugging since creating the machine failed. It won't be deleted when the machine is removed.", d.InstanceID)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// setUpInstance completes `Create` once the instance exists by recording its
+// addresses and disks and waiting for it to be usable.
+func (d *Driver) setUpInstance(ctx context.Context) error {
+	if len(d.AffinityGroups) > 0 {
+		if err := d.addAffinityGroupMembers(ctx); err != nil {
 			return err
 		}
-		userData = b
 	}
 
-	disks := make([]oxide.InstanceDiskAttachment, len(d.AdditionalDisks))
-	for i, additionalDisk := range d.AdditionalDisks {
-		disks[i] = oxide.InstanceDiskAttachment{
-			Value: &oxide.InstanceDiskAttachmentCreate{
-				Description: defaultDescription,
-				DiskBackend: oxide.DiskBackend{
-					Value: &oxide.DiskBackendDistributed{
-						DiskSource: oxide.DiskSource{
-							Value: &oxide.DiskSourceBlank{
-								BlockSize: oxide.BlockSize(4096),
-							},
-						},
-					},
-				},
-				Name: oxide.Name(additionalDisk.Name(d.MachineName, i)),
-				Size: oxide.ByteCount(additionalDisk.Size),
-			},
+	if !d.CloneKeepSnapshot {
+		if err := d.deleteCloneSnapshot(ctx); err != nil {
+			return err
 		}
 	}
 
-	antiAffinityGroups := make([]oxide.NameOrId, 0, len(d.AntiAffinityGroups))
-	for _, antiAffinityGroup := range d.AntiAffinityGroups {
-		antiAffinityGroups = append(antiAffinityGroups, oxide.NameOrId(antiAffinityGroup))
+	if err := d.updateIPAddresses(ctx); err != nil {
+		return err
 	}
 
-	externalIPs := make([]oxide.ExternalIpCreate, 0, 1)
-	if d.EphemeralIPAttach {
-		var poolSelector oxide.PoolSelector
-		if d.EphemeralIPPool != "" {
-			poolSelector = oxide.PoolSelector{
-				Value: &oxide.PoolSelectorExplicit{
-					Pool: oxide.NameOrId(d.EphemeralIPPool),
-				},
-			}
-		} else {
-			poolSelector = oxide.PoolSelector{
-				Value: &oxide.PoolSelectorAuto{
-					IpVersion: oxide.IpVersionV4,
-				},
-			}
+	if err := d.updateAdditionalDiskIDs(ctx); err != nil {
+		return err
+	}
+
+	// A stopped instance cannot accept SSH connections.
+	if d.VerifySSHAuth && d.InitialState != initialStateStopped {
+		if err := d.waitForSSHAuth(ctx); err != nil {
+			return err
 		}
+	}
 
-		extIP := oxide.ExternalIpCreate{
-			Value: &oxide.ExternalIpCreateEphemeral{
-				PoolSelector: poolSelector,
-			},
+	// Rancher waits for the instance to be running as soon as `Create` returns,
+	// and the state reported right after creation can be transient.
+	if d.CreateInitialDelay > 0 && d.InitialState != initialStateStopped {
+		if err := d.sleep(ctx, d.CreateInitialDelay); err != nil {
+			return err
 		}
-		externalIPs = append(externalIPs, extIP)
 	}
 
-	icp := oxide.InstanceCreateParams{
-		Project: oxide.NameOrId(d.Project),
-		Body: &oxide.InstanceCreate{
-			AntiAffinityGroups: antiAffinityGroups,
-			BootDisk: oxide.InstanceDiskAttachment{
-				Value: &oxide.InstanceDiskAttachmentCreate{
-					Description: defaultDescription,
-					DiskBackend: oxide.DiskBackend{
-						Value: &oxide.DiskBackendDistributed{
-							DiskSource: oxide.DiskSource{
-								Value: &oxide.DiskSourceImage{
-									ImageId: d.BootDiskImageID,
-								},
-							},
-						},
-					},
-					Name: oxide.Name("disk-" + d.GetMachineName()),
-					Size: oxide.ByteCount(d.BootDiskSize),
-				},
-			},
-			Disks:       disks,
-			Description: defaultDescription,
-			ExternalIps: externalIPs,
-			Hostname:    oxide.Hostname(d.GetMachineName()),
-			Memory:      oxide.ByteCount(d.Memory),
-			Name:        oxide.Name(d.GetMachineName()),
-			Ncpus:       oxide.InstanceCpuCount(d.VCPUS),
-			NetworkInterfaces: oxide.InstanceNetworkInterfaceAttachment{
-				Value: &oxide.InstanceNetworkInterfaceAttachmentCreate{
-					Params: []oxide.InstanceNetworkInterfaceCreate{
-						{
-							Description: defaultDescription,
-							Name:        oxide.Name("nic-" + d.GetMachineName()),
-							SubnetName:  oxide.Name(d.Subnet),
-							VpcName:     oxide.Name(d.VPC),
-							IpConfig: oxide.PrivateIpStackCreate{
-								Value: &oxide.PrivateIpStackCreateV4{
-									Value: oxide.PrivateIpv4StackCreate{
-										Ip: oxide.Ipv4Assignment{
-											Value: &oxide.Ipv4AssignmentAuto{},
-										},
-									},
-								},
-							},
-						},
-					},
-				},
-			},
-			SshPublicKeys: sshPublicKeys,
-			UserData:      base64.StdEncoding.EncodeToString(userData),
-		},
+	return d.writeFacts()
+}
+
+// Refresh re-populates the instance state tracked by the driver (e.g., IP
+// addresses, boot disk, additional disks) from the Oxide API. This reconciles
+// stale state, such as after deserializing old configuration or adopting an
+// existing instance.
+func (d *Driver) Refresh() error {
+	ctx := context.TODO()
+
+	if d.oxideClient == nil {
+		client, err := d.createOxideClient()
+		if err != nil {
+			return err
+		}
+		d.oxideClient = client
 	}
-	instance, err := d.oxideClient.InstanceCreate(context.TODO(), icp)
+
+	instance, err := d.oxideClient.InstanceView(ctx, oxide.InstanceViewParams{
+		Instance: oxide.NameOrId(d.InstanceID),
+	})
 	if err != nil {
-		return err
+		return fmt.Errorf("failed viewing instance: %w", err)
 	}
 
 	d.InstanceID = instance.Id
+	d.InstanceName = string(instance.Name)
 	d.BootDiskID = instance.BootDiskId
 
-	inilp := oxide.InstanceNetworkInterfaceListParams{
+	if err := d.updateIPAddresses(ctx); err != nil {
+		return err
+	}
+
+	return d.updateAdditionalDiskIDs(ctx)
+}
+
+// Reconcile compares the instance against the desired configuration and
+// corrects drift where the API allows it. Missing additional disks that exist
+// in the project are attached. The vCPUs and memory cannot be changed by the
+// driver so drift is only reported. Any remaining drift is returned joined
+// together.
+func (d *Driver) Reconcile() error {
+	ctx := context.TODO()
+
+	if d.oxideClient == nil {
+		client, err := d.createOxideClient()
+		if err != nil {
+			return err
+		}
+		d.oxideClient = client
+	}
+
+	instance, err := d.oxideClient.InstanceView(ctx, oxide.InstanceViewParams{
+		Instance: oxide.NameOrId(d.InstanceID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed viewing instance: %w", err)
+	}
+
+	var driftErr error
+	if int(instance.Ncpus) != d.VCPUS {
+		driftErr = errors.Join(driftErr, fmt.Errorf("instance has %d vcpus, expected %d", instance.Ncpus, d.VCPUS))
+	}
+	if uint64(instance.Memory) != d.Memory {
+		driftErr = errors.Join(driftErr, fmt.Errorf("instance has %s memory, expected %s",
+			humanize.IBytes(uint64(instance.Memory)), humanize.IBytes(d.Memory)))
+	}
+
+	attachedDisks, err := d.oxideClient.InstanceDiskListAllPages(ctx, oxide.InstanceDiskListParams{
 		Instance: oxide.NameOrId(d.InstanceID),
+	})
+	if err != nil {
+		return errors.Join(driftErr, fmt.Errorf("failed listing disks for instance: %w", err))
+	}
+
+	attached := 0
+	for i, additionalDisk := range d.AdditionalDisks {
+		diskName := additionalDisk.Name(string(instance.Name), i)
+		if slices.ContainsFunc(attachedDisks, func(disk oxide.Disk) bool { return string(disk.Name) == diskName }) {
+			continue
+		}
+
+		if _, err := d.oxideClient.InstanceDiskAttach(ctx, oxide.InstanceDiskAttachParams{
+			Instance: oxide.NameOrId(d.InstanceID),
+			Body: &oxide.DiskPath{
+				Disk: oxide.NameOrId(diskName),
+			},
+		}); err != nil {
+			driftErr = errors.Join(driftErr, fmt.Errorf("additional disk %s is not attached: %w", diskName, err))
+			continue
+		}
+
+		log.Infof("Attached missing additional disk %s to instance %s", diskName, d.InstanceID)
+		attached++
+	}
+
+	if attached > 0 {
+		if err := d.updateAdditionalDiskIDs(ctx); err != nil {
+			return errors.Join(driftErr, err)
+		}
+	}
+
+	if driftErr != nil {
+		log.Warnf("Instance %s has drifted from its configuration: %v", d.InstanceID, driftErr)
+	}
+
+	return driftErr
+}
+
+// ReattachDisksByLabel attaches disks previously created by the driver for an
+// additional disk with each of the labels, such as disks kept by
+// `RemoveInstanceOnly`, to the current instance. Disks are found by the
+// additional disk name convention for the instance named fromInstance, or for
+// this machine's instance when it's empty. A replacement machine passes the
+// name of the instance it replaces to take over its disks. Each label must
+// match exactly one disk in the project. Disks attached to another instance
+// are reported rather than moved. Oxide only attaches disks to stopped
+// instances.
+func (d *Driver) ReattachDisksByLabel(fromInstance string, labels []string) error {
+	ctx := context.TODO()
+
+	if d.oxideClient == nil {
+		client, err := d.createOxideClient()
+		if err != nil {
+			return err
+		}
+		d.oxideClient = client
 	}
-	networkInterfaces, err := d.oxideClient.InstanceNetworkInterfaceListAllPages(context.TODO(), inilp)
+
+	disks, err := d.oxideClient.DiskListAllPages(ctx, oxide.DiskListParams{
+		Project: d.projectRef(),
+	})
 	if err != nil {
-		return err
+		return fmt.Errorf("failed listing disks in project %q: %w", d.Project, err)
 	}
 
-	if len(networkInterfaces) == 0 {
-		return errors.New("no valid network interfaces found")
+	machineName := cmp.Or(fromInstance, d.InstanceName, d.baseInstanceName())
+
+	var reattachErr error
+	attached := 0
+	for _, label := range labels {
+		var matches []oxide.Disk
+		for _, disk := range disks {
+			if additionalDiskHasLabel(string(disk.Name), label, machineName) {
+				matches = append(matches, disk)
+			}
+		}
+
+		switch len(matches) {
+		case 0:
+			reattachErr = errors.Join(reattachErr, fmt.Errorf("no disk with label %q found in project %q", label, d.Project))
+			continue
+		case 1:
+		default:
+			names := make([]string, len(matches))
+			for i, disk := range matches {
+				names[i] = string(disk.Name)
+			}
+			reattachErr = errors.Join(reattachErr, fmt.Errorf("multiple disks with label %q found in project %q: %s", label, d.Project, strings.Join(names, ", ")))
+			continue
+		}
+
+		disk := matches[0]
+		if state, ok := disk.State.AsAttached(); ok {
+			if state.Instance != d.InstanceID {
+				reattachErr = errors.Join(reattachErr, fmt.Errorf("disk %s is attached to another instance %s", disk.Name, state.Instance))
+			}
+			continue
+		}
+
+		if _, err := d.oxideClient.InstanceDiskAttach(ctx, oxide.InstanceDiskAttachParams{
+			Instance: oxide.NameOrId(d.InstanceID),
+			Body: &oxide.DiskPath{
+				Disk: oxide.NameOrId(disk.Id),
+			},
+		}); err != nil {
+			reattachErr = errors.Join(reattachErr, fmt.Errorf("failed attaching disk %s: %w", disk.Name, err))
+			continue
+		}
+
+		log.Infof("Reattached disk %s to instance %s", disk.Name, d.InstanceID)
+		attached++
 	}
 
-	nic := networkInterfaces[0]
-	switch v := nic.IpStack.Value.(type) {
-	case oxide.PrivateIpStackV4:
-		d.IPAddress = v.Value.Ip
-	case *oxide.PrivateIpStackV4:
-		d.IPAddress = v.Value.Ip
-	case oxide.PrivateIpStackDualStack:
-		d.IPAddress = v.Value.V4.Ip
-	case *oxide.PrivateIpStackDualStack:
-		d.IPAddress = v.Value.V4.Ip
-	default:
-		return errors.New(
-			"no IPv4 address found on network interface",
-		)
+	if attached > 0 {
+		if err := d.updateAdditionalDiskIDs(ctx); err != nil {
+			return errors.Join(reattachErr, err)
+		}
+	}
+
+	return reattachErr
+}
+
+// additionalDiskHasLabel reports whether diskName is the name given by
+// `AdditionalDisk.Name` to an additional disk with label for machineName.
+func additionalDiskHasLabel(diskName, label, machineName string) bool {
+	rest, ok := strings.CutPrefix(diskName, "disk-")
+	if !ok || len(rest) < 3 || rest[2] != '-' {
+		return false
+	}
+	diskNumber, err := strconv.Atoi(rest[:2])
+	if err != nil {
+		return false
 	}
+	return diskName == AdditionalDisk{Label: label}.Name(machineName, diskNumber)
+}
 
-	additionalDisks, err := d.oxideClient.InstanceDiskListAllPages(context.TODO(), oxide.InstanceDiskListParams{
+// updateAdditionalDiskIDs lists the disks attached to the instance and records
+// every disk other than the boot disk in `AdditionalDiskIDs`.
+func (d *Driver) updateAdditionalDiskIDs(ctx context.Context) error {
+	additionalDisks, err := d.oxideClient.InstanceDiskListAllPages(ctx, oxide.InstanceDiskListParams{
 		Instance: oxide.NameOrId(d.InstanceID),
 	})
 	if err != nil {
 		return fmt.Errorf("failed listing disks for instance: %w", err)
 	}
 
-	d.AdditionalDiskIDs = make([]string, 0, len(d.AdditionalDisks))
+	// The API makes no guarantee about the order disks are listed in, so they
+	// are ordered as requested by `AdditionalDisks` to keep the recorded IDs
+	// aligned with the attachment order. Disks that weren't requested (e.g.,
+	// attached out of band) are ordered last, as listed.
+	if len(d.AdditionalDisks) > 0 {
+		diskNames := make([]string, len(d.AdditionalDisks))
+		for i, additionalDisk := range d.AdditionalDisks {
+			diskNames[i] = additionalDisk.Name(d.InstanceName, i)
+		}
+		requestOrder := func(disk oxide.Disk) int {
+			if i := slices.Index(diskNames, string(disk.Name)); i >= 0 {
+				return i
+			}
+			return len(diskNames)
+		}
+		slices.SortStableFunc(additionalDisks, func(a, b oxide.Disk) int {
+			return requestOrder(a) - requestOrder(b)
+		})
+	}
+
+	// Machines saved before the created disks were tracked have no created
+	// disk IDs and keep deleting every additional disk.
+	createdDiskIDs := d.CreatedAdditionalDiskIDs
+	trackCreated := createdDiskIDs != nil || d.createdDiskNames != nil
+
+	// The IDs are never nil so that an instance with only a boot disk is
+	// consistently saved with an empty list rather than null.
+	d.AdditionalDiskIDs = make([]string, 0, len(additionalDisks))
+	d.CreatedAdditionalDiskIDs = nil
+	if trackCreated {
+		d.CreatedAdditionalDiskIDs = make([]string, 0, len(d.AdditionalDisks))
+	}
 	for _, additionalDisk := range additionalDisks {
-		// The boot disk ID state is managed irrespective of the additional disks.
-		if additionalDisk.Id == instance.BootDiskId {
+		// The boot disk and seed disk ID state is managed irrespective of the
+		// additional disks.
+		if additionalDisk.Id == d.BootDiskID || additionalDisk.Id == d.SeedDiskID {
 			continue
 		}
 		d.AdditionalDiskIDs = append(d.AdditionalDiskIDs, additionalDisk.Id)
+
+		// Only the disks `Create` created are recorded, so that disks that
+		// were adopted, reattached, or attached out of band are discovered
+		// ones that `Remove` keeps.
+		if trackCreated && (slices.Contains(createdDiskIDs, additionalDisk.Id) || slices.Contains(d.createdDiskNames, string(additionalDisk.Name))) {
+			d.CreatedAdditionalDiskIDs = append(d.CreatedAdditionalDiskIDs, additionalDisk.Id)
+		}
 	}
 
 	return nil
 }
 
-// DriverName returns the name of this machine driver.
-func (d *Driver) DriverName() string {
-	return "oxide"
+// removableDiskIDs returns the IDs of the disks `Remove` deletes: the boot
+// disk, the seed disk, and the additional disks the driver created, or every
+// additional disk when `DeleteAllDisks` is set.
+func (d *Driver) removableDiskIDs() []string {
+	diskIDs := []string{d.BootDiskID}
+	if d.SeedDiskID != "" {
+		diskIDs = append(diskIDs, d.SeedDiskID)
+	}
+	for _, diskID := range d.AdditionalDiskIDs {
+		if d.DeleteAllDisks || d.CreatedAdditionalDiskIDs == nil || slices.Contains(d.CreatedAdditionalDiskIDs, diskID) {
+			diskIDs = append(diskIDs, diskID)
+			continue
+		}
+		log.Infof("Keeping disk %s since it wasn't created by the driver", diskID)
+	}
+	return diskIDs
 }
 
-// GetCreateFlags configures the CLI flags for machine driver.
-func (d *Driver) GetCreateFlags() []mcnflag.Flag {
-	return []mcnflag.Flag{
-		mcnflag.StringFlag{
-			Name:   flagHost,
-			Usage:  "Oxide silo domain name (e.g., https://silo01.oxide.example.com). This is `OXIDE_HOST` when authenticating via the Oxide CLI.",
-			EnvVar: "OXIDE_HOST",
-		},
-		mcnflag.StringFlag{
-			Name:   flagToken,
-			Usage:  "Oxide API token. This is `OXIDE_TOKEN` when authenticating via the Oxide CLI.",
-			EnvVar: "OXIDE_TOKEN",
-		},
-		mcnflag.StringFlag{
-			Name:   flagProject,
-			Usage:  "Oxide project to create instances within.",
-			EnvVar: "OXIDE_PROJECT",
-		},
+// sshPublicKeyIDs returns the SSH public keys to inject into the instance: the
+// generated SSH public key and the additional SSH public keys, ordered by
+// `SSHKeyOrder`.
+func (d *Driver) sshPublicKeyIDs() []oxide.NameOrId {
+	sshPublicKeys := make([]oxide.NameOrId, 0, len(d.SSHPublicKeys)+1)
+	for _, sshPubKey := range d.SSHPublicKeys {
+		sshPublicKeys = append(sshPublicKeys, oxide.NameOrId(sshPubKey))
+	}
 
-		// Instance hardware.
-		mcnflag.IntFlag{
-			Name:   flagVCPUs,
-			Usage:  "Number of vCPUs to give the instance.",
-			EnvVar: "OXIDE_VCPUS",
-			Value:  2,
-		},
-		mcnflag.StringFlag{
-			Name:   flagMemory,
-			Usage:  "Amount of memory, in bytes, to give the instance. Supports a unit suffix (e.g., 4 GiB).",
-			EnvVar: "OXIDE_MEMORY",
-			Value:  defaultMemory,
-		},
+	if d.SSHKeyOrder == sshKeyOrderAdditionalFirst {
+		return append(sshPublicKeys, oxide.NameOrId(d.SSHPublicKeyID))
+	}
+	return slices.Insert(sshPublicKeys, 0, oxide.NameOrId(d.SSHPublicKeyID))
+}
 
-		// Boot disk.
-		mcnflag.StringFlag{
-			Name:   flagBootDiskSize,
-			Usage:  "Size of the instance's boot disk, in bytes. Supports a unit suffix (e.g., 20 GiB).",
-			EnvVar: "OXIDE_BOOT_DISK_SIZE",
-			Value:  defaultBootDiskSize,
-		},
-		mcnflag.StringFlag{
-			Name:   flagBootDiskImageID,
-			Usage:  "Image ID to use for the instance's boot disk.",
-			EnvVar: "OXIDE_BOOT_DISK_IMAGE_ID",
-		},
+// readUserData reads the user data for the instance from `UserDataFile`, if
+// set. The DNS configuration, console logging, and the generated SSH public key
+// are merged into the user data when configured.
+func (d *Driver) readUserData() ([]byte, error) {
+	var userData []byte
+	if d.UserDataFile == userDataFileStdin {
+		b, err := d.readStdinUserData()
+		if err != nil {
+			return nil, err
+		}
+		userData = b
+	} else if d.UserDataFile != "" {
+		b, err := os.ReadFile(d.UserDataFile)
+		if err != nil {
+			return nil, err
+		}
+		userData = b
+	}
 
-		// Additional disks.
-		mcnflag.StringSliceFlag{
-			Name:  flagAdditionalDisk,
-			Usage: "Additional disks to attach to the instance in the format `SIZE[,LABEL]` where `SIZE` is the disk size in bytes and `LABEL` is an arbitrary string used within the disk name for identification. `SIZE` supports a unit suffix (e.g., 20 GiB).",
-		},
+	if len(d.DNSServers) > 0 || len(d.DNSSearch) > 0 {
+		b, err := injectResolvConf(userData, d.DNSServers, d.DNSSearch)
+		if err != nil {
+			return nil, err
+		}
+		userData = b
+	}
 
-		// Networking.
-		mcnflag.StringFlag{
-			Name:   flagVPC,
-			Usage:  "VPC name for the instance's network interface.",
-			EnvVar: "OXIDE_VPC",
-			Value:  "default",
-		},
-		mcnflag.StringFlag{
-			Name:   flagSubnet,
-			Usage:  "Subnet name for the instance's network interface.",
-			EnvVar: "OXIDE_SUBNET",
-			Value:  "default",
-		},
-		mcnflag.BoolFlag{
-			Name:   flagEphemeralIPAttach,
-			Usage:  "Should an ephemeral IP address be allocated for the instance.",
-			EnvVar: "OXIDE_EPHEMERAL_IP_ATTACH",
-		},
-		mcnflag.StringFlag{
-			Name:   flagEphemeralIPPool,
-			Usage:  "ephemeral IP pool that should be used for the instance or empty string to use the default (only used if oxide-ephemeral-ip is true)",
-			EnvVar: "OXIDE_EPHEMERAL_IP_POOL",
-			Value:  "",
-		},
+	if d.StaticIP != "" {
+		b, err := injectNetworkConfig(userData, d.StaticIP, d.Gateway, d.DNSServers, d.DNSSearch)
+		if err != nil {
+			return nil, err
+		}
+		userData = b
+	}
 
-		// User data.
-		mcnflag.StringFlag{
-			Name:   flagUserDataFile,
-			Usage:  "Path to file containing user data for the instance.",
-			EnvVar: "OXIDE_USER_DATA_FILE",
-		},
+	if d.EnableConsoleLogging {
+		b, err := injectConsoleLogging(userData)
+		if err != nil {
+			return nil, err
+		}
+		userData = b
+	}
 
-		// SSH information.
-		mcnflag.StringFlag{
-			Name:   flagSSHUser,
-			Usage:  "User to use when connecting to the instance via SSH.",
-			EnvVar: "OXIDE_SSH_USER",
+	if d.InjectKeyViaUserData {
+		publicKey, err := os.ReadFile(d.GetSSHKeyPath() + ".pub")
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := injectSSHAuthorizedKey(userData, strings.TrimSpace(string(publicKey)))
+		if err != nil {
+			return nil, err
+		}
+		userData = b
+	}
+
+	return userData, nil
+}
+
+// ipv4Assignment returns how the network interface is assigned its IPv4
+// address: `StaticIP` when set, otherwise automatically.
+func (d *Driver) ipv4Assignment() oxide.Ipv4Assignment {
+	if d.StaticIP != "" {
+		ip, _, _ := net.ParseCIDR(d.StaticIP)
+		return oxide.Ipv4Assignment{
+			Value: &oxide.Ipv4AssignmentExplicit{Value: ip.String()},
+		}
+	}
+
+	return oxide.Ipv4Assignment{
+		Value: &oxide.Ipv4AssignmentAuto{},
+	}
+}
+
+// interfaceAddrs lists the addresses of the host the driver runs on. It's a
+// variable so tests can substitute the host's addresses.
+var interfaceAddrs = net.InterfaceAddrs
+
+// checkIPv6Reachable verifies that the host the driver runs on, which is where
+// Rancher connects to the instance from, has a global IPv6 address to reach an
+// instance that only has an IPv6 address.
+func checkIPv6Reachable() error {
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		return fmt.Errorf("failed listing host addresses: %w", err)
+	}
+
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() == nil && ipNet.IP.IsGlobalUnicast() {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s %q requires a global IPv6 address on this host to connect to the instance", flagIPFamily, ipFamilyIPv6)
+}
+
+// privateIPStackCreate returns the IP stack to create the network interface
+// with for `IPFamily`. The IPv6 address is always assigned automatically.
+func (d *Driver) privateIPStackCreate(transitIPs []oxide.Ipv4Net) oxide.PrivateIpStackCreate {
+	v4 := oxide.PrivateIpv4StackCreate{
+		Ip:         d.ipv4Assignment(),
+		TransitIps: transitIPs,
+	}
+	v6 := oxide.PrivateIpv6StackCreate{
+		Ip: oxide.Ipv6Assignment{Value: &oxide.Ipv6AssignmentAuto{}},
+	}
+
+	switch d.IPFamily {
+	case ipFamilyIPv6:
+		return oxide.PrivateIpStackCreate{
+			Value: &oxide.PrivateIpStackCreateV6{Value: v6},
+		}
+	case ipFamilyDual:
+		return oxide.PrivateIpStackCreate{
+			Value: &oxide.PrivateIpStackCreateDualStack{
+				Value: oxide.PrivateIpStackCreateDualStackValue{V4: v4, V6: v6},
+			},
+		}
+	default:
+		return oxide.PrivateIpStackCreate{
+			Value: &oxide.PrivateIpStackCreateV4{Value: v4},
+		}
+	}
+}
+
+// updateIPAddresses fetches the internal and external IP addresses of the
+// instance and updates the IP address used to connect to it.
+func (d *Driver) updateIPAddresses(ctx context.Context) error {
+	internalIP, err := d.waitForInternalIPAddress(ctx)
+	if err != nil {
+		return err
+	}
+	d.InternalIPAddress = internalIP
+
+	if d.EphemeralIPAttach || len(d.ExternalIPs) > 0 {
+		externalIPs, err := d.oxideClient.InstanceExternalIpList(ctx, oxide.InstanceExternalIpListParams{
+			Instance: oxide.NameOrId(d.InstanceID),
+		})
+		if err != nil {
+			return fmt.Errorf("failed listing external IPs for instance: %w", err)
+		}
+
+		// A floating IP was chosen deliberately, so it's preferred over an
+		// ephemeral IP when connecting to the instance.
+		var floatingIPAddress, ephemeralIPAddress string
+		d.FloatingIPIDs = make([]string, 0)
+		for _, externalIP := range externalIPs.Items {
+			if floatingIP, ok := floatingExternalIP(externalIP); ok {
+				d.FloatingIPIDs = append(d.FloatingIPIDs, floatingIP.Id)
+				if floatingIPAddress == "" {
+					floatingIPAddress = floatingIP.Ip
+				}
+			} else if ip := externalIPAddress(externalIP); ip != "" && ephemeralIPAddress == "" {
+				ephemeralIPAddress = ip
+			}
+		}
+		d.ExternalIPAddress = cmp.Or(floatingIPAddress, ephemeralIPAddress)
+	}
+
+	ip, err := d.GetIP()
+	if err != nil {
+		return err
+	}
+	d.IPAddress = ip
+
+	return nil
+
+}
+
+// internalIPAddress lists the instance's network interfaces and returns the
+// address of the one used to reach the instance, which may be empty when it
+// has not been assigned yet.
+func (d *Driver) internalIPAddress(ctx context.Context) (string, error) {
+	inilp := oxide.InstanceNetworkInterfaceListParams{
+		Instance: oxide.NameOrId(d.InstanceID),
+	}
+	networkInterfaces, err := d.oxideClient.InstanceNetworkInterfaceListAllPages(ctx, inilp)
+	if err != nil {
+		return "", err
+	}
+
+	if len(networkInterfaces) == 0 {
+		return "", errors.New("no valid network interfaces found")
+	}
+
+	nic, err := d.reachableNetworkInterface(networkInterfaces)
+	if err != nil {
+		return "", err
+	}
+	return nicIPAddress(nic.IpStack, d.IPFamily)
+}
+
+// reachableNetworkInterface returns the network interface used to reach the
+// instance: the one named by `PrimaryNIC` when set, otherwise the one the
+// driver created. Instances whose network interface wasn't created under the
+// current naming (e.g., adopted instances) fall back to the primary network
+// interface.
+func (d *Driver) reachableNetworkInterface(networkInterfaces []oxide.InstanceNetworkInterface) (oxide.InstanceNetworkInterface, error) {
+	name := d.PrimaryNIC
+	if name == "" && d.InstanceName != "" {
+		name = derivedName("nic-", d.InstanceName)
+	}
+
+	names := make([]string, len(networkInterfaces))
+	for i, nic := range networkInterfaces {
+		if string(nic.Name) == name {
+			return nic, nil
+		}
+		names[i] = string(nic.Name)
+	}
+
+	if d.PrimaryNIC != "" {
+		return oxide.InstanceNetworkInterface{}, fmt.Errorf("network interface %q not found, available network interfaces: %s", d.PrimaryNIC, strings.Join(names, ", "))
+	}
+	return primaryNetworkInterface(networkInterfaces), nil
+}
+
+// nicIPAddress returns the address of stack that the instance is reached at
+// for the IP family: the IPv6 address for `ipv6`, otherwise the IPv4 address,
+// which is preferred for `dual` since it's the most likely to be reachable.
+func nicIPAddress(stack oxide.PrivateIpStack, ipFamily string) (string, error) {
+	var v4, v6 *string
+	switch v := stack.Value.(type) {
+	case oxide.PrivateIpStackV4:
+		v4 = &v.Value.Ip
+	case *oxide.PrivateIpStackV4:
+		v4 = &v.Value.Ip
+	case oxide.PrivateIpStackV6:
+		v6 = &v.Value.Ip
+	case *oxide.PrivateIpStackV6:
+		v6 = &v.Value.Ip
+	case oxide.PrivateIpStackDualStack:
+		v4, v6 = &v.Value.V4.Ip, &v.Value.V6.Ip
+	case *oxide.PrivateIpStackDualStack:
+		v4, v6 = &v.Value.V4.Ip, &v.Value.V6.Ip
+	}
+
+	if ipFamily == ipFamilyIPv6 {
+		if v6 == nil {
+			return "", errors.New("no IPv6 address found on network interface")
+		}
+		return *v6, nil
+	}
+
+	if v4 == nil {
+		return "", errors.New("no IPv4 address found on network interface")
+	}
+	return *v4, nil
+}
+
+// primaryNetworkInterface returns the network interface marked as primary,
+// falling back to the first network interface when none is marked.
+func primaryNetworkInterface(networkInterfaces []oxide.InstanceNetworkInterface) oxide.InstanceNetworkInterface {
+	for _, nic := range networkInterfaces {
+		if nic.Primary != nil && *nic.Primary {
+			return nic
+		}
+	}
+	return networkInterfaces[0]
+}
+
+// createInstance creates the instance named after the machine, with a name
+// suffix when `NameSuffixAlways` is set. When `NameConflictRetry` is set and
+// the derived names already exist, creation is retried a bounded number of
+// times with a new name suffix. The name that was ultimately used is recorded
+// in `InstanceName`.
+func (d *Driver) createInstance(ctx context.Context, sshPublicKeys []oxide.NameOrId, userData []byte) (*oxide.Instance, error) {
+	name := d.baseInstanceName()
+	if d.NameSuffixAlways {
+		var err error
+		if name, err = d.suffixedInstanceName(); err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		instance, err := d.createInstanceNamed(ctx, name, sshPublicKeys, userData)
+		if err == nil {
+			d.InstanceName = name
+			return instance, nil
+		}
+
+		if !d.NameConflictRetry || d.NameSuffixStrategy == nameSuffixStrategyNone || attempt >= nameConflictRetries || !(isObjectAlreadyExists(err) || errors.Is(err, errAdditionalDisksExist) || errors.Is(err, errInstanceNotOwned)) {
+			return nil, err
+		}
+
+		suffixedName, suffixErr := d.suffixedInstanceName()
+		if suffixErr != nil {
+			return nil, errors.Join(err, suffixErr)
+		}
+		log.Infof("Instance name %q conflicts with an existing resource, retrying as %q", name, suffixedName)
+		name = suffixedName
+	}
+}
+
+// validateDerivedNames reports whether the names of the resources created for
+// the instance, derived from the base instance name, are valid Oxide resource
+// names.
+func (d *Driver) validateDerivedNames() error {
+	name := d.baseInstanceName()
+	names := []string{name, derivedName("disk-", name), derivedName("nic-", name)}
+	for i, additionalDisk := range d.AdditionalDisks {
+		names = append(names, additionalDisk.Name(name, i))
+	}
+	if d.UserDataAsSeedISO {
+		names = append(names, d.seedDiskName())
+	}
+
+	var validateErr error
+	for _, name := range names {
+		validateErr = errors.Join(validateErr, validateName(name))
+	}
+	return validateErr
+}
+
+// suffixedInstanceName returns the base instance name with a suffix generated
+// by `NameSuffixStrategy`, shortening the base name so the result fits within
+// an Oxide resource name. The base name is returned as is for `none`.
+func (d *Driver) suffixedInstanceName() (string, error) {
+	var suffix string
+	switch d.NameSuffixStrategy {
+	case nameSuffixStrategyNone:
+		return d.baseInstanceName(), nil
+	case nameSuffixStrategyTimestamp:
+		suffix = timestampNameSuffix(time.Now())
+	default:
+		var err error
+		if suffix, err = randomNameSuffix(); err != nil {
+			return "", err
+		}
+	}
+	return suffixName(d.baseInstanceName(), suffix), nil
+}
+
+// createInstanceNamed creates the instance with names derived from name. The
+// additional disk names are checked beforehand so that disks left behind by a
+// failed run are reported clearly, or attached rather than created when
+// `AdoptExistingDisks` is set.
+func (d *Driver) createInstanceNamed(ctx context.Context, name string, sshPublicKeys []oxide.NameOrId, userData []byte) (*oxide.Instance, error) {
+	icp := d.instanceCreateParams(name, sshPublicKeys, userData)
+
+	existing, err := d.existingAdditionalDiskNames(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(existing) > 0 && !d.AdoptExistingDisks {
+		return nil, fmt.Errorf("%w in project %q: %s; delete them or set --%s", errAdditionalDisksExist, d.Project, strings.Join(existing, ", "), flagAdoptExistingDisks)
+	}
+
+	for i, additionalDisk := range d.AdditionalDisks {
+		diskName := additionalDisk.Name(name, i)
+		if slices.Contains(existing, diskName) {
+			log.Infof("Adopting existing disk %s", diskName)
+			icp.Body.Disks[i] = oxide.InstanceDiskAttachment{
+				Value: &oxide.InstanceDiskAttachmentAttach{Name: oxide.Name(diskName)},
+			}
+		}
+	}
+
+	// The disks still to be created after adopting the existing ones are
+	// created either beforehand or by the instance create.
+	createdDiskNames := make([]string, 0, len(icp.Body.Disks))
+	for _, attachment := range icp.Body.Disks {
+		if diskCreate, ok := attachment.Value.(*oxide.InstanceDiskAttachmentCreate); ok {
+			createdDiskNames = append(createdDiskNames, string(diskCreate.Name))
+		}
+	}
+
+	var createdDiskIDs []string
+	if d.DiskCreateConcurrency > 0 {
+		createdDiskIDs, err = d.createDisks(ctx, icp.Body.Disks)
+		if err != nil {
+			if d.AdditionalDiskFailurePolicy != additionalDiskFailurePolicyWarn {
+				return nil, errors.Join(err, d.deleteDisks(ctx, createdDiskIDs))
+			}
+
+			log.Warnf("Creating the instance without the additional disks that failed to be created: %v", err)
+			icp.Body.Disks = slices.DeleteFunc(icp.Body.Disks, func(attachment oxide.InstanceDiskAttachment) bool {
+				_, notCreated := attachment.Value.(*oxide.InstanceDiskAttachmentCreate)
+				return notCreated
+			})
+		}
+	}
+
+	// The disks created beforehand would otherwise be left behind, including
+	// when the instance name conflicts and `createInstance` retries under
+	// another name.
+	instance, err := d.instanceCreate(ctx, icp)
+	if err != nil {
+		return nil, errors.Join(err, d.deleteDisks(ctx, createdDiskIDs))
+	}
+
+	d.createdDiskNames = createdDiskNames
+
+	return instance, nil
+}
+
+// createDisks creates the disks in attachments that are to be created using up
+// to `DiskCreateConcurrency` concurrent requests, replacing each with an
+// attachment by name. Every disk is attempted and errors are returned together
+// with the IDs of the disks that were created, so that the caller can decide
+// whether to roll them back.
+func (d *Driver) createDisks(ctx context.Context, attachments []oxide.InstanceDiskAttachment) ([]string, error) {
+	sem := make(chan struct{}, max(d.DiskCreateConcurrency, 1))
+	errs := make([]error, len(attachments))
+	diskIDs := make([]string, len(attachments))
+
+	var wg sync.WaitGroup
+	for i, attachment := range attachments {
+		diskCreate, ok := attachment.Value.(*oxide.InstanceDiskAttachmentCreate)
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			disk, err := d.oxideClient.DiskCreate(ctx, oxide.DiskCreateParams{
+				Project: d.projectRef(),
+				Body: &oxide.DiskCreate{
+					Description: diskCreate.Description,
+					DiskBackend: diskCreate.DiskBackend,
+					Name:        diskCreate.Name,
+					Size:        diskCreate.Size,
+				},
+			})
+			if err != nil {
+				errs[i] = fmt.Errorf("failed creating disk %s: %w", diskCreate.Name, err)
+				return
+			}
+
+			log.Infof("Created disk %s (%s)", diskCreate.Name, disk.Id)
+			diskIDs[i] = disk.Id
+			attachments[i] = oxide.InstanceDiskAttachment{
+				Value: &oxide.InstanceDiskAttachmentAttach{Name: diskCreate.Name},
+			}
+		}()
+	}
+	wg.Wait()
+
+	return slices.DeleteFunc(diskIDs, func(diskID string) bool { return diskID == "" }), errors.Join(errs...)
+}
+
+// instanceCreate creates the instance from icp, waiting for a create slot and
+// dumping the request and response when debugging is enabled. When the outcome
+// of a create is ambiguous (e.g., the connection dropped before the response
+// arrived), the instance is looked up by name and adopted if it was created, or
+// the create is retried otherwise, so that a duplicate is never created. An
+// instance with the same name that was created before the first attempt is
+// someone else's and is reported as a conflict rather than adopted.
+func (d *Driver) instanceCreate(ctx context.Context, icp oxide.InstanceCreateParams) (*oxide.Instance, error) {
+	started := time.Now()
+	for attempt := 0; ; attempt++ {
+		var instance *oxide.Instance
+		err := withCreateSlot(func() (err error) {
+			instance, err = d.oxideClient.InstanceCreate(ctx, icp)
+			return err
+		})
+		d.dumpInstanceCreate(icp, instance, err)
+
+		if err == nil || !isAmbiguousRequestError(err) {
+			return instance, err
+		}
+
+		instance, viewErr := d.oxideClient.InstanceView(ctx, oxide.InstanceViewParams{
+			Project:  icp.Project,
+			Instance: oxide.NameOrId(icp.Body.Name),
+		})
+		if viewErr == nil {
+			if instance.TimeCreated == nil || instance.TimeCreated.Before(started) {
+				return nil, errors.Join(err, fmt.Errorf("%w: %s was created before this create started", errInstanceNotOwned, icp.Body.Name))
+			}
+			log.Infof("Instance %s was created despite the error %q, adopting it", icp.Body.Name, err)
+			return instance, nil
+		}
+		if !isNotFound(viewErr) {
+			return nil, errors.Join(err, fmt.Errorf("failed checking whether instance %s was created: %w", icp.Body.Name, viewErr))
+		}
+
+		if attempt >= ambiguousCreateRetries {
+			return nil, err
+		}
+		log.Infof("Instance %s was not created after the error %q, retrying", icp.Body.Name, err)
+	}
+}
+
+// bootDiskSource returns the source to create the boot disk from, which is
+// either the snapshot taken to clone the boot disk or the boot disk image.
+func (d *Driver) bootDiskSource() oxide.DiskSource {
+	if d.CloneSnapshotID != "" {
+		return oxide.DiskSource{
+			Value: &oxide.DiskSourceSnapshot{
+				SnapshotId: d.CloneSnapshotID,
+			},
+		}
+	}
+
+	return oxide.DiskSource{
+		Value: &oxide.DiskSourceImage{
+			ImageId: d.BootDiskImageID,
 		},
-		mcnflag.StringSliceFlag{
-			Name:   flagSSHPublicKey,
-			Usage:  "Additional SSH public keys IDs to inject into the instance.",
-			EnvVar: "OXIDE_ADDITIONAL_SSH_PUBLIC_KEY_IDS",
+	}
+}
+
+// createCloneSnapshot snapshots `CloneBootDiskFrom` to create the boot disk
+// from and waits for the snapshot to be ready. The snapshot is recorded in
+// `CloneSnapshotID` so it can be cleaned up.
+func (d *Driver) createCloneSnapshot(ctx context.Context) error {
+	snapshot, err := d.oxideClient.SnapshotCreate(ctx, oxide.SnapshotCreateParams{
+		Project: d.projectRef(),
+		Body: &oxide.SnapshotCreate{
+			Description: defaultDescription,
+			Disk:        oxide.NameOrId(d.CloneBootDiskFrom),
+			Name:        oxide.Name(derivedName("clone-", d.GetMachineName())),
 		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed snapshotting disk %q to clone: %w", d.CloneBootDiskFrom, err)
+	}
 
-		// Anti-affinity groups.
-		mcnflag.StringSliceFlag{
-			Name:  flagAntiAffinityGroup,
-			Usage: "Anti-affinity groups the instance will be a member of. The values can be IDs or names of anti-affinity groups.",
+	d.CloneSnapshotID = snapshot.Id
+
+	return d.waitForSnapshotReady(ctx)
+}
+
+// deleteCloneSnapshot deletes the snapshot taken to clone the boot disk, if
+// any. A snapshot that no longer exists is considered deleted.
+func (d *Driver) deleteCloneSnapshot(ctx context.Context) error {
+	if d.CloneSnapshotID == "" {
+		return nil
+	}
+
+	if err := d.oxideClient.SnapshotDelete(ctx, oxide.SnapshotDeleteParams{
+		Snapshot: oxide.NameOrId(d.CloneSnapshotID),
+	}); err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed deleting snapshot %s: %w", d.CloneSnapshotID, err)
+	}
+
+	d.CloneSnapshotID = ""
+	return nil
+}
+
+// seedDiskName returns the name of the seed disk for `UserDataAsSeedISO`.
+func (d *Driver) seedDiskName() string {
+	return derivedName("seed-", d.baseInstanceName())
+}
+
+// createSeedDisk creates a disk holding a NoCloud seed ISO with userData and
+// records it in `SeedDiskID`. The disk is created empty and the ISO is written
+// to it with bulk writes, after which it can be attached to the instance.
+func (d *Driver) createSeedDisk(ctx context.Context, userData []byte) error {
+	metaData := fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", d.baseInstanceName(), d.GetMachineName())
+	image, err := buildSeedISO([]seedISOFile{
+		{Name: "user-data", Content: userData},
+		{Name: "meta-data", Content: []byte(metaData)},
+	})
+	if err != nil {
+		return err
+	}
+
+	name := d.seedDiskName()
+	disk, err := d.oxideClient.DiskCreate(ctx, oxide.DiskCreateParams{
+		Project: d.projectRef(),
+		Body: &oxide.DiskCreate{
+			Description: diskDescription(defaultDescription, d.baseInstanceName()),
+			DiskBackend: oxide.DiskBackend{
+				Value: &oxide.DiskBackendDistributed{
+					DiskSource: oxide.DiskSource{
+						Value: &oxide.DiskSourceImportingBlocks{
+							BlockSize: seedDiskBlockSize,
+						},
+					},
+				},
+			},
+			Name: oxide.Name(name),
+			Size: seedDiskSize,
 		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed creating seed disk %s: %w", name, err)
+	}
+	d.SeedDiskID = disk.Id
+
+	diskID := oxide.NameOrId(disk.Id)
+	if err := d.oxideClient.DiskBulkWriteImportStart(ctx, oxide.DiskBulkWriteImportStartParams{
+		Disk: diskID,
+	}); err != nil {
+		return fmt.Errorf("failed starting write to seed disk %s: %w", name, err)
+	}
+
+	for offset := 0; offset < len(image); offset += seedDiskWriteChunkSize {
+		chunk := image[offset:min(offset+seedDiskWriteChunkSize, len(image))]
+		if err := d.oxideClient.DiskBulkWriteImport(ctx, oxide.DiskBulkWriteImportParams{
+			Disk: diskID,
+			Body: &oxide.ImportBlocksBulkWrite{
+				Base64EncodedData: base64.StdEncoding.EncodeToString(chunk),
+				Offset:            &offset,
+			},
+		}); err != nil {
+			// A disk that's still importing cannot be deleted, so the import
+			// is stopped to let `deleteSeedDisk` clean it up.
+			stopErr := d.oxideClient.DiskBulkWriteImportStop(ctx, oxide.DiskBulkWriteImportStopParams{
+				Disk: diskID,
+			})
+			if stopErr != nil {
+				stopErr = fmt.Errorf("failed stopping write to seed disk %s: %w", name, stopErr)
+			}
+			return errors.Join(fmt.Errorf("failed writing seed disk %s: %w", name, err), stopErr)
+		}
+	}
+
+	if err := d.oxideClient.DiskBulkWriteImportStop(ctx, oxide.DiskBulkWriteImportStopParams{
+		Disk: diskID,
+	}); err != nil {
+		return fmt.Errorf("failed stopping write to seed disk %s: %w", name, err)
+	}
+
+	if err := d.oxideClient.DiskFinalizeImport(ctx, oxide.DiskFinalizeImportParams{
+		Disk: diskID,
+		Body: &oxide.FinalizeDisk{},
+	}); err != nil {
+		return fmt.Errorf("failed finalizing seed disk %s: %w", name, err)
+	}
+
+	log.Infof("Created seed disk %s (%s)", name, disk.Id)
+	return nil
+}
+
+// deleteSeedDisk deletes the disk created by `createSeedDisk`, if any.
+func (d *Driver) deleteSeedDisk(ctx context.Context) error {
+	if d.SeedDiskID == "" {
+		return nil
+	}
+
+	if err := d.oxideClient.DiskDelete(ctx, oxide.DiskDeleteParams{
+		Disk: oxide.NameOrId(d.SeedDiskID),
+	}); err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed deleting seed disk %s: %w", d.SeedDiskID, err)
+	}
+
+	d.SeedDiskID = ""
+	return nil
+}
+
+// existingAdditionalDiskNames returns the additional disk names derived from
+// name that already exist in the project.
+func (d *Driver) existingAdditionalDiskNames(ctx context.Context, name string) ([]string, error) {
+	if len(d.AdditionalDisks) == 0 {
+		return nil, nil
+	}
+
+	disks, err := d.oxideClient.DiskListAllPages(ctx, oxide.DiskListParams{
+		Project: d.projectRef(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed listing disks in project %q: %w", d.Project, err)
+	}
+
+	var existing []string
+	for i, additionalDisk := range d.AdditionalDisks {
+		diskName := additionalDisk.Name(name, i)
+		if slices.ContainsFunc(disks, func(disk oxide.Disk) bool { return string(disk.Name) == diskName }) {
+			existing = append(existing, diskName)
+		}
+	}
+
+	return existing, nil
+}
+
+// newCreateSemaphore creates a semaphore sized from s. An empty s results in an
+// unbounded nil semaphore, as does an invalid s after logging a warning.
+func newCreateSemaphore(s string) chan struct{} {
+	if s == "" {
+		return nil
+	}
+
+	size, err := strconv.Atoi(s)
+	if err != nil || size <= 0 {
+		log.Warnf("Ignoring invalid %s value %q, expected a positive integer", maxConcurrentCreatesEnvVar, s)
+		return nil
+	}
+
+	return make(chan struct{}, size)
+}
+
+// withCreateSlot calls fn once a slot in `createSemaphore` is available and
+// releases the slot when fn returns.
+func withCreateSlot(fn func() error) error {
+	if createSemaphore != nil {
+		createSemaphore <- struct{}{}
+		defer func() { <-createSemaphore }()
+	}
+	return fn()
+}
+
+// ensureAntiAffinityGroup creates the `EnsureAntiAffinityGroup` anti-affinity
+// group if it doesn't already exist in the project. The ID of a created group
+// is recorded in `CreatedAntiAffinityGroupID` so that `Remove` only ever
+// deletes a group the driver created.
+func (d *Driver) ensureAntiAffinityGroup(ctx context.Context) error {
+	_, err := d.oxideClient.AntiAffinityGroupView(ctx, oxide.AntiAffinityGroupViewParams{
+		Project:           d.projectRef(),
+		AntiAffinityGroup: oxide.NameOrId(d.EnsureAntiAffinityGroup),
+	})
+	if err == nil {
+		return nil
+	}
+	if !isNotFound(err) {
+		return fmt.Errorf("failed viewing anti-affinity group %q: %w", d.EnsureAntiAffinityGroup, err)
+	}
+
+	group, err := d.oxideClient.AntiAffinityGroupCreate(ctx, oxide.AntiAffinityGroupCreateParams{
+		Project: d.projectRef(),
+		Body: &oxide.AntiAffinityGroupCreate{
+			Description:   defaultDescription,
+			FailureDomain: oxide.FailureDomainSled,
+			Name:          oxide.Name(d.EnsureAntiAffinityGroup),
+			Policy:        oxide.AffinityPolicyAllow,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed creating anti-affinity group %q: %w", d.EnsureAntiAffinityGroup, err)
+	}
+
+	d.CreatedAntiAffinityGroupID = group.Id
+
+	return nil
+}
+
+// checkGroups checks that no group is given as both an affinity and an
+// anti-affinity group and that every group exists. Every conflicting and
+// missing group is returned joined together.
+func (d *Driver) checkGroups(ctx context.Context) error {
+	var groupErr error
+	for _, affinityGroup := range d.AffinityGroups {
+		if slices.Contains(d.AntiAffinityGroups, affinityGroup) || affinityGroup == d.EnsureAntiAffinityGroup {
+			groupErr = errors.Join(groupErr, fmt.Errorf("group %q cannot be both an affinity and an anti-affinity group", affinityGroup))
+		}
+	}
+
+	for _, antiAffinityGroup := range d.AntiAffinityGroups {
+		// The ensured group is created by `Create` when it does not exist.
+		if antiAffinityGroup == d.EnsureAntiAffinityGroup {
+			continue
+		}
+
+		if _, err := d.oxideClient.AntiAffinityGroupView(ctx, oxide.AntiAffinityGroupViewParams{
+			AntiAffinityGroup: oxide.NameOrId(antiAffinityGroup),
+			Project:           d.projectRef(),
+		}); err != nil {
+			groupErr = errors.Join(groupErr, fmt.Errorf("anti-affinity group %q not found in project %q: %w", antiAffinityGroup, d.Project, err))
+		}
+	}
+
+	for _, affinityGroup := range d.AffinityGroups {
+		if _, err := d.oxideClient.ExperimentalAffinityGroupView(ctx, oxide.AffinityGroupViewParams{
+			AffinityGroup: oxide.NameOrId(affinityGroup),
+			Project:       d.projectRef(),
+		}); err != nil {
+			groupErr = errors.Join(groupErr, fmt.Errorf("affinity group %q not found in project %q: %w", affinityGroup, d.Project, err))
+		}
+	}
+
+	return groupErr
+}
+
+// addAffinityGroupMembers adds the instance to every affinity group and then
+// starts it unless it should be left stopped. `InstanceCreate` cannot add the
+// instance to affinity groups, so it's created stopped when there are any.
+func (d *Driver) addAffinityGroupMembers(ctx context.Context) error {
+	for _, affinityGroup := range d.AffinityGroups {
+		if _, err := d.oxideClient.ExperimentalAffinityGroupMemberInstanceAdd(ctx, oxide.AffinityGroupMemberInstanceAddParams{
+			AffinityGroup: oxide.NameOrId(affinityGroup),
+			Instance:      oxide.NameOrId(d.InstanceID),
+			Project:       d.projectRef(),
+		}); err != nil {
+			return fmt.Errorf("failed adding instance to affinity group %q: %w", affinityGroup, err)
+		}
+	}
+
+	if d.InitialState == initialStateStopped {
+		return nil
+	}
+
+	return d.start(ctx)
+}
+
+// removeAntiAffinityGroup deletes the anti-affinity group created by the driver
+// when it no longer has any members.
+func (d *Driver) removeAntiAffinityGroup(ctx context.Context) error {
+	members, err := d.oxideClient.AntiAffinityGroupMemberListAllPages(ctx, oxide.AntiAffinityGroupMemberListParams{
+		AntiAffinityGroup: oxide.NameOrId(d.CreatedAntiAffinityGroupID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed listing anti-affinity group members: %w", err)
+	}
+
+	if len(members) > 0 {
+		log.Infof("Keeping anti-affinity group %s since it still has %d members", d.CreatedAntiAffinityGroupID, len(members))
+		return nil
+	}
+
+	return d.oxideClient.AntiAffinityGroupDelete(ctx, oxide.AntiAffinityGroupDeleteParams{
+		AntiAffinityGroup: oxide.NameOrId(d.CreatedAntiAffinityGroupID),
+	})
+}
+
+// instanceCreateParams builds the parameters used to create the instance. The
+// instance, boot disk, additional disks, and network interface names are
+// derived from name.
+func (d *Driver) instanceCreateParams(name string, sshPublicKeys []oxide.NameOrId, userData []byte) oxide.InstanceCreateParams {
+	transitIPs := make([]oxide.Ipv4Net, len(d.TransitIPs))
+	for i, transitIP := range d.TransitIPs {
+		transitIPs[i] = oxide.Ipv4Net(transitIP)
+	}
+
+	disks := make([]oxide.InstanceDiskAttachment, len(d.AdditionalDisks))
+	for i, additionalDisk := range d.AdditionalDisks {
+		disks[i] = oxide.InstanceDiskAttachment{
+			Value: &oxide.InstanceDiskAttachmentCreate{
+				Description: diskDescription(additionalDisk.DescriptionOrDefault(), name),
+				DiskBackend: additionalDisk.diskBackend(),
+				Name:        oxide.Name(additionalDisk.Name(name, i)),
+				Size:        oxide.ByteCount(additionalDisk.Size),
+			},
+		}
+	}
+
+	// The seed disk is attached after the additional disks so that their
+	// attachment order is unchanged.
+	if d.SeedDiskID != "" {
+		disks = append(disks, oxide.InstanceDiskAttachment{
+			Value: &oxide.InstanceDiskAttachmentAttach{Name: oxide.Name(d.seedDiskName())},
+		})
+	}
+
+	antiAffinityGroups := make([]oxide.NameOrId, 0, len(d.AntiAffinityGroups)+1)
+	for _, antiAffinityGroup := range d.AntiAffinityGroups {
+		antiAffinityGroups = append(antiAffinityGroups, oxide.NameOrId(antiAffinityGroup))
+	}
+	if d.EnsureAntiAffinityGroup != "" && !slices.Contains(d.AntiAffinityGroups, d.EnsureAntiAffinityGroup) {
+		antiAffinityGroups = append(antiAffinityGroups, oxide.NameOrId(d.EnsureAntiAffinityGroup))
+	}
+
+	externalIPs := make([]oxide.ExternalIpCreate, 0, len(d.ExternalIPs)+1)
+	for _, externalIP := range d.ExternalIPs {
+		externalIPs = append(externalIPs, externalIP.externalIPCreate(d.IPFamily))
+	}
+	if d.EphemeralIPAttach {
+		externalIPs = append(externalIPs, ExternalIP{Type: externalIPTypeEphemeral, NameOrID: d.EphemeralIPPool}.externalIPCreate(d.IPFamily))
+	}
+
+	icp := oxide.InstanceCreateParams{
+		Project: d.projectRef(),
+		Body: &oxide.InstanceCreate{
+			AntiAffinityGroups: antiAffinityGroups,
+			BootDisk: oxide.InstanceDiskAttachment{
+				Value: &oxide.InstanceDiskAttachmentCreate{
+					Description: diskDescription(d.BootDiskDescription, name),
+					DiskBackend: oxide.DiskBackend{
+						Value: &oxide.DiskBackendDistributed{
+							DiskSource: d.bootDiskSource(),
+						},
+					},
+					Name: oxide.Name(derivedName("disk-", name)),
+					Size: oxide.ByteCount(d.BootDiskSize),
+				},
+			},
+			Disks:       disks,
+			Description: d.instanceDescription(),
+			ExternalIps: externalIPs,
+			Hostname:    oxide.Hostname(d.GetMachineName()),
+			Memory:      oxide.ByteCount(d.Memory),
+			Name:        oxide.Name(name),
+			Ncpus:       oxide.InstanceCpuCount(d.VCPUS),
+			NetworkInterfaces: oxide.InstanceNetworkInterfaceAttachment{
+				Value: &oxide.InstanceNetworkInterfaceAttachmentCreate{
+					Params: []oxide.InstanceNetworkInterfaceCreate{
+						{
+							Description: defaultDescription,
+							Name:        oxide.Name(derivedName("nic-", name)),
+							SubnetName:  oxide.Name(d.Subnet),
+							VpcName:     oxide.Name(d.VPC),
+							IpConfig:    d.privateIPStackCreate(transitIPs),
+						},
+					},
+				},
+			},
+			SshPublicKeys: sshPublicKeys,
+			Start:         oxide.NewPointer(d.InitialState != initialStateStopped && len(d.AffinityGroups) == 0),
+			UserData:      base64.StdEncoding.EncodeToString(userData),
+		},
+	}
+	return icp
+}
+
+// DriverName returns the name of this machine driver.
+func (d *Driver) DriverName() string {
+	return "oxide"
+}
+
+// GetCreateFlags configures the CLI flags for machine driver.
+func (d *Driver) GetCreateFlags() []mcnflag.Flag {
+	return []mcnflag.Flag{
+		mcnflag.StringFlag{
+			Name:   flagHost,
+			Usage:  "Oxide silo domain name (e.g., https://silo01.oxide.example.com). This is `OXIDE_HOST` when authenticating via the Oxide CLI.",
+			EnvVar: "OXIDE_HOST",
+		},
+		mcnflag.StringFlag{
+			Name:   flagSilo,
+			Usage:  "Oxide silo shortname (e.g., silo01). Used with --oxide-domain to construct the silo domain name when --oxide-host is not given.",
+			EnvVar: "OXIDE_SILO",
+		},
+		mcnflag.StringFlag{
+			Name:   flagDomain,
+			Usage:  "DNS suffix appended to --oxide-silo to construct the silo domain name (e.g., oxide.example.com).",
+			EnvVar: "OXIDE_DOMAIN",
+		},
+		mcnflag.StringFlag{
+			Name:   flagToken,
+			Usage:  "Oxide API token. This is `OXIDE_TOKEN` when authenticating via the Oxide CLI.",
+			EnvVar: "OXIDE_TOKEN",
+		},
+		mcnflag.StringFlag{
+			Name:   flagTokenFile,
+			Usage:  "Path to a file containing the Oxide API token, used when --oxide-token is not given. The token is reloaded from this file when it is rejected as unauthorized.",
+			EnvVar: "OXIDE_TOKEN_FILE",
+		},
+		mcnflag.StringFlag{
+			Name:   flagProject,
+			Usage:  "Oxide project to create instances within.",
+			EnvVar: "OXIDE_PROJECT",
+		},
+		mcnflag.StringFlag{
+			Name:   flagProjectTemplate,
+			Usage:  "Template used to derive the Oxide project from the Rancher cluster name in `RANCHER_CLUSTER_NAME` (e.g., k8s-{{.ClusterName}}). Mutually exclusive with --oxide-project.",
+			EnvVar: "OXIDE_PROJECT_TEMPLATE",
+		},
+		mcnflag.StringFlag{
+			Name:   flagResourceReferences,
+			Usage:  "How the project, VPC, subnet, and image references are given. One of `auto`, which treats UUIDs as IDs and anything else as names, `name`, or `id`. References of the other kind are rejected rather than looked up.",
+			EnvVar: "OXIDE_RESOURCE_REFERENCES",
+			Value:  resourceReferencesAuto,
+		},
+
+		// Instance hardware.
+		mcnflag.IntFlag{
+			Name:   flagVCPUs,
+			Usage:  "Number of vCPUs to give the instance.",
+			EnvVar: "OXIDE_VCPUS",
+			Value:  2,
+		},
+		mcnflag.StringFlag{
+			Name:   flagMemory,
+			Usage:  "Amount of memory, in bytes, to give the instance. Supports a unit suffix (e.g., 4 GiB).",
+			EnvVar: "OXIDE_MEMORY",
+			Value:  defaultMemory,
+		},
+
+		// Instance description.
+		mcnflag.StringFlag{
+			Name:   flagInstanceDescription,
+			Usage:  "Description of the instance.",
+			EnvVar: "OXIDE_INSTANCE_DESCRIPTION",
+			Value:  defaultDescription,
+		},
+		mcnflag.StringFlag{
+			Name:   flagLabels,
+			Usage:  `Labels to append to the instance description as a JSON object of strings (e.g., {"team":"infra"}).`,
+			EnvVar: "OXIDE_LABELS",
+		},
+
+		// Boot disk.
+		mcnflag.StringFlag{
+			Name:   flagBootDiskSize,
+			Usage:  "Size of the instance's boot disk, in bytes. Supports a unit suffix (e.g., 20 GiB). Use `auto` to size the boot disk to fit its image, or prefix the size with `+` to size the boot disk to fit its image plus the size (e.g., +10 GiB).",
+			EnvVar: "OXIDE_BOOT_DISK_SIZE",
+			Value:  defaultBootDiskSize,
+		},
+		mcnflag.StringFlag{
+			Name:   flagBootDiskImageID,
+			Usage:  "Image name or ID to use for the instance's boot disk. A name is looked up among the project's images and the silo's images. May be a Go text/template referencing environment variables with `env` (e.g., `ubuntu-{{env \"IMAGE_RELEASE\"}}`) so node pools map to different images.",
+			EnvVar: "OXIDE_BOOT_DISK_IMAGE_ID",
+		},
+		mcnflag.StringFlag{
+			Name:   flagCloneBootDiskFrom,
+			Usage:  "Name or ID of an existing disk in the project to clone the instance's boot disk from, via a snapshot, instead of an image.",
+			EnvVar: "OXIDE_CLONE_BOOT_DISK_FROM",
+		},
+		mcnflag.BoolFlag{
+			Name:   flagCloneKeepSnapshot,
+			Usage:  "Should the snapshot taken to clone the boot disk be kept after creating the instance. Kept snapshots are deleted when the machine is removed.",
+			EnvVar: "OXIDE_CLONE_KEEP_SNAPSHOT",
+		},
+		mcnflag.StringFlag{
+			Name:   flagBootDiskDescription,
+			Usage:  "Description of the instance's boot disk.",
+			EnvVar: "OXIDE_BOOT_DISK_DESCRIPTION",
+			Value:  defaultDescription,
+		},
+
+		// Additional disks.
+		mcnflag.StringSliceFlag{
+			Name:  flagAdditionalDisk,
+			Usage: "Additional disks to attach to the instance in the format `[image,IMAGE_ID,][backend,BACKEND,]SIZE[,LABEL[,DESCRIPTION]]` where `IMAGE_ID` is the ID of an image to create the disk from, `BACKEND` is `distributed` (the default) to replicate the disk across sleds or `local` to store the blank disk on the instance's sled for lower latency, `SIZE` is the disk size in bytes, `LABEL` is an arbitrary string used within the disk name for identification, and `DESCRIPTION` is the disk description. `SIZE` supports a unit suffix (e.g., 20 GiB). Disks without an image are blank.",
+		},
+		mcnflag.BoolFlag{
+			Name:   flagAdoptExistingDisks,
+			Usage:  "Should additional disks whose names already exist in the project (e.g., left behind by a failed run) be attached to the instance rather than failing creation.",
+			EnvVar: "OXIDE_ADOPT_EXISTING_DISKS",
+		},
+		mcnflag.IntFlag{
+			Name:   flagDiskCreateConcurrency,
+			Usage:  "Maximum number of additional disks to create concurrently before creating the instance. Zero creates them as part of creating the instance.",
+			EnvVar: "OXIDE_DISK_CREATE_CONCURRENCY",
+		},
+		mcnflag.StringFlag{
+			Name:   flagAdditionalDiskFailurePolicy,
+			Usage:  "What to do when creating an additional disk before the instance fails. One of `fail`, which deletes the disks that were created and fails creation, or `warn`, which creates the instance without the disks that failed.",
+			EnvVar: "OXIDE_ADDITIONAL_DISK_FAILURE_POLICY",
+			Value:  additionalDiskFailurePolicyFail,
+		},
+
+		// Networking.
+		mcnflag.StringFlag{
+			Name:   flagVPC,
+			Usage:  "VPC name for the instance's network interface.",
+			EnvVar: "OXIDE_VPC",
+			Value:  "default",
+		},
+		mcnflag.StringFlag{
+			Name:   flagSubnet,
+			Usage:  "Subnet name for the instance's network interface.",
+			EnvVar: "OXIDE_SUBNET",
+			Value:  "default",
+		},
+		mcnflag.BoolFlag{
+			Name:   flagEphemeralIPAttach,
+			Usage:  "Should an ephemeral IP address be allocated for the instance.",
+			EnvVar: "OXIDE_EPHEMERAL_IP_ATTACH",
+		},
+		mcnflag.StringFlag{
+			Name:   flagEphemeralIPPool,
+			Usage:  "ephemeral IP pool that should be used for the instance or empty string to use the default (only used if oxide-ephemeral-ip is true)",
+			EnvVar: "OXIDE_EPHEMERAL_IP_POOL",
+			Value:  "",
+		},
+		mcnflag.StringSliceFlag{
+			Name:  flagExternalIP,
+			Usage: "External IPs to attach to the instance in the format `floating,NAME_OR_ID` where `NAME_OR_ID` is the name or ID of a floating IP in the project, or `ephemeral[,POOL]` where `POOL` is the name or ID of the IP pool to allocate an ephemeral IP from (the default pool when omitted). Floating IPs are detached, not deleted, when the machine is removed; ephemeral IPs are released with the instance.",
+		},
+		mcnflag.StringFlag{
+			Name:   flagPrimaryNIC,
+			Usage:  "Name of the network interface whose IP address is used to reach the instance when it has several. Defaults to the network interface the driver creates (`nic-` followed by the instance name).",
+			EnvVar: "OXIDE_PRIMARY_NIC",
+		},
+		mcnflag.StringSliceFlag{
+			Name:  flagTransitIPs,
+			Usage: "Additional IPv4 networks, in CIDR notation, that the instance's network interface may send and receive traffic on (e.g., 10.42.0.0/16). Required for instances that forward traffic such as pod networks.",
+		},
+		mcnflag.StringFlag{
+			Name:   flagIPPreference,
+			Usage:  "Which IP address Rancher should use to connect to the instance when it has both an internal and an external IP address. One of `external` or `internal`.",
+			EnvVar: "OXIDE_IP_PREFERENCE",
+			Value:  ipPreferenceExternal,
+		},
+
+		// Instance lifecycle.
+		mcnflag.StringFlag{
+			Name:   flagInitialState,
+			Usage:  "Which state to leave the instance in after creating it. One of `running` or `stopped`. Rancher requires `running` to provision the machine; `stopped` is only useful when the machine is started later by other means.",
+			EnvVar: "OXIDE_INITIAL_STATE",
+			Value:  initialStateRunning,
+		},
+		mcnflag.StringFlag{
+			Name:   flagCreateInitialDelay,
+			Usage:  "How long to wait after creating a running instance before its state is first checked (e.g., 3s). Use 0 to not wait.",
+			EnvVar: "OXIDE_CREATE_INITIAL_DELAY",
+			Value:  defaultCreateInitialDelay.String(),
+		},
+		mcnflag.BoolFlag{
+			Name:   flagPreserveOnFailure,
+			Usage:  "Should the instance and its disks be kept for debugging when creating the machine fails after the instance was created, rather than being deleted when the machine is removed.",
+			EnvVar: "OXIDE_PRESERVE_ON_FAILURE",
+		},
+		mcnflag.BoolFlag{
+			Name:   flagWaitOnStart,
+			Usage:  "Should starting the instance wait for it to be running before returning.",
+			EnvVar: "OXIDE_WAIT_ON_START",
+		},
+		mcnflag.IntFlag{
+			Name:   flagStartMaxRetries,
+			Usage:  "Maximum number of times to retry starting the instance, with backoff, when it fails with a transient error. Use 0 to not retry.",
+			EnvVar: "OXIDE_START_MAX_RETRIES",
+			Value:  defaultStartMaxRetries,
+		},
+		mcnflag.StringFlag{
+			Name:   flagStopPollInterval,
+			Usage:  "Initial interval between instance state checks while waiting for the instance to stop during removal (e.g., 1s). The interval doubles after every check up to oxide-stop-poll-max-interval.",
+			EnvVar: "OXIDE_STOP_POLL_INTERVAL",
+			Value:  defaultStopPollInterval.String(),
+		},
+		mcnflag.StringFlag{
+			Name:   flagStopPollMaxInterval,
+			Usage:  "Maximum interval between instance state checks while waiting for the instance to stop during removal (e.g., 15s).",
+			EnvVar: "OXIDE_STOP_POLL_MAX_INTERVAL",
+			Value:  defaultStopPollMaxInterval.String(),
+		},
+		mcnflag.StringFlag{
+			Name:   flagStopReissueAfter,
+			Usage:  "How long the instance may be stopping during removal before the stop is re-issued (e.g., 30s). Use 0 to never re-issue the stop.",
+			EnvVar: "OXIDE_STOP_REISSUE_AFTER",
+			Value:  defaultStopReissueAfter.String(),
+		},
+		mcnflag.StringFlag{
+			Name:   flagRemoveTimeout,
+			Usage:  "How long to wait for the instance to stop during removal before giving up (e.g., 5m).",
+			EnvVar: "OXIDE_REMOVE_TIMEOUT",
+			Value:  defaultRemoveTimeout.String(),
+		},
+		mcnflag.StringFlag{
+			Name:   flagOperationTimeout,
+			Usage:  "How long creating or removing the instance may take as a whole, bounding every API call and wait within it (e.g., 15m).",
+			EnvVar: "OXIDE_OPERATION_TIMEOUT",
+		},
+		mcnflag.BoolFlag{
+			Name:   flagWaitForDiskDeletion,
+			Usage:  "Should removal wait for each deleted disk to no longer exist before continuing.",
+			EnvVar: "OXIDE_WAIT_FOR_DISK_DELETION",
+		},
+		mcnflag.BoolFlag{
+			Name:   flagVerifyRemove,
+			Usage:  "Should removal verify that the instance, its disks, and the generated SSH public key no longer exist, failing if any still do.",
+			EnvVar: "OXIDE_VERIFY_REMOVE",
+		},
+		mcnflag.BoolFlag{
+			Name:   flagDeleteAllDisks,
+			Usage:  "Should removal delete every disk attached to the instance, including disks attached outside the driver, rather than only the disks the driver created.",
+			EnvVar: "OXIDE_DELETE_ALL_DISKS",
+		},
+		mcnflag.IntFlag{
+			Name:   flagDiskDeleteConcurrency,
+			Usage:  "Maximum number of disks to delete concurrently during removal.",
+			EnvVar: "OXIDE_DISK_DELETE_CONCURRENCY",
+			Value:  defaultDiskDeleteConcurrency,
+		},
+		mcnflag.StringFlag{
+			Name:   flagNICIPWait,
+			Usage:  "How long to wait for the instance network interface to be assigned an IP address after creating the instance (e.g., 30s).",
+			EnvVar: "OXIDE_NIC_IP_WAIT",
+		},
+
+		// Pre-create checks.
+		mcnflag.StringFlag{
+			Name:   flagPreCreateWait,
+			Usage:  "How long to wait for the project, VPC, subnet, and boot disk image to appear when they are not found before creating the instance (e.g., 30s). Useful when they are created or imported concurrently with the machine.",
+			EnvVar: "OXIDE_PRECREATE_WAIT",
+		},
+		mcnflag.BoolFlag{
+			Name:   flagSkipPreCreateValidation,
+			Usage:  "Should the checks that the project, VPC, subnet, and other resources exist and are usable be skipped before creating the instance. Saves API calls when provisioning into a known-good environment, but a missing or unusable resource then fails partway through creation, after the SSH key is uploaded.",
+			EnvVar: "OXIDE_SKIP_PRECREATE_VALIDATION",
+		},
+		mcnflag.BoolFlag{
+			Name:   flagRespectMaintenance,
+			Usage:  "Should the Oxide API be checked for availability before creating the instance, failing fast when it's unavailable (e.g., during a maintenance window). Adds an API call to every creation.",
+			EnvVar: "OXIDE_RESPECT_MAINTENANCE",
+		},
+
+		// User data.
+		mcnflag.StringFlag{
+			Name:   flagUserDataFile,
+			Usage:  "Path to file containing user data for the instance. Use `-` to read the user data piped to stdin when running the driver from the command line.",
+			EnvVar: "OXIDE_USER_DATA_FILE",
+		},
+		mcnflag.BoolFlag{
+			Name:   flagUserDataAsSeedISO,
+			Usage:  "Should the user data be provided on a NoCloud seed ISO (labeled `cidata`) attached to the instance as a disk rather than through the instance's user data, for images that don't read Oxide's user data. Combine with oxide-inject-key-via-user-data so the generated SSH public key reaches such images. The disk is deleted when the machine is removed.",
+			EnvVar: "OXIDE_USER_DATA_AS_SEED_ISO",
+		},
+		mcnflag.BoolFlag{
+			Name:   flagInjectKeyViaUserData,
+			Usage:  "Should the generated SSH public key also be added to `ssh_authorized_keys` in the cloud-init user data. Useful for images that ignore Oxide SSH keys. Existing user data must be a `#cloud-config` document.",
+			EnvVar: "OXIDE_INJECT_KEY_VIA_USER_DATA",
+		},
+		mcnflag.BoolFlag{
+			Name:   flagEnableConsoleLogging,
+			Usage:  "Should the cloud-init user data configure the instance to log cloud-init and journald output to the serial console and keep the journal across reboots. Useful to debug instances that fail to boot. Existing user data must be a `#cloud-config` document.",
+			EnvVar: "OXIDE_ENABLE_CONSOLE_LOGGING",
+		},
+		mcnflag.StringSliceFlag{
+			Name:   flagDNSServers,
+			Usage:  "DNS server IP addresses to configure on the instance via the cloud-init user data. Existing user data must be a `#cloud-config` document.",
+			EnvVar: "OXIDE_DNS_SERVERS",
+		},
+		mcnflag.StringSliceFlag{
+			Name:   flagDNSSearch,
+			Usage:  "DNS search domains to configure on the instance via the cloud-init user data. Existing user data must be a `#cloud-config` document.",
+			EnvVar: "OXIDE_DNS_SEARCH",
+		},
+		mcnflag.StringFlag{
+			Name:   flagStaticIP,
+			Usage:  "Static IPv4 address, in CIDR notation including the netmask, for the instance's network interface (e.g., 172.30.0.10/22). The instance is configured with a netplan network config via the cloud-init user data for images that don't get addressing automatically. Existing user data must be a `#cloud-config` document.",
+			EnvVar: "OXIDE_STATIC_IP",
+		},
+		mcnflag.StringFlag{
+			Name:   flagIPFamily,
+			Usage:  "IP address family of the instance's network interface. One of `ipv4`, `ipv6`, or `dual`. Rancher connects to the instance over IPv6 only for `ipv6`, which requires Rancher to have an IPv6 address.",
+			EnvVar: "OXIDE_IP_FAMILY",
+			Value:  ipFamilyIPv4,
+		},
+		mcnflag.StringFlag{
+			Name:   flagGateway,
+			Usage:  "Default gateway IPv4 address to configure on the instance alongside the static IP address.",
+			EnvVar: "OXIDE_GATEWAY",
+		},
+
+		// SSH information.
+		mcnflag.StringFlag{
+			Name:   flagSSHUser,
+			Usage:  "User to use when connecting to the instance via SSH. Defaults to the default user of the boot disk image's operating system (e.g., ubuntu), or oxide when it isn't known.",
+			EnvVar: "OXIDE_SSH_USER",
+		},
+		mcnflag.IntFlag{
+			Name:   flagSSHPort,
+			Usage:  "Port to use when connecting to the instance via SSH.",
+			EnvVar: "OXIDE_SSH_PORT",
+			Value:  defaultSSHPort,
+		},
+		mcnflag.IntFlag{
+			Name:   flagDockerPort,
+			Usage:  "Port the Docker daemon on the instance listens on.",
+			EnvVar: "OXIDE_DOCKER_PORT",
+			Value:  defaultDockerPort,
+		},
+		mcnflag.BoolFlag{
+			Name:   flagVerifySSHAuth,
+			Usage:  "Should creation verify that the instance accepts the generated SSH key by authenticating over SSH. Fails creation early for images that boot but reject the key.",
+			EnvVar: "OXIDE_VERIFY_SSH_AUTH",
+		},
+		mcnflag.StringSliceFlag{
+			Name:   flagSSHPublicKey,
+			Usage:  "Additional SSH public keys IDs to inject into the instance.",
+			EnvVar: "OXIDE_ADDITIONAL_SSH_PUBLIC_KEY_IDS",
+		},
+		mcnflag.StringFlag{
+			Name:   flagSSHKeyOrder,
+			Usage:  "Order in which the generated and additional SSH public keys are passed to the instance. One of `generated-first` or `additional-first`.",
+			EnvVar: "OXIDE_SSH_KEY_ORDER",
+			Value:  sshKeyOrderGeneratedFirst,
+		},
+		mcnflag.StringFlag{
+			Name:   flagSSHKeyDescription,
+			Usage:  "Description of the generated SSH public key uploaded to Oxide.",
+			EnvVar: "OXIDE_SSH_KEY_DESCRIPTION",
+			Value:  defaultDescription,
+		},
+
+		// Affinity and anti-affinity groups.
+		mcnflag.StringSliceFlag{
+			Name:  flagAntiAffinityGroup,
+			Usage: "Anti-affinity groups the instance will be a member of. The values can be IDs or names of anti-affinity groups.",
+		},
+		mcnflag.StringSliceFlag{
+			Name:  flagAffinityGroup,
+			Usage: "Affinity groups the instance will be a member of. The values can be IDs or names of affinity groups. A group cannot also be given as an anti-affinity group.",
+		},
+		mcnflag.StringFlag{
+			Name:   flagEnsureAntiAffinityGroup,
+			Usage:  "Name of an anti-affinity group the instance will be a member of. The group is created with the `allow` policy if it doesn't exist.",
+			EnvVar: "OXIDE_ENSURE_ANTI_AFFINITY_GROUP",
+		},
+		mcnflag.BoolFlag{
+			Name:   flagAntiAffinityGroupCleanup,
+			Usage:  "Should the anti-affinity group created for oxide-ensure-anti-affinity-group be deleted when the instance is removed and the group has no members.",
+			EnvVar: "OXIDE_ANTI_AFFINITY_GROUP_CLEANUP",
+		},
+
+		// Name conflicts.
+		mcnflag.BoolFlag{
+			Name:   flagNameConflictRetry,
+			Usage:  "Should instance creation be retried with a random name suffix when the derived instance, disk, or network interface names already exist.",
+			EnvVar: "OXIDE_NAME_CONFLICT_RETRY",
+		},
+		mcnflag.StringFlag{
+			Name:   flagNameSuffixStrategy,
+			Usage:  "How the suffix appended to the instance name is generated. One of `none`, which never appends a suffix so names are stable, `random`, which appends 5 random characters, or `timestamp`, which appends the creation time so names are unique and sortable.",
+			EnvVar: "OXIDE_NAME_SUFFIX_STRATEGY",
+			Value:  nameSuffixStrategyRandom,
+		},
+		mcnflag.BoolFlag{
+			Name:   flagNameSuffixAlways,
+			Usage:  "Should the name suffix always be appended to the instance name rather than only when retrying a name conflict.",
+			EnvVar: "OXIDE_NAME_SUFFIX_ALWAYS",
+		},
+		mcnflag.StringFlag{
+			Name:   flagInstanceNameSeed,
+			Usage:  "Seed from which a deterministic instance name is derived by appending a hash of the seed to the machine name, so that re-runs with the same seed are idempotent.",
+			EnvVar: "OXIDE_INSTANCE_NAME_SEED",
+		},
+
+		// User agent.
+		mcnflag.StringFlag{
+			Name:   flagUserAgent,
+			Usage:  "Custom user agent string for API requests.",
+			EnvVar: "OXIDE_USER_AGENT",
+			Value:  "Oxide Rancher Machine Driver",
+		},
+
+		// TLS.
+		mcnflag.StringFlag{
+			Name:   flagTLSMinVersion,
+			Usage:  "Minimum TLS version for API requests. One of `1.2` or `1.3`. Defaults to the Go default.",
+			EnvVar: "OXIDE_TLS_MIN_VERSION",
+		},
+
+		// API base path.
+		mcnflag.StringFlag{
+			Name:   flagAPIBasePath,
+			Usage:  "Path prefix the Oxide API is served under, for APIs behind a gateway or reverse proxy (e.g., /oxide). Must start with `/`.",
+			EnvVar: "OXIDE_API_BASE_PATH",
+		},
+
+		// Facts.
+		mcnflag.StringFlag{
+			Name:   flagFactsOutputFile,
+			Usage:  "Path to write a JSON document with the instance ID, IP addresses, boot disk ID, additional disk IDs, and SSH public key ID to after the instance is created.",
+			EnvVar: "OXIDE_FACTS_OUTPUT_FILE",
+		},
+	}
+}
+
+// GetIP returns the IP address Rancher uses to connect to the instance. The
+// external IP address is returned when one is attached unless `IPPreference`
+// is `internal`, otherwise the internal IP address is returned.
+func (d *Driver) GetIP() (string, error) {
+	if d.ExternalIPAddress != "" && d.IPPreference != ipPreferenceInternal {
+		return d.ExternalIPAddress, nil
+	}
+
+	if d.InternalIPAddress != "" {
+		return d.InternalIPAddress, nil
+	}
+
+	// Machines created before the internal and external IP addresses were
+	// tracked separately only have the embedded BaseDriver's IP address.
+	return d.BaseDriver.GetIP()
+}
+
+// GetExternalIPs fetches the ephemeral and floating external IP addresses
+// attached to the instance. An empty list is returned when the instance has no
+// external IP addresses.
+func (d *Driver) GetExternalIPs() ([]string, error) {
+	ctx := context.TODO()
+
+	if d.oxideClient == nil {
+		client, err := d.createOxideClient()
+		if err != nil {
+			return nil, err
+		}
+		d.oxideClient = client
+	}
+
+	externalIPs, err := d.oxideClient.InstanceExternalIpList(ctx, oxide.InstanceExternalIpListParams{
+		Instance: oxide.NameOrId(d.InstanceID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed listing external IPs for instance: %w", err)
+	}
+
+	ips := make([]string, 0, len(externalIPs.Items))
+	for _, externalIP := range externalIPs.Items {
+		if ip := externalIPAddress(externalIP); ip != "" {
+			ips = append(ips, ip)
+		}
+	}
+
+	return ips, nil
+}
+
+// NodeLabels returns suggested Kubernetes node labels describing where the
+// instance runs in Oxide, for tooling that enriches nodes with Oxide metadata.
+// Labels whose value is unknown are omitted. The sled the instance runs on is
+// not exposed by the silo API, so it's not included.
+func (d *Driver) NodeLabels() map[string]string {
+	labels := make(map[string]string)
+	for key, value := range map[string]string{
+		"silo":          d.Silo,
+		"project":       d.Project,
+		"instance-id":   d.InstanceID,
+		"instance-name": d.InstanceName,
+	} {
+		if value != "" {
+			labels[nodeLabelPrefix+key] = value
+		}
+	}
+	return labels
+}
+
+// GetSSHHostname returns the IP address or DNS name of the instance.
+// This IP address or DNS name must be accessible from Rancher.
+func (d *Driver) GetSSHHostname() (string, error) {
+	return d.GetIP()
+}
+
+// GetState fetches the current state of the instance and returns it as
+// a standardized state representation that Rancher can understand.
+func (d *Driver) GetState() (state.State, error) {
+	return d.getState(context.TODO())
+}
+
+// getState fetches the current state of the instance using ctx for the API
+// call.
+func (d *Driver) getState(ctx context.Context) (state.State, error) {
+	// The instance has not been created yet so there's nothing to look up.
+	if d.InstanceID == "" {
+		return state.None, nil
+	}
+
+	if d.oxideClient == nil {
+		client, err := d.createOxideClient()
+		if err != nil {
+			return state.None, err
+		}
+		d.oxideClient = client
+	}
+
+	instance, err := d.oxideClient.InstanceView(ctx, oxide.InstanceViewParams{
+		Instance: oxide.NameOrId(d.InstanceID),
+	})
+	if err != nil {
+		return state.None, err
+	}
+
+	return toRancherMachineState(instance.RunState), nil
+}
+
+// GetURL builds and returns a Docker-compatible URL that can be used to
+// connect to the instance.
+func (d *Driver) GetURL() (string, error) {
+	if err := drivers.MustBeRunning(d); err != nil {
+		return "", err
+	}
+
+	ip, err := d.GetIP()
+	if err != nil {
+		return "", err
+	}
+
+	u := url.URL{
+		Scheme: "tcp",
+		Host:   net.JoinHostPort(ip, strconv.Itoa(cmp.Or(d.DockerPort, defaultDockerPort))),
+	}
+
+	return u.String(), nil
+}
+
+// Kill forcefully stops the instance but does not remove it.
+func (d *Driver) Kill() error {
+	return d.Stop()
+}
+
+// PreCreateCheck performs necessary driver validation before creating any
+// instance.
+func (d *Driver) PreCreateCheck() error {
+	ctx := context.TODO()
+
+	if d.UserDataFile == userDataFileStdin {
+		if _, err := d.readStdinUserData(); err != nil {
+			return err
+		}
+	} else if d.UserDataFile != "" {
+		if _, err := os.Stat(d.UserDataFile); os.IsNotExist(err) {
+			return fmt.Errorf("user data file %s could not be found", d.UserDataFile)
+		}
+	}
+
+	if d.BootDiskSizeAuto && d.BootDiskImageID == "" {
+		if d.BootDiskSizeDelta > 0 {
+			return fmt.Errorf("boot disk size relative to its image requires a boot disk image")
+		}
+		return fmt.Errorf("boot disk size %q requires a boot disk image", bootDiskSizeAuto)
+	}
+
+	if err := d.checkFactsOutputDir(); err != nil {
+		return err
+	}
+
+	if err := d.validateDerivedNames(); err != nil {
+		return err
+	}
+
+	if d.IPFamily == ipFamilyIPv6 {
+		if err := checkIPv6Reachable(); err != nil {
+			return err
+		}
+	}
+
+	// The boot disk image is still needed to size the boot disk automatically.
+	if d.SkipPreCreateValidation && !d.BootDiskSizeAuto && !d.RespectMaintenance {
+		return nil
+	}
+
+	if d.oxideClient == nil {
+		client, err := d.createOxideClient()
+		if err != nil {
+			return err
+		}
+		d.oxideClient = client
+	}
+
+	if d.RespectMaintenance {
+		if err := d.checkAPIAvailable(ctx); err != nil {
+			return err
+		}
+	}
+
+	if !d.SkipPreCreateValidation {
+		if err := d.validateResources(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Images only exist once they're usable, so an image that is still being
+	// imported is not found until it's finalized.
+	var bootDiskImage *oxide.Image
+	if d.BootDiskImageID != "" {
+		if err := d.waitForResource(ctx, func() error {
+			var err error
+			bootDiskImage, err = d.viewImage(ctx, d.BootDiskImageID)
+			return err
+		}); err != nil {
+			return fmt.Errorf("image %q not found: %w", d.BootDiskImageID, err)
+		}
+	}
+
+	if bootDiskImage != nil {
+		d.BootDiskImageID = bootDiskImage.Id
+
+		if d.SSHUserAuto {
+			if user := sshUserForImage(*bootDiskImage); user != "" {
+				d.SSHUser = user
+				log.Infof("Using SSH user %s for image %s", d.SSHUser, bootDiskImage.Name)
+			}
+		}
+	}
+
+	if d.BootDiskSizeAuto {
+		bootDiskSize, err := bootDiskSizeForImage(uint64(bootDiskImage.Size), d.BootDiskSizeDelta)
+		if err != nil {
+			return NewFlagParseError(flagBootDiskSize, err)
+		}
+		d.BootDiskSize = bootDiskSize
+		log.Infof("Using boot disk size %s to fit image %s", humanize.IBytes(d.BootDiskSize), d.BootDiskImageID)
+	}
+
+	return nil
+}
+
+// sshUserForImage returns the default user of image's operating system, or an
+// empty string if it isn't known.
+func sshUserForImage(image oxide.Image) string {
+	for _, s := range []string{image.Os, string(image.Name)} {
+		s = strings.ToLower(s)
+		for _, entry := range imageSSHUsers {
+			if strings.Contains(s, entry.family) {
+				return entry.user
+			}
+		}
+	}
+	return ""
+}
+
+// projectRef returns the reference to the project used in API calls: its ID
+// once resolved, otherwise `Project` as given.
+func (d *Driver) projectRef() oxide.NameOrId {
+	return oxide.NameOrId(cmp.Or(d.ProjectID, d.Project))
+}
+
+// viewImage returns the image with the given ID or name. A name is looked up
+// among both the project's images and the silo's images, failing if it names a
+// different image in each.
+func (d *Driver) viewImage(ctx context.Context, image string) (*oxide.Image, error) {
+	if isUUID(image) {
+		return d.oxideClient.ImageView(ctx, oxide.ImageViewParams{
+			Image: oxide.NameOrId(image),
+		})
+	}
+
+	projectImage, projectErr := d.oxideClient.ImageView(ctx, oxide.ImageViewParams{
+		Image:   oxide.NameOrId(image),
+		Project: d.projectRef(),
+	})
+	if projectErr != nil && !isNotFound(projectErr) {
+		return nil, projectErr
+	}
+
+	// Without a project, images are looked up by name among the silo's images.
+	siloImage, siloErr := d.oxideClient.ImageView(ctx, oxide.ImageViewParams{
+		Image: oxide.NameOrId(image),
+	})
+	if siloErr != nil && !isNotFound(siloErr) {
+		return nil, siloErr
+	}
+
+	switch {
+	case projectErr == nil && siloErr == nil && projectImage.Id != siloImage.Id:
+		return nil, fmt.Errorf("image name %q is ambiguous, matching project image %s and silo image %s", image, projectImage.Id, siloImage.Id)
+	case projectErr == nil:
+		return projectImage, nil
+	case siloErr == nil:
+		return siloImage, nil
+	default:
+		return nil, projectErr
+	}
+}
+
+// bootDiskSizeForImage returns the size of a boot disk that fits an image of
+// imageSize plus delta, both in bytes, failing if the disk would be larger than
+// the Oxide API allows.
+func bootDiskSizeForImage(imageSize, delta uint64) (uint64, error) {
+	if delta > maxDiskSize || imageSize > maxDiskSize-delta {
+		return 0, fmt.Errorf("image size %s plus %s exceeds the maximum disk size %s",
+			humanize.IBytes(imageSize), humanize.IBytes(delta), humanize.IBytes(maxDiskSize))
+	}
+	return imageSize + delta, nil
+}
+
+// checkAPIAvailable checks that the Oxide API is available to create the
+// instance. The API doesn't expose whether the rack is in maintenance, so the
+// API is pinged and treated as in maintenance when it's unavailable.
+func (d *Driver) checkAPIAvailable(ctx context.Context) error {
+	if _, err := d.oxideClient.Ping(ctx); err != nil {
+		if isServiceUnavailable(err) {
+			return fmt.Errorf("not creating the instance since the Oxide API at %s is unavailable, likely for maintenance: %w", d.Host, err)
+		}
+		return fmt.Errorf("failed checking whether the Oxide API is available: %w", err)
+	}
+	return nil
+}
+
+// lookupError describes the failure to look up resource, optionally within
+// scope (e.g., ` in project "prod"`), distinguishing a resource that doesn't
+// exist from one the token is not allowed to access.
+func lookupError(err error, resource, scope string) error {
+	switch {
+	case isNotFound(err):
+		return fmt.Errorf("%s not found%s: %w", resource, scope, err)
+	case isUnauthorized(err), isForbidden(err):
+		return fmt.Errorf("token is not allowed to access %s%s: %w", resource, scope, err)
+	default:
+		return fmt.Errorf("failed looking up %s%s: %w", resource, scope, err)
+	}
+}
+
+// validateResources validates that the resources the instance is created with
+// exist and are usable before creating it.
+func (d *Driver) validateResources(ctx context.Context) error {
+	var project *oxide.Project
+	if err := d.waitForResource(ctx, func() error {
+		var err error
+		project, err = d.oxideClient.ProjectView(ctx, oxide.ProjectViewParams{
+			Project: oxide.NameOrId(d.Project),
+		})
+		return err
+	}); err != nil {
+		return lookupError(err, fmt.Sprintf("project %q", d.Project), "")
+	}
+
+	// The project ID is recorded alongside the project as given, and used by
+	// the lookups that follow.
+	d.ProjectID = project.Id
+
+	var vpc *oxide.Vpc
+	if err := d.waitForResource(ctx, func() error {
+		var err error
+		vpc, err = d.oxideClient.VpcView(ctx, oxide.VpcViewParams{
+			Project: d.projectRef(),
+			Vpc:     oxide.NameOrId(d.VPC),
+		})
+		return err
+	}); err != nil {
+		return lookupError(err, fmt.Sprintf("vpc %q", d.VPC), fmt.Sprintf(" in project %q", d.Project))
+	}
+
+	var subnet *oxide.VpcSubnet
+	if err := d.waitForResource(ctx, func() error {
+		var err error
+		subnet, err = d.oxideClient.VpcSubnetView(ctx, oxide.VpcSubnetViewParams{
+			Project: d.projectRef(),
+			Vpc:     oxide.NameOrId(d.VPC),
+			Subnet:  oxide.NameOrId(d.Subnet),
+		})
+		return err
+	}); err != nil {
+		return lookupError(err, fmt.Sprintf("subnet %q", d.Subnet), fmt.Sprintf(" in vpc %q", d.VPC))
+	}
+
+	if err := d.checkSubnetAddresses(ctx, subnet); err != nil {
+		return err
+	}
+
+	// The network interface is created with the VPC and subnet names, so
+	// they're recorded by name when given by ID.
+	if isUUID(d.VPC) {
+		d.VPC = string(vpc.Name)
+	}
+	if isUUID(d.Subnet) {
+		d.Subnet = string(subnet.Name)
+	}
+
+	if d.EphemeralIPAttach && d.EphemeralIPPool != "" {
+		if err := d.checkEphemeralIPPool(ctx, d.EphemeralIPPool); err != nil {
+			return err
+		}
+	}
+	for _, externalIP := range d.ExternalIPs {
+		if externalIP.Type != externalIPTypeEphemeral || externalIP.NameOrID == "" {
+			continue
+		}
+		if err := d.checkEphemeralIPPool(ctx, externalIP.NameOrID); err != nil {
+			return err
+		}
+	}
+
+	if err := d.checkGroups(ctx); err != nil {
+		return err
+	}
+
+	// Additional disk images are looked up like the boot disk image and
+	// recorded by ID, which is how the disk source refers to them.
+	for i, additionalDisk := range d.AdditionalDisks {
+		if additionalDisk.ImageID == "" {
+			continue
+		}
+
+		image, err := d.viewImage(ctx, additionalDisk.ImageID)
+		if err != nil {
+			return fmt.Errorf("image %q not found: %w", additionalDisk.ImageID, err)
+		}
+
+		if additionalDisk.Size < uint64(image.Size) {
+			return fmt.Errorf("additional disk size %s is smaller than image %q size %s",
+				humanize.IBytes(additionalDisk.Size), additionalDisk.ImageID, humanize.IBytes(uint64(image.Size)))
+		}
+
+		d.AdditionalDisks[i].ImageID = image.Id
+	}
+
+	return nil
+}
+
+// checkSubnetAddresses returns an error when every IPv4 address in subnet is
+// already allocated to a network interface, since creating the instance's
+// network interface would otherwise fail partway through `Create`. An IPv6 only
+// network interface is always given an address from the subnet's /64 block, so
+// it isn't checked.
+func (d *Driver) checkSubnetAddresses(ctx context.Context, subnet *oxide.VpcSubnet) error {
+	if d.IPFamily == ipFamilyIPv6 {
+		return nil
+	}
+
+	capacity, err := subnetCapacity(string(subnet.Ipv4Block))
+	if err != nil {
+		return fmt.Errorf("failed parsing ipv4 block of subnet %q: %w", d.Subnet, err)
+	}
+
+	nics, err := d.oxideClient.VpcSubnetListNetworkInterfacesAllPages(ctx, oxide.VpcSubnetListNetworkInterfacesParams{
+		Project: d.projectRef(),
+		Vpc:     oxide.NameOrId(d.VPC),
+		Subnet:  oxide.NameOrId(d.Subnet),
+	})
+	if err != nil {
+		return fmt.Errorf("failed listing network interfaces in subnet %q: %w", d.Subnet, err)
+	}
+
+	if len(nics) >= capacity {
+		return fmt.Errorf("subnet %q has no free addresses: %d of %d addresses in %s in use",
+			d.Subnet, len(nics), capacity, subnet.Ipv4Block)
+	}
+
+	return nil
+}
+
+// checkEphemeralIPPool returns an error when the IP pool named by poolName
+// cannot provide an ephemeral external IP address for the instance's network
+// interface, which the API would otherwise reject partway through `Create`.
+func (d *Driver) checkEphemeralIPPool(ctx context.Context, poolName string) error {
+	pool, err := d.oxideClient.IpPoolView(ctx, oxide.IpPoolViewParams{
+		Pool: oxide.NameOrId(poolName),
+	})
+	if err != nil {
+		return fmt.Errorf("ip pool %q not found or not linked to the silo: %w", poolName, err)
+	}
+
+	if pool.PoolType == oxide.IpPoolTypeMulticast {
+		return fmt.Errorf("ip pool %q is a multicast pool and cannot provide an ephemeral ip", poolName)
+	}
+
+	// The instance's network interface only has the IP stacks of `IPFamily`.
+	switch {
+	case d.IPFamily == ipFamilyIPv6 && pool.IpVersion != oxide.IpVersionV6:
+		return fmt.Errorf("ip pool %q has %s addresses but the network interface in subnet %q of vpc %q only has an ipv6 address",
+			poolName, pool.IpVersion, d.Subnet, d.VPC)
+	case d.IPFamily != ipFamilyIPv6 && d.IPFamily != ipFamilyDual && pool.IpVersion != oxide.IpVersionV4:
+		return fmt.Errorf("ip pool %q has %s addresses but the network interface in subnet %q of vpc %q only has an ipv4 address",
+			poolName, pool.IpVersion, d.Subnet, d.VPC)
+	}
+
+	return nil
+}
+
+// reservedSubnetAddresses is the number of addresses in each VPC subnet that
+// Oxide reserves for itself: the first five addresses and the broadcast
+// address.
+const reservedSubnetAddresses = 6
+
+// subnetCapacity returns the number of addresses in the IPv4 CIDR block that
+// can be allocated to network interfaces.
+func subnetCapacity(block string) (int, error) {
+	_, ipNet, err := net.ParseCIDR(block)
+	if err != nil {
+		return 0, err
+	}
+
+	ones, bits := ipNet.Mask.Size()
+	return max((1<<(bits-ones))-reservedSubnetAddresses, 0), nil
+}
+
+// Remove stops and removes the instance and any dependencies so that
+// they no longer exist in Oxide.
+func (d *Driver) Remove() error {
+	if d.oxideClient == nil {
+		client, err := d.createOxideClient()
+		if err != nil {
+			return err
+		}
+		d.oxideClient = client
+	}
+
+	return d.withOperationTimeout("remove", d.remove)
+}
+
+// remove implements `Remove` once the Oxide client has been created.
+func (d *Driver) remove(ctx context.Context) error {
+	if d.InstancePreserved {
+		log.Warnf("Not deleting instance %s or its disks since it was preserved for debugging", d.InstanceID)
+		return nil
+	}
+
+	if err := d.stop(ctx); err != nil {
+		return err
+	}
+
+	// The instance cannot be deleted until it's stopped. Wait for it to stop.
+	if err := d.waitForStopped(ctx); err != nil {
+		return err
+	}
+
+	// A failure to delete the SSH key must not leak the instance and disks, so
+	// it's returned along with any other error once they've been deleted.
+	var sshKeyErr error
+	if err := d.oxideClient.CurrentUserSshKeyDelete(ctx, oxide.CurrentUserSshKeyDeleteParams{
+		SshKey: oxide.NameOrId(d.SSHPublicKeyID),
+	}); err != nil {
+		sshKeyErr = fmt.Errorf("failed deleting ssh key %s: %w", d.SSHPublicKeyID, err)
+	}
+
+	if err := errors.Join(sshKeyErr, d.removeInstanceAndDisks(ctx)); err != nil {
+		return err
+	}
 
-		// User agent.
-		mcnflag.StringFlag{
-			Name:   flagUserAgent,
-			Usage:  "Custom user agent string for API requests.",
-			EnvVar: "OXIDE_USER_AGENT",
-			Value:  "Oxide Rancher Machine Driver",
-		},
+	if d.VerifyRemove {
+		return d.verifyRemoved(ctx)
 	}
-}
 
-// GetSSHHostname returns the IP address or DNS name of the instance.
-// This IP address or DNS name must be accessible from Rancher.
-func (d *Driver) GetSSHHostname() (string, error) {
-	// Use the embedded BaseDriver's logic.
-	return d.GetIP()
+	return nil
 }
 
-// GetState fetches the current state of the instance and returns it as
-// a standardized state representation that Rancher can understand.
-func (d *Driver) GetState() (state.State, error) {
-	if d.oxideClient == nil {
-		client, err := d.createOxideClient()
-		if err != nil {
-			return state.None, err
+// verifyRemoved checks that the instance, its disks, and the generated SSH
+// public key no longer exist, since a delete the API accepted may not have
+// completed. Every resource that still exists is listed in the returned error.
+func (d *Driver) verifyRemoved(ctx context.Context) error {
+	var remaining []string
+	var joinedErr error
+	check := func(resource string, view func() error) {
+		err := view()
+		switch {
+		case isNotFound(err):
+		case err == nil:
+			remaining = append(remaining, resource)
+		default:
+			joinedErr = errors.Join(joinedErr, fmt.Errorf("failed verifying %s was removed: %w", resource, err))
 		}
-		d.oxideClient = client
 	}
 
-	instance, err := d.oxideClient.InstanceView(context.TODO(), oxide.InstanceViewParams{
-		Instance: oxide.NameOrId(d.InstanceID),
+	check("instance "+d.InstanceID, func() error {
+		_, err := d.oxideClient.InstanceView(ctx, oxide.InstanceViewParams{
+			Instance: oxide.NameOrId(d.InstanceID),
+		})
+		return err
 	})
-	if err != nil {
-		return state.None, err
+	for _, diskID := range d.removableDiskIDs() {
+		check("disk "+diskID, func() error {
+			_, err := d.oxideClient.DiskView(ctx, oxide.DiskViewParams{
+				Disk: oxide.NameOrId(diskID),
+			})
+			return err
+		})
 	}
+	check("ssh key "+d.SSHPublicKeyID, func() error {
+		_, err := d.oxideClient.CurrentUserSshKeyView(ctx, oxide.CurrentUserSshKeyViewParams{
+			SshKey: oxide.NameOrId(d.SSHPublicKeyID),
+		})
+		return err
+	})
 
-	return toRancherMachineState(instance.RunState), nil
+	if len(remaining) > 0 {
+		joinedErr = errors.Join(fmt.Errorf("resources still exist after removal: %s", strings.Join(remaining, ", ")), joinedErr)
+	}
+
+	return joinedErr
 }
 
-// GetURL builds and returns a Docker-compatible URL that can be used to
-// connect to the instance.
-func (d *Driver) GetURL() (string, error) {
-	if err := drivers.MustBeRunning(d); err != nil {
-		return "", err
+// removeInstanceAndDisks deletes the stopped instance, its disks, and the other
+// resources created alongside it.
+func (d *Driver) removeInstanceAndDisks(ctx context.Context) error {
+	// Ephemeral IPs are released back to their pool along with the instance,
+	// so only floating IPs need to be handled.
+	if err := d.detachFloatingIPs(ctx); err != nil {
+		return err
 	}
 
-	ip, err := d.GetIP()
-	if err != nil {
-		return "", err
+	// Disks are detached and the instance leaves its anti-affinity groups once
+	// the instance is deleted, so nothing else can be removed before then.
+	if err := d.oxideClient.InstanceDelete(ctx, oxide.InstanceDeleteParams{
+		Instance: oxide.NameOrId(d.InstanceID),
+	}); err != nil {
+		return err
 	}
 
-	u := url.URL{
-		Scheme: "tcp",
-		Host:   net.JoinHostPort(ip, "2376"),
-	}
+	// The remaining resources don't depend on each other, so they're removed
+	// concurrently and every failure is returned joined together.
+	var diskErr, groupErr, snapshotErr error
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		diskErr = d.deleteDisks(ctx, d.removableDiskIDs())
+	}()
+	go func() {
+		defer wg.Done()
+		if d.AntiAffinityGroupCleanup && d.CreatedAntiAffinityGroupID != "" {
+			groupErr = d.removeAntiAffinityGroup(ctx)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		snapshotErr = d.deleteCloneSnapshot(ctx)
+	}()
+	wg.Wait()
 
-	return u.String(), nil
+	return errors.Join(diskErr, groupErr, snapshotErr)
 }
 
-// Kill forcefully stops the instance but does not remove it.
-func (d *Driver) Kill() error {
-	return d.Stop()
+// detachFloatingIPs detaches the floating IPs attached to the instance so they
+// can be attached to another instance. Floating IPs that no longer exist are
+// ignored.
+func (d *Driver) detachFloatingIPs(ctx context.Context) error {
+	var joinedErr error
+	for _, floatingIPID := range d.FloatingIPIDs {
+		if _, err := d.oxideClient.FloatingIpDetach(ctx, oxide.FloatingIpDetachParams{
+			FloatingIp: oxide.NameOrId(floatingIPID),
+		}); err != nil && !isNotFound(err) {
+			joinedErr = errors.Join(joinedErr, fmt.Errorf("failed detaching floating ip %s: %w", floatingIPID, err))
+		}
+	}
+	return joinedErr
 }
 
-// PreCreateCheck performs necessary driver validation before creating any
-// instance.
-func (d *Driver) PreCreateCheck() error {
-	if d.UserDataFile != "" {
-		if _, err := os.Stat(d.UserDataFile); os.IsNotExist(err) {
-			return fmt.Errorf("user data file %s could not be found", d.UserDataFile)
-		}
+// deleteDisk deletes the disk and, when `WaitForDiskDeletion` is set, waits for
+// it to no longer exist.
+func (d *Driver) deleteDisk(ctx context.Context, diskID string) error {
+	if err := d.oxideClient.DiskDelete(ctx, oxide.DiskDeleteParams{
+		Disk: oxide.NameOrId(diskID),
+	}); err != nil {
+		return err
 	}
+
+	if d.WaitForDiskDeletion {
+		return d.waitForDiskDeleted(ctx, diskID)
+	}
+
 	return nil
 }
 
-// Remove stops and removes the instance and any dependencies so that
-// they no longer exist in Oxide.
-func (d *Driver) Remove() error {
+// deleteDisks deletes the disks using up to `DiskDeleteConcurrency` concurrent
+// deletions. A failure to delete one disk does not stop the others from being
+// deleted and all failures are returned joined together.
+func (d *Driver) deleteDisks(ctx context.Context, diskIDs []string) error {
+	concurrency := max(d.DiskDeleteConcurrency, 1)
+	sem := make(chan struct{}, concurrency)
+	errs := make([]error, len(diskIDs))
+
+	var wg sync.WaitGroup
+	for i, diskID := range diskIDs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := d.deleteDisk(ctx, diskID); err != nil {
+				errs[i] = fmt.Errorf("failed deleting disk %s: %w", diskID, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// RemoveInstanceOnly stops and deletes the instance but keeps its disks and the
+// generated SSH public key so the machine can be quickly re-provisioned with
+// `RecreateInstance`. Unlike `Remove`, the additional disks are detached rather
+// than deleted and the boot disk is released by the instance deletion, so
+// `BootDiskID`, `AdditionalDiskIDs`, and `SSHPublicKeyID` remain valid.
+func (d *Driver) RemoveInstanceOnly() error {
 	if d.oxideClient == nil {
 		client, err := d.createOxideClient()
 		if err != nil {
@@ -541,62 +3378,120 @@ func (d *Driver) Remove() error {
 		d.oxideClient = client
 	}
 
-	if err := d.Stop(); err != nil {
+	return d.withOperationTimeout("remove instance", d.removeInstanceOnly)
+}
+
+// removeInstanceOnly implements `RemoveInstanceOnly` once the Oxide client has
+// been created. Every additional disk is detached before a failure is
+// returned, and the instance is only deleted once they're all detached.
+func (d *Driver) removeInstanceOnly(ctx context.Context) error {
+	if err := d.stop(ctx); err != nil {
 		return err
 	}
 
-	// The instance cannot be deleted until it's stopped. Wait for it to stop.
-	stopCtx, cancel := context.WithTimeout(context.TODO(), 2*time.Minute)
-	defer cancel()
+	if err := d.waitForStopped(ctx); err != nil {
+		return err
+	}
 
-	for {
-		select {
-		case <-stopCtx.Done():
-			return fmt.Errorf("timed out waiting for instance to stop: %w", stopCtx.Err())
-		default:
+	var detachErr error
+	for _, additionalDiskID := range d.AdditionalDiskIDs {
+		if _, err := d.oxideClient.InstanceDiskDetach(ctx, oxide.InstanceDiskDetachParams{
+			Instance: oxide.NameOrId(d.InstanceID),
+			Body: &oxide.DiskPath{
+				Disk: oxide.NameOrId(additionalDiskID),
+			},
+		}); err != nil {
+			detachErr = errors.Join(detachErr, fmt.Errorf("failed detaching disk %s: %w", additionalDiskID, err))
 		}
+	}
+	if detachErr != nil {
+		return detachErr
+	}
+
+	if err := d.oxideClient.InstanceDelete(ctx, oxide.InstanceDeleteParams{
+		Instance: oxide.NameOrId(d.InstanceID),
+	}); err != nil {
+		return err
+	}
+
+	d.InstanceID = ""
+	d.IPAddress = ""
+	d.InternalIPAddress = ""
+	d.ExternalIPAddress = ""
+
+	return nil
+}
 
-		currentState, err := d.GetState()
+// RecreateInstance creates a new instance for a machine previously removed with
+// `RemoveInstanceOnly`, attaching the kept boot disk and additional disks and
+// injecting the kept SSH public key.
+func (d *Driver) RecreateInstance() error {
+	ctx := context.TODO()
+
+	if d.oxideClient == nil {
+		client, err := d.createOxideClient()
 		if err != nil {
 			return err
 		}
+		d.oxideClient = client
+	}
+
+	if d.InstanceID != "" {
+		return fmt.Errorf("instance %s still exists", d.InstanceID)
+	}
+
+	// Disks can only be attached by name during instance creation.
+	bootDisk, err := d.oxideClient.DiskView(ctx, oxide.DiskViewParams{
+		Disk: oxide.NameOrId(d.BootDiskID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed viewing boot disk %s: %w", d.BootDiskID, err)
+	}
 
-		if currentState == state.Stopped {
-			break
+	disks := make([]oxide.InstanceDiskAttachment, len(d.AdditionalDiskIDs))
+	for i, additionalDiskID := range d.AdditionalDiskIDs {
+		disk, err := d.oxideClient.DiskView(ctx, oxide.DiskViewParams{
+			Disk: oxide.NameOrId(additionalDiskID),
+		})
+		if err != nil {
+			return fmt.Errorf("failed viewing disk %s: %w", additionalDiskID, err)
+		}
+		disks[i] = oxide.InstanceDiskAttachment{
+			Value: &oxide.InstanceDiskAttachmentAttach{Name: disk.Name},
 		}
 	}
 
-	if err := d.oxideClient.CurrentUserSshKeyDelete(context.TODO(), oxide.CurrentUserSshKeyDeleteParams{
-		SshKey: oxide.NameOrId(d.SSHPublicKeyID),
-	}); err != nil {
+	userData, err := d.readUserData()
+	if err != nil {
 		return err
 	}
 
-	if err := d.oxideClient.InstanceDelete(context.TODO(), oxide.InstanceDeleteParams{
-		Instance: oxide.NameOrId(d.InstanceID),
-	}); err != nil {
-		return err
+	name := d.InstanceName
+	if name == "" {
+		name = d.baseInstanceName()
 	}
 
-	if err := d.oxideClient.DiskDelete(context.TODO(), oxide.DiskDeleteParams{
-		Disk: oxide.NameOrId(d.BootDiskID),
-	}); err != nil {
-		return err
+	icp := d.instanceCreateParams(name, d.sshPublicKeyIDs(), userData)
+	icp.Body.BootDisk = oxide.InstanceDiskAttachment{
+		Value: &oxide.InstanceDiskAttachmentAttach{Name: bootDisk.Name},
 	}
+	icp.Body.Disks = disks
 
-	for _, additionalDiskID := range d.AdditionalDiskIDs {
-		if err := d.oxideClient.DiskDelete(context.TODO(), oxide.DiskDeleteParams{
-			Disk: oxide.NameOrId(additionalDiskID),
-		}); err != nil {
-			return err
-		}
+	instance, err := d.instanceCreate(ctx, icp)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	d.InstanceID = instance.Id
+	d.InstanceName = name
+
+	return d.updateIPAddresses(ctx)
 }
 
 // Restart restarts the instance without changing its configuration.
 func (d *Driver) Restart() error {
+	ctx := context.TODO()
+
 	if d.oxideClient == nil {
 		client, err := d.createOxideClient()
 		if err != nil {
@@ -608,7 +3503,7 @@ func (d *Driver) Restart() error {
 	irp := oxide.InstanceRebootParams{
 		Instance: oxide.NameOrId(d.InstanceID),
 	}
-	if _, err := d.oxideClient.InstanceReboot(context.TODO(), irp); err != nil {
+	if _, err := d.oxideClient.InstanceReboot(ctx, irp); err != nil {
 		return err
 	}
 
@@ -619,26 +3514,121 @@ func (d *Driver) Restart() error {
 // driver for use by other methods.
 func (d *Driver) SetConfigFromFlags(opts drivers.DriverOptions) error {
 	d.Host = opts.String(flagHost)
+	d.Silo = opts.String(flagSilo)
+	d.Domain = opts.String(flagDomain)
 	d.Token = opts.String(flagToken)
+	d.TokenFile = opts.String(flagTokenFile)
 	d.Project = opts.String(flagProject)
+	d.ResourceReferences = opts.String(flagResourceReferences)
 	d.VCPUS = opts.Int(flagVCPUs)
 	d.BootDiskImageID = opts.String(flagBootDiskImageID)
+	d.CloneBootDiskFrom = opts.String(flagCloneBootDiskFrom)
+	d.CloneKeepSnapshot = opts.Bool(flagCloneKeepSnapshot)
+	d.BootDiskDescription = opts.String(flagBootDiskDescription)
+	d.InstanceDescription = opts.String(flagInstanceDescription)
 	d.VPC = opts.String(flagVPC)
 	d.Subnet = opts.String(flagSubnet)
 	d.UserDataFile = opts.String(flagUserDataFile)
+	d.UserDataAsSeedISO = opts.Bool(flagUserDataAsSeedISO)
+	d.FactsOutputFile = opts.String(flagFactsOutputFile)
+	d.InjectKeyViaUserData = opts.Bool(flagInjectKeyViaUserData)
+	d.EnableConsoleLogging = opts.Bool(flagEnableConsoleLogging)
+	d.AdoptExistingDisks = opts.Bool(flagAdoptExistingDisks)
+	d.DNSServers = opts.StringSlice(flagDNSServers)
+	d.DNSSearch = opts.StringSlice(flagDNSSearch)
+	d.StaticIP = opts.String(flagStaticIP)
+	d.IPFamily = opts.String(flagIPFamily)
+	d.Gateway = opts.String(flagGateway)
+	d.VerifySSHAuth = opts.Bool(flagVerifySSHAuth)
 	d.SSHUser = opts.String(flagSSHUser)
+	d.SSHUserAuto = d.SSHUser == ""
+	if d.SSHUserAuto {
+		d.SSHUser = defaultSSHUser
+	}
 	d.SSHPublicKeys = opts.StringSlice(flagSSHPublicKey)
+	d.SSHKeyOrder = opts.String(flagSSHKeyOrder)
+	d.SSHKeyDescription = opts.String(flagSSHKeyDescription)
 	d.AntiAffinityGroups = opts.StringSlice(flagAntiAffinityGroup)
-	d.SSHPort = defaultSSHPort
+	d.AffinityGroups = opts.StringSlice(flagAffinityGroup)
+	d.EnsureAntiAffinityGroup = opts.String(flagEnsureAntiAffinityGroup)
+	d.AntiAffinityGroupCleanup = opts.Bool(flagAntiAffinityGroupCleanup)
 	d.EphemeralIPAttach = opts.Bool(flagEphemeralIPAttach)
 	d.EphemeralIPPool = opts.String(flagEphemeralIPPool)
+	d.TransitIPs = opts.StringSlice(flagTransitIPs)
+	d.PrimaryNIC = opts.String(flagPrimaryNIC)
 	d.UserAgent = opts.String(flagUserAgent)
+	d.TLSMinVersion = opts.String(flagTLSMinVersion)
+	d.APIBasePath = strings.TrimSuffix(opts.String(flagAPIBasePath), "/")
+	d.NameConflictRetry = opts.Bool(flagNameConflictRetry)
+	d.InstanceNameSeed = opts.String(flagInstanceNameSeed)
+	d.NameSuffixStrategy = opts.String(flagNameSuffixStrategy)
+	d.NameSuffixAlways = opts.Bool(flagNameSuffixAlways)
+	d.WaitOnStart = opts.Bool(flagWaitOnStart)
+	d.SkipPreCreateValidation = opts.Bool(flagSkipPreCreateValidation)
+	d.RespectMaintenance = opts.Bool(flagRespectMaintenance)
+	d.WaitForDiskDeletion = opts.Bool(flagWaitForDiskDeletion)
+	d.VerifyRemove = opts.Bool(flagVerifyRemove)
+	d.DeleteAllDisks = opts.Bool(flagDeleteAllDisks)
+	d.IPPreference = opts.String(flagIPPreference)
+	d.InitialState = opts.String(flagInitialState)
+	d.AdditionalDiskFailurePolicy = opts.String(flagAdditionalDiskFailurePolicy)
+	d.PreserveOnFailure = opts.Bool(flagPreserveOnFailure)
+
+	if d.Token == "" && d.TokenFile != "" {
+		token, err := readTokenFile(d.TokenFile)
+		if err != nil {
+			return NewFlagParseError(flagTokenFile, err)
+		}
+		d.Token = token
+	}
+
+	// Errors parsing the flags that required flags are derived from are reported
+	// together with the errors parsing the optional flags.
+	var joinedParseErr error
+
+	if d.CloneBootDiskFrom != "" && d.BootDiskImageID != "" {
+		joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagCloneBootDiskFrom,
+			fmt.Errorf("options %q and %q are mutually exclusive", flagBootDiskImageID, flagCloneBootDiskFrom)))
+	}
+
+	projectTemplate := opts.String(flagProjectTemplate)
+	switch {
+	case projectTemplate == "":
+	case d.Project != "":
+		joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagProjectTemplate,
+			fmt.Errorf("options %q and %q are mutually exclusive", flagProject, flagProjectTemplate)))
+	default:
+		project, err := renderProjectTemplate(projectTemplate, os.Getenv(clusterNameEnvVar))
+		if err != nil {
+			joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagProjectTemplate, err))
+		}
+		d.Project = project
+	}
+
+	if strings.Contains(d.BootDiskImageID, "{{") {
+		image, err := renderBootDiskImageTemplate(d.BootDiskImageID)
+		if err != nil {
+			joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagBootDiskImageID, err))
+		} else {
+			d.BootDiskImageID = image
+		}
+	}
 
 	// Required flags.
 	{
 		var joinedRequiredFlagError error
 
-		if d.Host == "" {
+		switch {
+		case d.Host != "":
+		case d.Silo != "" && d.Domain == "":
+			joinedRequiredFlagError = errors.Join(joinedRequiredFlagError, NewRequiredFlagError(flagDomain))
+		case d.Silo != "":
+			host, err := siloHost(d.Silo, d.Domain)
+			if err != nil {
+				joinedRequiredFlagError = errors.Join(joinedRequiredFlagError, NewFlagParseError(flagSilo, err))
+			}
+			d.Host = host
+		default:
 			joinedRequiredFlagError = errors.Join(joinedRequiredFlagError, NewRequiredFlagError(flagHost))
 		}
 
@@ -646,43 +3636,289 @@ func (d *Driver) SetConfigFromFlags(opts drivers.DriverOptions) error {
 			joinedRequiredFlagError = errors.Join(joinedRequiredFlagError, NewRequiredFlagError(flagToken))
 		}
 
-		if d.Project == "" {
+		// The project template failing to render is already a parse error.
+		if d.Project == "" && projectTemplate == "" {
 			joinedRequiredFlagError = errors.Join(joinedRequiredFlagError, NewRequiredFlagError(flagProject))
 		}
 
-		if d.BootDiskImageID == "" {
+		if d.BootDiskImageID == "" && d.CloneBootDiskFrom == "" {
 			joinedRequiredFlagError = errors.Join(joinedRequiredFlagError, NewRequiredFlagError(flagBootDiskImageID))
 		}
 
 		if joinedRequiredFlagError != nil {
-			return joinedRequiredFlagError
+			return errors.Join(joinedRequiredFlagError, joinedParseErr)
 		}
 	}
 
 	// Optional flags that need to be parsed. The logic is a bit verbose due to the
 	// type conversion between the flag and the field in the Driver struct.
 	{
-		var joinedParseErr error
-
 		memoryStr := opts.String(flagMemory)
 		if memoryStr == "" {
 			memoryStr = defaultMemory
 		}
-		memory, err := humanize.ParseBytes(memoryStr)
+		memory, err := parseSize(memoryStr)
 		if err != nil {
 			joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagMemory, err))
 		}
-		d.Memory = memory
+		d.Memory = memory
+
+		bootDiskSizeStr := opts.String(flagBootDiskSize)
+		if bootDiskSizeStr == "" {
+			bootDiskSizeStr = defaultBootDiskSize
+		}
+		d.BootDiskSizeAuto = bootDiskSizeStr == bootDiskSizeAuto
+		if deltaStr, ok := strings.CutPrefix(bootDiskSizeStr, bootDiskSizeDeltaPrefix); ok {
+			delta, err := parseBootDiskSizeDelta(deltaStr)
+			if err != nil {
+				joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagBootDiskSize, err))
+			}
+			d.BootDiskSizeAuto = true
+			d.BootDiskSizeDelta = delta
+		} else if !d.BootDiskSizeAuto {
+			bootDiskSize, err := parseSize(bootDiskSizeStr)
+			if err != nil {
+				joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagBootDiskSize, err))
+			}
+			d.BootDiskSize = bootDiskSize
+		}
+
+		switch d.IPPreference {
+		case "":
+			d.IPPreference = ipPreferenceExternal
+		case ipPreferenceExternal, ipPreferenceInternal:
+		default:
+			joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagIPPreference,
+				fmt.Errorf("invalid value %q, expected %q or %q", d.IPPreference, ipPreferenceExternal, ipPreferenceInternal)))
+		}
+
+		switch d.SSHKeyOrder {
+		case "":
+			d.SSHKeyOrder = sshKeyOrderGeneratedFirst
+		case sshKeyOrderGeneratedFirst, sshKeyOrderAdditionalFirst:
+		default:
+			joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagSSHKeyOrder,
+				fmt.Errorf("invalid value %q, expected %q or %q", d.SSHKeyOrder, sshKeyOrderGeneratedFirst, sshKeyOrderAdditionalFirst)))
+		}
+
+		if _, ok := tlsVersions[d.TLSMinVersion]; d.TLSMinVersion != "" && !ok {
+			joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagTLSMinVersion,
+				fmt.Errorf("invalid value %q, expected %q or %q", d.TLSMinVersion, tlsVersion12, tlsVersion13)))
+		}
+
+		if d.APIBasePath != "" {
+			if err := validateAPIBasePath(d.APIBasePath); err != nil {
+				joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagAPIBasePath, err))
+			}
+		}
+
+		switch d.NameSuffixStrategy {
+		case "":
+			d.NameSuffixStrategy = nameSuffixStrategyRandom
+		case nameSuffixStrategyNone, nameSuffixStrategyRandom, nameSuffixStrategyTimestamp:
+		default:
+			joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagNameSuffixStrategy,
+				fmt.Errorf("invalid value %q, expected %q, %q, or %q", d.NameSuffixStrategy, nameSuffixStrategyNone, nameSuffixStrategyRandom, nameSuffixStrategyTimestamp)))
+		}
+
+		switch d.ResourceReferences {
+		case "":
+			d.ResourceReferences = resourceReferencesAuto
+		case resourceReferencesAuto, resourceReferencesName, resourceReferencesID:
+		default:
+			joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagResourceReferences,
+				fmt.Errorf("invalid value %q, expected %q, %q, or %q", d.ResourceReferences, resourceReferencesAuto, resourceReferencesName, resourceReferencesID)))
+		}
+
+		switch d.InitialState {
+		case "":
+			d.InitialState = initialStateRunning
+		case initialStateRunning, initialStateStopped:
+		default:
+			joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagInitialState,
+				fmt.Errorf("invalid value %q, expected %q or %q", d.InitialState, initialStateRunning, initialStateStopped)))
+		}
+
+		switch d.AdditionalDiskFailurePolicy {
+		case "":
+			d.AdditionalDiskFailurePolicy = additionalDiskFailurePolicyFail
+		case additionalDiskFailurePolicyFail, additionalDiskFailurePolicyWarn:
+		default:
+			joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagAdditionalDiskFailurePolicy,
+				fmt.Errorf("invalid value %q, expected %q or %q", d.AdditionalDiskFailurePolicy, additionalDiskFailurePolicyFail, additionalDiskFailurePolicyWarn)))
+		}
+
+		if preCreateWaitStr := opts.String(flagPreCreateWait); preCreateWaitStr != "" {
+			preCreateWait, err := time.ParseDuration(preCreateWaitStr)
+			if err != nil {
+				joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagPreCreateWait, err))
+			}
+			d.PreCreateWait = preCreateWait
+		}
+
+		if nicIPWaitStr := opts.String(flagNICIPWait); nicIPWaitStr != "" {
+			nicIPWait, err := time.ParseDuration(nicIPWaitStr)
+			if err != nil {
+				joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagNICIPWait, err))
+			}
+			d.NICIPWait = nicIPWait
+		}
+
+		for _, dnsServer := range d.DNSServers {
+			if net.ParseIP(dnsServer) == nil {
+				joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagDNSServers,
+					fmt.Errorf("invalid IP address %q", dnsServer)))
+			}
+		}
+
+		for _, transitIP := range d.TransitIPs {
+			if err := validateIPv4CIDR(transitIP); err != nil {
+				joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagTransitIPs, err))
+			}
+		}
+
+		if d.StaticIP != "" {
+			if err := validateIPv4CIDR(d.StaticIP); err != nil {
+				joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagStaticIP, err))
+			}
+		}
+
+		switch d.IPFamily {
+		case "":
+			d.IPFamily = ipFamilyIPv4
+		case ipFamilyIPv4, ipFamilyDual:
+		case ipFamilyIPv6:
+			// The static IP address and transit IPs are IPv4 only.
+			if d.StaticIP != "" || len(d.TransitIPs) > 0 {
+				joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagIPFamily,
+					fmt.Errorf("%q cannot be combined with %q or %q", ipFamilyIPv6, flagStaticIP, flagTransitIPs)))
+			}
+		default:
+			joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagIPFamily,
+				fmt.Errorf("invalid value %q, expected %q, %q, or %q", d.IPFamily, ipFamilyIPv4, ipFamilyIPv6, ipFamilyDual)))
+		}
+
+		if d.Gateway != "" {
+			if err := validateGateway(d.Gateway, d.StaticIP); err != nil {
+				joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagGateway, err))
+			}
+		}
+
+		d.StopPollInterval = defaultStopPollInterval
+		if stopPollIntervalStr := opts.String(flagStopPollInterval); stopPollIntervalStr != "" {
+			stopPollInterval, err := parsePositiveDuration(stopPollIntervalStr)
+			if err != nil {
+				joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagStopPollInterval, err))
+			}
+			d.StopPollInterval = stopPollInterval
+		}
+
+		d.StopPollMaxInterval = defaultStopPollMaxInterval
+		if stopPollMaxIntervalStr := opts.String(flagStopPollMaxInterval); stopPollMaxIntervalStr != "" {
+			stopPollMaxInterval, err := parsePositiveDuration(stopPollMaxIntervalStr)
+			if err != nil {
+				joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagStopPollMaxInterval, err))
+			}
+			d.StopPollMaxInterval = stopPollMaxInterval
+		}
+
+		d.DiskDeleteConcurrency = defaultDiskDeleteConcurrency
+		switch diskDeleteConcurrency := opts.Int(flagDiskDeleteConcurrency); {
+		case diskDeleteConcurrency < 0:
+			joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagDiskDeleteConcurrency,
+				fmt.Errorf("invalid value %d, expected a positive integer", diskDeleteConcurrency)))
+		case diskDeleteConcurrency > 0:
+			d.DiskDeleteConcurrency = diskDeleteConcurrency
+		}
+
+		if diskCreateConcurrency := opts.Int(flagDiskCreateConcurrency); diskCreateConcurrency < 0 {
+			joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagDiskCreateConcurrency,
+				fmt.Errorf("invalid value %d, expected a non-negative integer", diskCreateConcurrency)))
+		} else {
+			d.DiskCreateConcurrency = diskCreateConcurrency
+		}
+
+		if startMaxRetries := opts.Int(flagStartMaxRetries); startMaxRetries < 0 {
+			joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagStartMaxRetries,
+				fmt.Errorf("invalid value %d, expected a non-negative integer", startMaxRetries)))
+		} else {
+			d.StartMaxRetries = startMaxRetries
+		}
+
+		d.StopReissueAfter = defaultStopReissueAfter
+		if stopReissueAfterStr := opts.String(flagStopReissueAfter); stopReissueAfterStr != "" {
+			stopReissueAfter, err := time.ParseDuration(stopReissueAfterStr)
+			if err != nil {
+				joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagStopReissueAfter, err))
+			}
+			d.StopReissueAfter = stopReissueAfter
+		}
+
+		d.RemoveTimeout = defaultRemoveTimeout
+		if removeTimeoutStr := opts.String(flagRemoveTimeout); removeTimeoutStr != "" {
+			removeTimeout, err := time.ParseDuration(removeTimeoutStr)
+			if err == nil && removeTimeout <= 0 {
+				err = fmt.Errorf("invalid value %q, expected a positive duration", removeTimeoutStr)
+			}
+			if err != nil {
+				joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagRemoveTimeout, err))
+			}
+			d.RemoveTimeout = removeTimeout
+		}
+
+		d.CreateInitialDelay = defaultCreateInitialDelay
+		if createInitialDelayStr := opts.String(flagCreateInitialDelay); createInitialDelayStr != "" {
+			createInitialDelay, err := time.ParseDuration(createInitialDelayStr)
+			if err != nil {
+				joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagCreateInitialDelay, err))
+			}
+			d.CreateInitialDelay = createInitialDelay
+		}
+
+		if operationTimeoutStr := opts.String(flagOperationTimeout); operationTimeoutStr != "" {
+			operationTimeout, err := time.ParseDuration(operationTimeoutStr)
+			if err != nil {
+				joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagOperationTimeout, err))
+			}
+			d.OperationTimeout = operationTimeout
+		}
+
+		if d.InstanceDescription == "" {
+			d.InstanceDescription = defaultDescription
+		}
+
+		// Zero, such as when the flag isn't set, uses the default ports.
+		d.SSHPort = cmp.Or(opts.Int(flagSSHPort), defaultSSHPort)
+		if err := validatePort(flagSSHPort, d.SSHPort); err != nil {
+			joinedParseErr = errors.Join(joinedParseErr, err)
+		}
+
+		d.DockerPort = cmp.Or(opts.Int(flagDockerPort), defaultDockerPort)
+		if err := validatePort(flagDockerPort, d.DockerPort); err != nil {
+			joinedParseErr = errors.Join(joinedParseErr, err)
+		}
+
+		if d.InstanceNameSeed != "" {
+			if err := validateName(d.baseInstanceName()); err != nil {
+				joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagInstanceNameSeed, err))
+			}
+		}
 
-		bootDiskSizeStr := opts.String(flagBootDiskSize)
-		if bootDiskSizeStr == "" {
-			bootDiskSizeStr = defaultBootDiskSize
+		if labelsStr := opts.String(flagLabels); labelsStr != "" {
+			labels, err := parseLabels(labelsStr)
+			if err != nil {
+				joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagLabels, err))
+			}
+			d.Labels = labels
 		}
-		bootDiskSize, err := humanize.ParseBytes(bootDiskSizeStr)
-		if err != nil {
-			joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagBootDiskSize, err))
+
+		if d.SSHKeyDescription == "" {
+			d.SSHKeyDescription = defaultDescription
+		}
+
+		if d.BootDiskDescription == "" {
+			d.BootDiskDescription = defaultDescription
 		}
-		d.BootDiskSize = bootDiskSize
 
 		d.AdditionalDisks = make([]AdditionalDisk, 0)
 		for _, diskInfo := range opts.StringSlice(flagAdditionalDisk) {
@@ -693,16 +3929,108 @@ func (d *Driver) SetConfigFromFlags(opts drivers.DriverOptions) error {
 			d.AdditionalDisks = append(d.AdditionalDisks, additionalDisk)
 		}
 
+		checkReference := func(flag, reference string) {
+			if reference == "" {
+				return
+			}
+			if err := d.checkResourceReference(reference); err != nil {
+				joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flag, err))
+			}
+		}
+		checkReference(flagProject, d.Project)
+		checkReference(flagVPC, d.VPC)
+		checkReference(flagSubnet, d.Subnet)
+		checkReference(flagBootDiskImageID, d.BootDiskImageID)
+		for _, additionalDisk := range d.AdditionalDisks {
+			checkReference(flagAdditionalDisk, additionalDisk.ImageID)
+		}
+
+		d.ExternalIPs = make([]ExternalIP, 0)
+		for _, externalIPInfo := range opts.StringSlice(flagExternalIP) {
+			externalIP, err := ParseExternalIP(externalIPInfo)
+			if err != nil {
+				joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagExternalIP, err))
+			}
+			d.ExternalIPs = append(d.ExternalIPs, externalIP)
+		}
+
+		// An instance can only have one ephemeral IP.
+		ephemeralIPs := 0
+		if d.EphemeralIPAttach {
+			ephemeralIPs++
+		}
+		for _, externalIP := range d.ExternalIPs {
+			if externalIP.Type == externalIPTypeEphemeral {
+				ephemeralIPs++
+			}
+		}
+		if ephemeralIPs > 1 {
+			joinedParseErr = errors.Join(joinedParseErr, NewFlagParseError(flagExternalIP,
+				fmt.Errorf("only one ephemeral IP may be attached, including by %q", flagEphemeralIPAttach)))
+		}
+
 		if joinedParseErr != nil {
 			return joinedParseErr
 		}
 	}
 
-	return nil
+	return d.checkSizePolicy()
+}
+
+// checkSizePolicy checks the instance memory and vCPUs against the bounds
+// operators can set through the environment, returning a joined error for
+// every bound that is invalid or violated.
+func (d *Driver) checkSizePolicy() error {
+	parseCount := func(s string) (uint64, error) { return strconv.ParseUint(s, 10, 64) }
+	formatCount := func(v uint64) string { return strconv.FormatUint(v, 10) }
+
+	policies := []struct {
+		flag    string
+		envVar  string
+		minimum bool
+		value   uint64
+		parse   func(string) (uint64, error)
+		format  func(uint64) string
+	}{
+		{flagMemory, minMemoryEnvVar, true, d.Memory, parseSize, humanize.IBytes},
+		{flagMemory, maxMemoryEnvVar, false, d.Memory, parseSize, humanize.IBytes},
+		{flagVCPUs, minVCPUsEnvVar, true, uint64(d.VCPUS), parseCount, formatCount},
+		{flagVCPUs, maxVCPUsEnvVar, false, uint64(d.VCPUS), parseCount, formatCount},
+	}
+
+	var joinedErr error
+	for _, policy := range policies {
+		boundStr := os.Getenv(policy.envVar)
+		if boundStr == "" {
+			continue
+		}
+
+		bound, err := policy.parse(boundStr)
+		if err != nil {
+			joinedErr = errors.Join(joinedErr, fmt.Errorf("invalid %s value %q: %w", policy.envVar, boundStr, err))
+			continue
+		}
+
+		switch {
+		case policy.minimum && policy.value < bound:
+			joinedErr = errors.Join(joinedErr, NewFlagParseError(policy.flag,
+				fmt.Errorf("%s is below the minimum %s set by %s", policy.format(policy.value), policy.format(bound), policy.envVar)))
+		case !policy.minimum && policy.value > bound:
+			joinedErr = errors.Join(joinedErr, NewFlagParseError(policy.flag,
+				fmt.Errorf("%s is above the maximum %s set by %s", policy.format(policy.value), policy.format(bound), policy.envVar)))
+		}
+	}
+
+	return joinedErr
 }
 
 // Start starts the instance.
 func (d *Driver) Start() error {
+	return d.start(context.TODO())
+}
+
+// start starts the instance using ctx for the API calls and the wait.
+func (d *Driver) start(ctx context.Context) error {
 	if d.oxideClient == nil {
 		client, err := d.createOxideClient()
 		if err != nil {
@@ -714,15 +4042,27 @@ func (d *Driver) Start() error {
 	isp := oxide.InstanceStartParams{
 		Instance: oxide.NameOrId(d.InstanceID),
 	}
-	if _, err := d.oxideClient.InstanceStart(context.TODO(), isp); err != nil {
+	if err := d.retryTransient(ctx, "instance start", d.StartMaxRetries, func() error {
+		_, err := d.oxideClient.InstanceStart(ctx, isp)
+		return err
+	}); err != nil {
 		return err
 	}
 
+	if d.WaitOnStart {
+		return d.waitForRunning(ctx, "start")
+	}
+
 	return nil
 }
 
 // Stop stops the instance.
 func (d *Driver) Stop() error {
+	return d.stop(context.TODO())
+}
+
+// stop stops the instance using ctx for the API call.
+func (d *Driver) stop(ctx context.Context) error {
 	if d.oxideClient == nil {
 		client, err := d.createOxideClient()
 		if err != nil {
@@ -734,7 +4074,7 @@ func (d *Driver) Stop() error {
 	isp := oxide.InstanceStopParams{
 		Instance: oxide.NameOrId(d.InstanceID),
 	}
-	if _, err := d.oxideClient.InstanceStop(context.TODO(), isp); err != nil {
+	if _, err := d.oxideClient.InstanceStop(ctx, isp); err != nil {
 		return err
 	}
 
@@ -744,7 +4084,7 @@ func (d *Driver) Stop() error {
 // createSSHKeyPair creates a new SSH key pair, saves both the private and
 // public key to the store path for the machine driver to use, and uploads the
 // public key to Oxide to be injected into the instance.
-func (d *Driver) createSSHKeyPair() (*oxide.SshKey, error) {
+func (d *Driver) createSSHKeyPair(ctx context.Context) (*oxide.SshKey, error) {
 	if d.oxideClient == nil {
 		client, err := d.createOxideClient()
 		if err != nil {
@@ -765,12 +4105,382 @@ func (d *Driver) createSSHKeyPair() (*oxide.SshKey, error) {
 
 	cuscp := oxide.CurrentUserSshKeyCreateParams{
 		Body: &oxide.SshKeyCreate{
-			Description: defaultDescription,
+			Description: d.SSHKeyDescription,
 			Name:        oxide.Name(d.GetMachineName()),
 			PublicKey:   string(b),
 		},
 	}
-	return d.oxideClient.CurrentUserSshKeyCreate(context.TODO(), cuscp)
+	return d.oxideClient.CurrentUserSshKeyCreate(ctx, cuscp)
+}
+
+// floatingExternalIP returns the floating IP that externalIP represents, if
+// any.
+func floatingExternalIP(externalIP oxide.ExternalIp) (oxide.ExternalIpFloating, bool) {
+	switch v := externalIP.Value.(type) {
+	case oxide.ExternalIpFloating:
+		return v, true
+	case *oxide.ExternalIpFloating:
+		return *v, true
+	default:
+		return oxide.ExternalIpFloating{}, false
+	}
+}
+
+// externalIPAddress returns the address of an external IP that can be used to
+// reach the instance. SNAT addresses are only used for outbound traffic so an
+// empty string is returned for them.
+func externalIPAddress(externalIP oxide.ExternalIp) string {
+	switch v := externalIP.Value.(type) {
+	case oxide.ExternalIpEphemeral:
+		return v.Ip
+	case *oxide.ExternalIpEphemeral:
+		return v.Ip
+	case oxide.ExternalIpFloating:
+		return v.Ip
+	case *oxide.ExternalIpFloating:
+		return v.Ip
+	default:
+		return ""
+	}
+}
+
+// instanceDescription returns the instance description followed by the JSON
+// encoded `Labels`, if any.
+func (d *Driver) instanceDescription() string {
+	description := cmp.Or(d.InstanceDescription, defaultDescription)
+	if len(d.Labels) == 0 {
+		return description
+	}
+
+	// The labels were validated to encode when parsed.
+	labels, _ := encodeLabels(d.Labels)
+	return description + " " + labels
+}
+
+// diskDescription returns the description of a disk created with the instance
+// named instanceName. The default description is followed by the owning
+// instance. A description the user set is left as given.
+func diskDescription(description, instanceName string) string {
+	if description != defaultDescription {
+		return description
+	}
+	return description + " " + ownerDescriptionPrefix + instanceName
+}
+
+// encodeLabels returns labels as they're appended to the instance description.
+// Map keys are sorted when encoded so the result is deterministic.
+func encodeLabels(labels map[string]string) (string, error) {
+	b, err := json.Marshal(labels)
+	if err != nil {
+		return "", err
+	}
+	return labelsDescriptionPrefix + string(b), nil
+}
+
+// parseLabels parses the `oxide-labels` JSON object of strings.
+func parseLabels(s string) (map[string]string, error) {
+	var labels map[string]string
+	if err := json.Unmarshal([]byte(s), &labels); err != nil {
+		return nil, fmt.Errorf("invalid labels JSON object: %w", err)
+	}
+
+	if _, err := encodeLabels(labels); err != nil {
+		return nil, err
+	}
+
+	return labels, nil
+}
+
+// renderProjectTemplate renders the `oxide-project-template` text/template
+// with the Rancher cluster name available as `.ClusterName`.
+func renderProjectTemplate(projectTemplate, clusterName string) (string, error) {
+	if clusterName == "" {
+		return "", fmt.Errorf("cluster name not set in %s", clusterNameEnvVar)
+	}
+
+	tmpl, err := template.New(flagProjectTemplate).Parse(projectTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, struct{ ClusterName string }{ClusterName: clusterName}); err != nil {
+		return "", err
+	}
+
+	return sb.String(), nil
+}
+
+// renderBootDiskImageTemplate renders the `oxide-boot-disk-image-id`
+// text/template, where `env` returns the value of an environment variable.
+// Rendering fails when a referenced environment variable is unset or empty, or
+// when the result isn't an image ID or a valid image name.
+func renderBootDiskImageTemplate(imageTemplate string) (string, error) {
+	funcs := template.FuncMap{
+		"env": func(name string) (string, error) {
+			value := os.Getenv(name)
+			if value == "" {
+				return "", fmt.Errorf("environment variable %s not set", name)
+			}
+			return value, nil
+		},
+	}
+
+	tmpl, err := template.New(flagBootDiskImageID).Funcs(funcs).Parse(imageTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, nil); err != nil {
+		return "", err
+	}
+
+	image := sb.String()
+	if !isUUID(image) {
+		if err := validateName(image); err != nil {
+			return "", fmt.Errorf("rendered image: %w", err)
+		}
+	}
+
+	return image, nil
+}
+
+// siloHost constructs the silo domain name `https://<silo>.<domain>` from a
+// silo shortname and DNS suffix, returning an error if the result is not a
+// valid URL.
+func siloHost(silo, domain string) (string, error) {
+	if strings.ContainsAny(silo, "./:") {
+		return "", fmt.Errorf("silo %q must be a shortname, not a domain name or URL", silo)
+	}
+
+	domain = strings.Trim(domain, ".")
+	host := fmt.Sprintf("https://%s.%s", silo, domain)
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return "", err
+	}
+
+	if u.Hostname() != silo+"."+domain || u.Path != "" {
+		return "", fmt.Errorf("invalid silo domain name %q", host)
+	}
+
+	return host, nil
+}
+
+// validateIPv4CIDR validates that s is an IPv4 network in CIDR notation.
+func validateIPv4CIDR(s string) error {
+	ip, _, err := net.ParseCIDR(s)
+	if err != nil {
+		return err
+	}
+	if ip.To4() == nil {
+		return fmt.Errorf("%q is not an IPv4 network", s)
+	}
+	return nil
+}
+
+// validateGateway validates that gateway is an IPv4 address within the network
+// of the static IP address in CIDR notation.
+func validateGateway(gateway, staticIP string) error {
+	ip := net.ParseIP(gateway)
+	if ip == nil || ip.To4() == nil {
+		return fmt.Errorf("%q is not an IPv4 address", gateway)
+	}
+
+	if staticIP == "" {
+		return fmt.Errorf("gateway requires %q", flagStaticIP)
+	}
+
+	// An invalid static IP address is reported against its own flag.
+	_, network, err := net.ParseCIDR(staticIP)
+	if err == nil && !network.Contains(ip) {
+		return fmt.Errorf("gateway %s is not within %s", gateway, staticIP)
+	}
+
+	return nil
+}
+
+// baseInstanceName returns the name the instance is created with before any
+// name conflict retry: the machine name, or the name derived from
+// `InstanceNameSeed` when set.
+func (d *Driver) baseInstanceName() string {
+	if d.InstanceNameSeed == "" {
+		return d.GetMachineName()
+	}
+	return seededInstanceName(d.GetMachineName(), d.InstanceNameSeed)
+}
+
+// seededInstanceName derives a deterministic instance name from machineName
+// and seed by appending a short hash of the seed, shortening machineName so
+// the result fits within an Oxide resource name.
+func seededInstanceName(machineName, seed string) string {
+	sum := sha256.Sum256([]byte(seed))
+	return suffixName(machineName, hex.EncodeToString(sum[:])[:seededNameSuffixLength])
+}
+
+// suffixName appends suffix to name, shortening name so the result fits within
+// an Oxide resource name.
+func suffixName(name, suffix string) string {
+	if maxPrefix := maxNameLength - len(suffix) - 1; len(name) > maxPrefix {
+		name = strings.TrimRight(name[:maxPrefix], "-")
+	}
+	return name + "-" + suffix
+}
+
+// derivedName returns the name of a resource derived from the instance name:
+// prefix followed by name. When that doesn't fit within an Oxide resource name,
+// name is shortened and a short hash of it appended, so that the names derived
+// from distinct instance names remain distinct.
+func derivedName(prefix, name string) string {
+	if len(prefix)+len(name) <= maxNameLength {
+		return prefix + name
+	}
+	sum := sha256.Sum256([]byte(name))
+	return suffixName(prefix+name, hex.EncodeToString(sum[:])[:seededNameSuffixLength])
+}
+
+// validateName reports whether name is a valid Oxide resource name: at most
+// 63 characters of lowercase letters, digits, and hyphens, starting with a
+// letter and not ending with a hyphen.
+func validateName(name string) error {
+	if name == "" || len(name) > maxNameLength {
+		return fmt.Errorf("name %q must be between 1 and %d characters", name, maxNameLength)
+	}
+	if name[0] < 'a' || name[0] > 'z' {
+		return fmt.Errorf("name %q must start with a lowercase letter", name)
+	}
+	if strings.HasSuffix(name, "-") {
+		return fmt.Errorf("name %q must not end with a hyphen", name)
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return fmt.Errorf("name %q must only contain lowercase letters, digits, and hyphens", name)
+		}
+	}
+	return nil
+}
+
+// parseBootDiskSizeDelta parses the size following the `+` of a boot disk size
+// relative to its image. The size must be positive, since `auto` already sizes
+// the boot disk to fit its image exactly.
+func parseBootDiskSizeDelta(s string) (uint64, error) {
+	delta, err := parseSize(s)
+	if err != nil {
+		return 0, err
+	}
+	if delta == 0 {
+		return 0, fmt.Errorf("size %q added to the image size must be positive", s)
+	}
+	if delta > maxDiskSize {
+		return 0, fmt.Errorf("size %q added to the image size exceeds the maximum disk size %s", s, humanize.IBytes(maxDiskSize))
+	}
+	return delta, nil
+}
+
+// parseSize parses a size in bytes with an optional unit suffix (e.g., 4 GiB).
+// A single comma used as the decimal separator (e.g., 1,5 GiB) is accepted,
+// since the comma would otherwise be dropped as a thousands separator and the
+// size silently misread. A comma followed by exactly three digits (e.g., 1,024
+// MiB) remains a thousands separator.
+func parseSize(s string) (uint64, error) {
+	size, err := humanize.ParseBytes(normalizeDecimalComma(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q, expected a number with an optional unit suffix (e.g., 512 MiB, 4 GiB, or 1.5 TiB): %w", s, err)
+	}
+	return size, nil
+}
+
+// normalizeDecimalComma replaces a comma used as the decimal separator in the
+// number at the start of s with a period.
+func normalizeDecimalComma(s string) string {
+	s = strings.TrimSpace(s)
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != ',' && r != '.'
+	})
+	if end < 0 {
+		end = len(s)
+	}
+
+	number, unit := s[:end], s[end:]
+	if strings.Count(number, ",") != 1 || strings.Contains(number, ".") {
+		return s
+	}
+	if _, fraction, _ := strings.Cut(number, ","); len(fraction) == 3 {
+		return s
+	}
+	return strings.Replace(number, ",", ".", 1) + unit
+}
+
+// checkResourceReference returns an error when reference isn't of the kind
+// given by `ResourceReferences`. The Oxide API forbids names formatted as
+// UUIDs, so a reference of the expected kind is looked up the same way as with
+// `auto`.
+func (d *Driver) checkResourceReference(reference string) error {
+	switch d.ResourceReferences {
+	case resourceReferencesName:
+		if isUUID(reference) {
+			return fmt.Errorf("%q is an ID but names are expected", reference)
+		}
+	case resourceReferencesID:
+		if !isUUID(reference) {
+			return fmt.Errorf("%q is not an ID but IDs are expected", reference)
+		}
+	}
+	return nil
+}
+
+// isUUID reports whether s is formatted as a UUID. Oxide resource names cannot
+// be UUIDs, so a name or ID given as a UUID is always an ID.
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, r := range s {
+		switch i {
+		case 8, 13, 18, 23:
+			if r != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validatePort returns a `FlagParseError` for flag when port is not a valid
+// TCP port.
+func validatePort(flag string, port int) error {
+	if port < 1 || port > 65535 {
+		return NewFlagParseError(flag, fmt.Errorf("invalid port %d, expected a value between 1 and 65535", port))
+	}
+	return nil
+}
+
+// randomNameSuffix returns a short random string of lowercase letters and
+// digits that's valid within an Oxide resource name.
+func randomNameSuffix() (string, error) {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed generating random name suffix: %w", err)
+	}
+	for i := range b {
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+	return string(b), nil
+}
+
+// timestampNameSuffix returns the milliseconds since the Unix epoch at t in
+// base 36, a short string of lowercase letters and digits that's valid within
+// an Oxide resource name and sorts by creation time.
+func timestampNameSuffix(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 36)
 }
 
 // toRancherMachineState converts an Oxide instance state to a Rancher machine
@@ -814,18 +4524,63 @@ type AdditionalDisk struct {
 
 	// An optional label to use in the disk name for ease of identification.
 	Label string
+
+	// An optional description of the disk. `defaultDescription` is used when
+	// empty.
+	Description string
+
+	// An optional ID of the image to create the disk from. The disk is blank
+	// when empty.
+	ImageID string
+
+	// An optional backend to store the disk on. Either `distributed`, which
+	// replicates the disk across sleds, or `local`, which stores it on the
+	// sled the instance runs on for lower latency. Empty is `distributed`.
+	Backend string
 }
 
+// Values for the additional disk backend.
+const (
+	diskBackendDistributed = "distributed"
+	diskBackendLocal       = "local"
+)
+
 // ParseAdditionalDisk parses an `AdditionalDisk` from a string in the format
-// `SIZE[,LABEL]` where `SIZE` is the disk size in bytes and `LABEL` is an
-// arbitrary string used within the disk name for identification. `SIZE`
-// supports a unit suffix (e.g., 20 GiB).
+// `[image,IMAGE_ID,][backend,BACKEND,]SIZE[,LABEL[,DESCRIPTION]]` where
+// `IMAGE_ID` is the ID of an image to create the disk from, `BACKEND` is either
+// `distributed` or `local`, `SIZE` is the disk size in bytes, `LABEL` is an
+// arbitrary string used within the disk name for identification, and
+// `DESCRIPTION` is the disk description. `SIZE` supports a unit suffix (e.g.,
+// 20 GiB). The description may itself contain commas.
 func ParseAdditionalDisk(s string) (AdditionalDisk, error) {
-	var sizeStr string
+	var imageID, backend, sizeStr, description string
 	label := "additional"
 
-	fields := strings.Split(s, ",")
+	if rest, ok := strings.CutPrefix(s, "image,"); ok {
+		imageID, s, _ = strings.Cut(rest, ",")
+		if imageID == "" {
+			return AdditionalDisk{}, errors.New("missing image ID")
+		}
+	}
+
+	if rest, ok := strings.CutPrefix(s, "backend,"); ok {
+		backend, s, _ = strings.Cut(rest, ",")
+		switch backend {
+		case diskBackendDistributed:
+		case diskBackendLocal:
+			if imageID != "" {
+				return AdditionalDisk{}, fmt.Errorf("%s disks cannot be created from an image", diskBackendLocal)
+			}
+		default:
+			return AdditionalDisk{}, fmt.Errorf("invalid backend %q, expected %q or %q", backend, diskBackendDistributed, diskBackendLocal)
+		}
+	}
+
+	fields := strings.SplitN(s, ",", 3)
 	switch len(fields) {
+	case 3:
+		description = fields[2]
+		fallthrough
 	case 2:
 		sizeStr = fields[0]
 		if fields[1] != "" {
@@ -833,18 +4588,19 @@ func ParseAdditionalDisk(s string) (AdditionalDisk, error) {
 		}
 	case 1:
 		sizeStr = fields[0]
-	default:
-		return AdditionalDisk{}, fmt.Errorf("invalid format %q, expected size[,label]", s)
 	}
 
-	size, err := humanize.ParseBytes(sizeStr)
+	size, err := parseSize(sizeStr)
 	if err != nil {
-		return AdditionalDisk{}, fmt.Errorf("failed parsing size %q %w", sizeStr, err)
+		return AdditionalDisk{}, err
 	}
 
 	a := AdditionalDisk{
-		Size:  size,
-		Label: label,
+		Size:        size,
+		Label:       label,
+		Description: description,
+		ImageID:     imageID,
+		Backend:     backend,
 	}
 
 	return a, nil
@@ -852,5 +4608,126 @@ func ParseAdditionalDisk(s string) (AdditionalDisk, error) {
 
 // Name returns a string representing the disk name.
 func (a AdditionalDisk) Name(machineName string, diskNumber int) string {
-	return fmt.Sprintf("disk-%02d-%s-%s", diskNumber, a.Label, machineName)
+	return derivedName(fmt.Sprintf("disk-%02d-%s-", diskNumber, a.Label), machineName)
+}
+
+// diskBackend returns the backend to create the disk on. Local disks are always
+// blank.
+func (a AdditionalDisk) diskBackend() oxide.DiskBackend {
+	if a.Backend == diskBackendLocal {
+		return oxide.DiskBackend{
+			Value: &oxide.DiskBackendLocal{},
+		}
+	}
+
+	return oxide.DiskBackend{
+		Value: &oxide.DiskBackendDistributed{
+			DiskSource: a.diskSource(),
+		},
+	}
+}
+
+// diskSource returns the source to create the disk from, which is either the
+// disk's image or a blank disk.
+func (a AdditionalDisk) diskSource() oxide.DiskSource {
+	if a.ImageID != "" {
+		return oxide.DiskSource{
+			Value: &oxide.DiskSourceImage{
+				ImageId: a.ImageID,
+			},
+		}
+	}
+
+	return oxide.DiskSource{
+		Value: &oxide.DiskSourceBlank{
+			BlockSize: oxide.BlockSize(4096),
+		},
+	}
+}
+
+// DescriptionOrDefault returns the disk description, falling back to
+// `defaultDescription` when none was given.
+func (a AdditionalDisk) DescriptionOrDefault() string {
+	if a.Description == "" {
+		return defaultDescription
+	}
+	return a.Description
+}
+
+// ExternalIP represents an external IP attached to an instance.
+type ExternalIP struct {
+	// Required. The type of external IP. Either `floating` or `ephemeral`.
+	Type string
+
+	// The name or ID of the floating IP, which is required, or of the IP pool
+	// to allocate the ephemeral IP from. The ephemeral IP is allocated from
+	// the silo's default pool when empty.
+	NameOrID string
+}
+
+// Values for the external IP type.
+const (
+	externalIPTypeFloating  = "floating"
+	externalIPTypeEphemeral = "ephemeral"
+)
+
+// ParseExternalIP parses an `ExternalIP` from a string in the format
+// `floating,NAME_OR_ID` where `NAME_OR_ID` is the name or ID of a floating IP,
+// or `ephemeral[,POOL]` where `POOL` is the name or ID of the IP pool to
+// allocate an ephemeral IP from.
+func ParseExternalIP(s string) (ExternalIP, error) {
+	ipType, nameOrID, _ := strings.Cut(s, ",")
+	switch ipType {
+	case externalIPTypeFloating:
+		if nameOrID == "" {
+			return ExternalIP{}, fmt.Errorf("invalid external IP %q, expected floating,NAME_OR_ID", s)
+		}
+	case externalIPTypeEphemeral:
+	default:
+		return ExternalIP{}, fmt.Errorf("invalid external IP type %q, expected %q or %q", ipType, externalIPTypeFloating, externalIPTypeEphemeral)
+	}
+
+	return ExternalIP{Type: ipType, NameOrID: nameOrID}, nil
+}
+
+// externalIPCreate returns the parameters to attach the external IP when
+// creating an instance whose network interface has the IP stacks of ipFamily.
+func (e ExternalIP) externalIPCreate(ipFamily string) oxide.ExternalIpCreate {
+	if e.Type == externalIPTypeEphemeral {
+		return oxide.ExternalIpCreate{
+			Value: &oxide.ExternalIpCreateEphemeral{
+				PoolSelector: ephemeralIPPoolSelector(e.NameOrID, ipFamily),
+			},
+		}
+	}
+
+	return oxide.ExternalIpCreate{
+		Value: &oxide.ExternalIpCreateFloating{
+			FloatingIp: oxide.NameOrId(e.NameOrID),
+		},
+	}
+}
+
+// ephemeralIPPoolSelector selects the IP pool to allocate an ephemeral IP from:
+// pool when set, otherwise the silo's default IPv6 pool for an IPv6 only
+// ipFamily or its default IPv4 pool for the others.
+func ephemeralIPPoolSelector(pool, ipFamily string) oxide.PoolSelector {
+	if pool != "" {
+		return oxide.PoolSelector{
+			Value: &oxide.PoolSelectorExplicit{
+				Pool: oxide.NameOrId(pool),
+			},
+		}
+	}
+
+	ipVersion := oxide.IpVersionV4
+	if ipFamily == ipFamilyIPv6 {
+		ipVersion = oxide.IpVersionV6
+	}
+
+	return oxide.PoolSelector{
+		Value: &oxide.PoolSelectorAuto{
+			IpVersion: ipVersion,
+		},
+	}
 }
@@ -6,6 +6,21 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/oxidecomputer/oxide.go/oxide"
@@ -27,7 +42,183 @@ var _ = Describe("Driver", func() {
 			Expect(SUT.SetConfigFromFlags(opts)).To(Succeed())
 		})
 
+		DescribeTable("should construct the host from the silo and domain",
+			func(host, silo, domain, expected string) {
+				opts.Data[flagHost] = host
+				opts.Data[flagSilo] = silo
+				opts.Data[flagDomain] = domain
+				Expect(SUT.SetConfigFromFlags(opts)).To(Succeed())
+				Expect(SUT.Host).To(Equal(expected))
+			},
+			Entry("silo and domain", "", "silo01", "oxide.example.com", "https://silo01.oxide.example.com"),
+			Entry("domain with surrounding dots", "", "silo01", ".oxide.example.com.", "https://silo01.oxide.example.com"),
+			Entry("explicit host takes precedence", "https://other.example.com", "silo01", "oxide.example.com", "https://other.example.com"),
+		)
+
+		It("should default the instance description", func() {
+			Expect(SUT.SetConfigFromFlags(opts)).To(Succeed())
+			Expect(SUT.InstanceDescription).To(Equal(defaultDescription))
+		})
+
+		It("should use the given instance description", func() {
+			opts.Data[flagInstanceDescription] = "etcd node"
+			Expect(SUT.SetConfigFromFlags(opts)).To(Succeed())
+			Expect(SUT.InstanceDescription).To(Equal("etcd node"))
+		})
+
+		It("should succeed when the instance size is within the environment policy", func() {
+			GinkgoT().Setenv(minMemoryEnvVar, "4 GiB")
+			GinkgoT().Setenv(maxMemoryEnvVar, "16 GiB")
+			GinkgoT().Setenv(minVCPUsEnvVar, "2")
+			GinkgoT().Setenv(maxVCPUsEnvVar, "8")
+			opts.Data[flagMemory] = "8 GiB"
+			opts.Data[flagVCPUs] = 4
+			Expect(SUT.SetConfigFromFlags(opts)).To(Succeed())
+		})
+
+		It("should default the SSH user until it's detected from the image", func() {
+			opts.Data[flagSSHUser] = ""
+			Expect(SUT.SetConfigFromFlags(opts)).To(Succeed())
+			Expect(SUT.SSHUserAuto).To(BeTrue())
+			Expect(SUT.SSHUser).To(Equal(defaultSSHUser))
+		})
+
+		It("should use the SSH user when given", func() {
+			opts.Data[flagSSHUser] = "admin"
+			Expect(SUT.SetConfigFromFlags(opts)).To(Succeed())
+			Expect(SUT.SSHUserAuto).To(BeFalse())
+			Expect(SUT.SSHUser).To(Equal("admin"))
+		})
+
+		It("should default the remove timeout", func() {
+			Expect(SUT.SetConfigFromFlags(opts)).To(Succeed())
+			Expect(SUT.RemoveTimeout).To(Equal(defaultRemoveTimeout))
+
+			opts.Data[flagRemoveTimeout] = "5m"
+			Expect(SUT.SetConfigFromFlags(opts)).To(Succeed())
+			Expect(SUT.RemoveTimeout).To(Equal(5 * time.Minute))
+		})
+
+		It("should defer the boot disk size when set to auto", func() {
+			opts.Data[flagBootDiskSize] = "auto"
+			Expect(SUT.SetConfigFromFlags(opts)).To(Succeed())
+			Expect(SUT.BootDiskSizeAuto).To(BeTrue())
+			Expect(SUT.BootDiskSize).To(BeZero())
+		})
+
+		It("should defer the boot disk size when given relative to its image", func() {
+			opts.Data[flagBootDiskSize] = "+10 GiB"
+			Expect(SUT.SetConfigFromFlags(opts)).To(Succeed())
+			Expect(SUT.BootDiskSizeAuto).To(BeTrue())
+			Expect(SUT.BootDiskSizeDelta).To(Equal(uint64(10 << 30)))
+			Expect(SUT.BootDiskSize).To(BeZero())
+		})
+
+		It("should read the token from the token file when no token is given", func() {
+			tokenFile := filepath.Join(GinkgoT().TempDir(), "token")
+			Expect(os.WriteFile(tokenFile, []byte("file-token\n"), 0o600)).To(Succeed())
+			opts.Data[flagToken] = ""
+			opts.Data[flagTokenFile] = tokenFile
+			Expect(SUT.SetConfigFromFlags(opts)).To(Succeed())
+			Expect(SUT.Token).To(Equal("file-token"))
+		})
+
+		It("should render the project from the template and cluster name", func() {
+			GinkgoT().Setenv(clusterNameEnvVar, "prod")
+			opts.Data[flagProject] = ""
+			opts.Data[flagProjectTemplate] = "k8s-{{.ClusterName}}"
+			Expect(SUT.SetConfigFromFlags(opts)).To(Succeed())
+			Expect(SUT.Project).To(Equal("k8s-prod"))
+		})
+
+		It("should render the boot disk image from the template and environment", func() {
+			GinkgoT().Setenv("IMAGE_RELEASE", "2404")
+			opts.Data[flagBootDiskImageID] = `ubuntu-{{env "IMAGE_RELEASE"}}`
+			Expect(SUT.SetConfigFromFlags(opts)).To(Succeed())
+			Expect(SUT.BootDiskImageID).To(Equal("ubuntu-2404"))
+		})
+
+		It("should use the default ports when unset or zero", func() {
+			opts.Data[flagDockerPort] = 0
+			Expect(SUT.SetConfigFromFlags(opts)).To(Succeed())
+			Expect(SUT.SSHPort).To(Equal(defaultSSHPort))
+			Expect(SUT.DockerPort).To(Equal(defaultDockerPort))
+		})
+
+		It("should use the given ports", func() {
+			opts.Data[flagSSHPort] = 2222
+			opts.Data[flagDockerPort] = 2377
+			Expect(SUT.SetConfigFromFlags(opts)).To(Succeed())
+			Expect(SUT.SSHPort).To(Equal(2222))
+			Expect(SUT.DockerPort).To(Equal(2377))
+		})
+
 		Describe("errors", func() {
+			It("should fail when the token file cannot be read", func() {
+				opts.Data[flagToken] = ""
+				opts.Data[flagTokenFile] = filepath.Join(GinkgoT().TempDir(), "missing")
+				var parseErr *FlagParseError
+				Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+				Expect(parseErr.Flag).To(Equal(flagTokenFile))
+			})
+
+			It("should fail when both the project and project template are set", func() {
+				GinkgoT().Setenv(clusterNameEnvVar, "prod")
+				opts.Data[flagProjectTemplate] = "k8s-{{.ClusterName}}"
+				err := SUT.SetConfigFromFlags(opts)
+				Expect(err).To(MatchError(ContainSubstring("mutually exclusive")))
+				var parseErr *FlagParseError
+				Expect(errors.As(err, &parseErr)).To(BeTrue())
+				Expect(parseErr.Flag).To(Equal(flagProjectTemplate))
+			})
+
+			It("should report the project template error together with the other parse errors", func() {
+				opts.Data[flagProject] = ""
+				opts.Data[flagProjectTemplate] = "k8s-{{.ClusterName"
+				opts.Data[flagMemory] = "lots"
+				err := SUT.SetConfigFromFlags(opts)
+				Expect(err).To(MatchError(ContainSubstring(`failed parsing flag "%s"`, flagProjectTemplate)))
+				Expect(err).To(MatchError(ContainSubstring(`failed parsing flag "%s"`, flagMemory)))
+				Expect(err).NotTo(MatchError(ContainSubstring(flagProject + `"`)))
+			})
+
+			DescribeTable("should fail when the project template cannot be rendered",
+				func(clusterName, projectTemplate string) {
+					GinkgoT().Setenv(clusterNameEnvVar, clusterName)
+					opts.Data[flagProject] = ""
+					opts.Data[flagProjectTemplate] = projectTemplate
+					var parseErr *FlagParseError
+					Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+					Expect(parseErr.Flag).To(Equal(flagProjectTemplate))
+				},
+				Entry("missing cluster name", "", "k8s-{{.ClusterName}}"),
+				Entry("invalid syntax", "prod", "k8s-{{.ClusterName"),
+				Entry("unknown field", "prod", "k8s-{{.Cluster}}"),
+			)
+
+			DescribeTable("should fail when the boot disk image template cannot be rendered",
+				func(release, imageTemplate, message string) {
+					GinkgoT().Setenv("IMAGE_RELEASE", release)
+					opts.Data[flagBootDiskImageID] = imageTemplate
+					var parseErr *FlagParseError
+					err := SUT.SetConfigFromFlags(opts)
+					Expect(errors.As(err, &parseErr)).To(BeTrue())
+					Expect(parseErr.Flag).To(Equal(flagBootDiskImageID))
+					Expect(err).To(MatchError(ContainSubstring(message)))
+				},
+				Entry("missing variable", "", `ubuntu-{{env "IMAGE_RELEASE"}}`, "environment variable IMAGE_RELEASE not set"),
+				Entry("invalid syntax", "2404", `ubuntu-{{env "IMAGE_RELEASE"`, "unclosed action"),
+				Entry("invalid rendered name", "24.04", `ubuntu-{{env "IMAGE_RELEASE"}}`, "must only contain"),
+			)
+
+			It("should report the boot disk image template error together with the other parse errors", func() {
+				opts.Data[flagBootDiskImageID] = `ubuntu-{{env "IMAGE_RELEASE"`
+				opts.Data[flagMemory] = "lots"
+				err := SUT.SetConfigFromFlags(opts)
+				Expect(err).To(MatchError(ContainSubstring(`failed parsing flag "%s"`, flagBootDiskImageID)))
+				Expect(err).To(MatchError(ContainSubstring(`failed parsing flag "%s"`, flagMemory)))
+			})
+
 			DescribeTable("should fail when a required string field is missing",
 				func(fields []string) {
 					for _, field := range fields {
@@ -42,6 +233,298 @@ var _ = Describe("Driver", func() {
 				Entry("diskImageId", []string{flagBootDiskImageID}),
 			)
 
+			It("should report every missing required field in a single joined error", func() {
+				fields := []string{flagHost, flagToken, flagProject, flagBootDiskImageID}
+				for _, field := range fields {
+					opts.Data[field] = ""
+				}
+
+				err := SUT.SetConfigFromFlags(opts)
+				Expect(err).To(HaveOccurred())
+				for _, field := range fields {
+					Expect(err.Error()).To(ContainSubstring(NewRequiredFlagError(field).Error()))
+				}
+			})
+
+			It("should report an invalid silo alongside other missing required fields", func() {
+				opts.Data[flagHost] = ""
+				opts.Data[flagToken] = ""
+				opts.Data[flagSilo] = "silo01.oxide.example.com"
+				opts.Data[flagDomain] = "oxide.example.com"
+
+				err := SUT.SetConfigFromFlags(opts)
+				var parseErr *FlagParseError
+				Expect(errors.As(err, &parseErr)).To(BeTrue())
+				Expect(parseErr.Flag).To(Equal(flagSilo))
+				Expect(err.Error()).To(ContainSubstring(NewRequiredFlagError(flagToken).Error()))
+			})
+
+			It("should fail when the silo is given without a domain", func() {
+				opts.Data[flagHost] = ""
+				opts.Data[flagSilo] = "silo01"
+				var requiredErr *RequiredFlagError
+				Expect(errors.As(SUT.SetConfigFromFlags(opts), &requiredErr)).To(BeTrue())
+				Expect(requiredErr.Flag).To(Equal(flagDomain))
+			})
+
+			DescribeTable("should fail when the silo or domain is invalid",
+				func(silo, domain string) {
+					opts.Data[flagHost] = ""
+					opts.Data[flagSilo] = silo
+					opts.Data[flagDomain] = domain
+					var parseErr *FlagParseError
+					Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+					Expect(parseErr.Flag).To(Equal(flagSilo))
+				},
+				Entry("silo is a domain name", "silo01.oxide.example.com", "oxide.example.com"),
+				Entry("silo is a URL", "https://silo01", "oxide.example.com"),
+				Entry("domain has a path", "silo01", "oxide.example.com/api"),
+				Entry("domain has a space", "silo01", "oxide example.com"),
+			)
+
+			It("should fail when the disk delete concurrency is negative", func() {
+				opts.Data[flagDiskDeleteConcurrency] = -1
+				var parseErr *FlagParseError
+				Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+				Expect(parseErr.Flag).To(Equal(flagDiskDeleteConcurrency))
+			})
+
+			It("should fail when a DNS server is not an IP address", func() {
+				opts.Data[flagDNSServers] = []string{"10.0.0.2", "dns.example.com"}
+				var parseErr *FlagParseError
+				Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+				Expect(parseErr.Flag).To(Equal(flagDNSServers))
+			})
+
+			DescribeTable("should fail when the instance size is outside the environment policy",
+				func(envVar, bound, flag string, value any) {
+					GinkgoT().Setenv(envVar, bound)
+					opts.Data[flag] = value
+					var parseErr *FlagParseError
+					Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+					Expect(parseErr.Flag).To(Equal(flag))
+					Expect(parseErr.Error()).To(ContainSubstring(envVar))
+				},
+				Entry("memory under the minimum", minMemoryEnvVar, "8 GiB", flagMemory, "4 GiB"),
+				Entry("memory over the maximum", maxMemoryEnvVar, "16 GiB", flagMemory, "32 GiB"),
+				Entry("vCPUs under the minimum", minVCPUsEnvVar, "4", flagVCPUs, 2),
+				Entry("vCPUs over the maximum", maxVCPUsEnvVar, "8", flagVCPUs, 16),
+			)
+
+			It("should fail when an environment policy bound is invalid", func() {
+				GinkgoT().Setenv(maxVCPUsEnvVar, "lots")
+				Expect(SUT.SetConfigFromFlags(opts)).To(MatchError(ContainSubstring(maxVCPUsEnvVar)))
+			})
+
+			It("should fail when the IP preference is invalid", func() {
+				opts.Data[flagIPPreference] = "public"
+				err := SUT.SetConfigFromFlags(opts)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring(flagIPPreference))
+			})
+
+			DescribeTable("should fail when the static network config is invalid",
+				func(staticIP, gateway, flag string) {
+					opts.Data[flagStaticIP] = staticIP
+					opts.Data[flagGateway] = gateway
+					var parseErr *FlagParseError
+					Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+					Expect(parseErr.Flag).To(Equal(flag))
+				},
+				Entry("static IP without a netmask", "172.30.0.10", "", flagStaticIP),
+				Entry("IPv6 static IP", "fd00::10/64", "", flagStaticIP),
+				Entry("gateway without a static IP", "", "172.30.0.1", flagGateway),
+				Entry("invalid gateway", "172.30.0.10/22", "gateway", flagGateway),
+				Entry("gateway outside the network", "172.30.0.10/22", "10.0.0.1", flagGateway),
+			)
+
+			It("should fail when the SSH key order is invalid", func() {
+				opts.Data[flagSSHKeyOrder] = "random"
+				var parseErr *FlagParseError
+				Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+				Expect(parseErr.Flag).To(Equal(flagSSHKeyOrder))
+			})
+
+			It("should fail when cloning the boot disk and using an image", func() {
+				opts.Data[flagCloneBootDiskFrom] = "golden-disk"
+				opts.Data[flagMemory] = "lots"
+				err := SUT.SetConfigFromFlags(opts)
+				Expect(err).To(MatchError(ContainSubstring("mutually exclusive")))
+				Expect(err).To(MatchError(ContainSubstring(`failed parsing flag "%s"`, flagCloneBootDiskFrom)))
+				Expect(err).To(MatchError(ContainSubstring(`failed parsing flag "%s"`, flagMemory)))
+			})
+
+			It("should fail when the labels are not a JSON object", func() {
+				opts.Data[flagLabels] = "team=infra"
+				var parseErr *FlagParseError
+				Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+				Expect(parseErr.Flag).To(Equal(flagLabels))
+			})
+
+			It("should fail when the seeded instance name is invalid", func() {
+				SUT = newDriver("Bob", "path")
+				opts.Data[flagInstanceNameSeed] = "seed"
+				var parseErr *FlagParseError
+				Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+				Expect(parseErr.Flag).To(Equal(flagInstanceNameSeed))
+			})
+
+			It("should fail when more than one ephemeral IP is requested", func() {
+				opts.Data[flagEphemeralIPAttach] = true
+				opts.Data[flagExternalIP] = []string{"ephemeral,public"}
+				var parseErr *FlagParseError
+				Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+				Expect(parseErr.Flag).To(Equal(flagExternalIP))
+			})
+
+			It("should fail when the name suffix strategy is invalid", func() {
+				opts.Data[flagNameSuffixStrategy] = "uuid"
+				var parseErr *FlagParseError
+				Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+				Expect(parseErr.Flag).To(Equal(flagNameSuffixStrategy))
+			})
+
+			It("should fail when the resource references kind is invalid", func() {
+				opts.Data[flagResourceReferences] = "uuid"
+				var parseErr *FlagParseError
+				Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+				Expect(parseErr.Flag).To(Equal(flagResourceReferences))
+			})
+
+			It("should fail when a reference is a name but IDs are expected", func() {
+				opts.Data[flagResourceReferences] = resourceReferencesID
+				opts.Data[flagProject] = "6f1b5d4e-8a2c-4d6e-9f01-23456789abcd"
+				err := SUT.SetConfigFromFlags(opts)
+				var parseErr *FlagParseError
+				Expect(errors.As(err, &parseErr)).To(BeTrue())
+				Expect(parseErr.Flag).To(Equal(flagBootDiskImageID))
+				Expect(err).To(MatchError(ContainSubstring(`"image" is not an ID but IDs are expected`)))
+			})
+
+			It("should fail when a reference is an ID but names are expected", func() {
+				opts.Data[flagResourceReferences] = resourceReferencesName
+				opts.Data[flagVPC] = "6f1b5d4e-8a2c-4d6e-9f01-23456789abcd"
+				err := SUT.SetConfigFromFlags(opts)
+				var parseErr *FlagParseError
+				Expect(errors.As(err, &parseErr)).To(BeTrue())
+				Expect(parseErr.Flag).To(Equal(flagVPC))
+				Expect(err).To(MatchError(ContainSubstring(`"6f1b5d4e-8a2c-4d6e-9f01-23456789abcd" is an ID but names are expected`)))
+			})
+
+			It("should fail when the initial state is invalid", func() {
+				opts.Data[flagInitialState] = "paused"
+				var parseErr *FlagParseError
+				Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+				Expect(parseErr.Flag).To(Equal(flagInitialState))
+			})
+
+			DescribeTable("should fail when the remove timeout is invalid",
+				func(removeTimeout string) {
+					opts.Data[flagRemoveTimeout] = removeTimeout
+					var parseErr *FlagParseError
+					Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+					Expect(parseErr.Flag).To(Equal(flagRemoveTimeout))
+				},
+				Entry("not a duration", "five minutes"),
+				Entry("missing unit", "5"),
+				Entry("zero", "0s"),
+				Entry("negative", "-5m"),
+			)
+
+			DescribeTable("should fail when the API base path is invalid",
+				func(basePath string) {
+					opts.Data[flagAPIBasePath] = basePath
+					var parseErr *FlagParseError
+					Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+					Expect(parseErr.Flag).To(Equal(flagAPIBasePath))
+				},
+				Entry("relative path", "oxide"),
+				Entry("URL", "https://gw.example.com/oxide"),
+				Entry("query", "/oxide?version=1"),
+				Entry("network path", "//gw.example.com/oxide"),
+			)
+
+			DescribeTable("should fail when the boot disk size relative to its image is invalid",
+				func(size string) {
+					opts.Data[flagBootDiskSize] = size
+					var parseErr *FlagParseError
+					Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+					Expect(parseErr.Flag).To(Equal(flagBootDiskSize))
+				},
+				Entry("missing size", "+"),
+				Entry("zero size", "+0 GiB"),
+				Entry("negative size", "+-10 GiB"),
+				Entry("size over the maximum disk size", "+2 TiB"),
+			)
+
+			DescribeTable("should fail when a port is out of range",
+				func(flag string, port int) {
+					opts.Data[flag] = port
+					var parseErr *FlagParseError
+					Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+					Expect(parseErr.Flag).To(Equal(flag))
+				},
+				Entry("negative SSH port", flagSSHPort, -22),
+				Entry("SSH port over 65535", flagSSHPort, 65536),
+				Entry("negative Docker port", flagDockerPort, -2376),
+				Entry("Docker port over 65535", flagDockerPort, 65536),
+			)
+
+			It("should fail when the additional disk failure policy is invalid", func() {
+				opts.Data[flagAdditionalDiskFailurePolicy] = "ignore"
+				var parseErr *FlagParseError
+				Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+				Expect(parseErr.Flag).To(Equal(flagAdditionalDiskFailurePolicy))
+			})
+
+			It("should fail when the TLS minimum version is invalid", func() {
+				opts.Data[flagTLSMinVersion] = "1.1"
+				var parseErr *FlagParseError
+				Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+				Expect(parseErr.Flag).To(Equal(flagTLSMinVersion))
+			})
+
+			It("should fail when the IP family is invalid", func() {
+				opts.Data[flagIPFamily] = "ipx"
+				var parseErr *FlagParseError
+				Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+				Expect(parseErr.Flag).To(Equal(flagIPFamily))
+			})
+
+			It("should fail when IPv6 is combined with a static IPv4 address", func() {
+				opts.Data[flagIPFamily] = ipFamilyIPv6
+				opts.Data[flagStaticIP] = "172.30.0.10/22"
+				var parseErr *FlagParseError
+				Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+				Expect(parseErr.Flag).To(Equal(flagIPFamily))
+			})
+
+			It("should fail when the create initial delay is not a duration", func() {
+				opts.Data[flagCreateInitialDelay] = "soon"
+				var parseErr *FlagParseError
+				Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+				Expect(parseErr.Flag).To(Equal(flagCreateInitialDelay))
+			})
+
+			It("should fail when the pre-create wait is not a duration", func() {
+				opts.Data[flagPreCreateWait] = "soon"
+				var parseErr *FlagParseError
+				Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+				Expect(parseErr.Flag).To(Equal(flagPreCreateWait))
+			})
+
+			DescribeTable("should fail when a transit IP is not an IPv4 network",
+				func(transitIP string) {
+					opts.Data[flagTransitIPs] = []string{"10.42.0.0/16", transitIP}
+					var parseErr *FlagParseError
+					Expect(errors.As(SUT.SetConfigFromFlags(opts), &parseErr)).To(BeTrue())
+					Expect(parseErr.Flag).To(Equal(flagTransitIPs))
+				},
+				Entry("missing prefix length", "10.42.0.0"),
+				Entry("IPv6 network", "fd00::/64"),
+				Entry("garbage", "pods"),
+			)
+
 			It("should fail when nothing is given", func() {
 				err := SUT.SetConfigFromFlags(&commandstest.FakeFlagger{
 					Data: map[string]any{},
@@ -55,47 +538,2977 @@ var _ = Describe("Driver", func() {
 		})
 	})
 
-	DescribeTable("RancherMachineState mapping is correct",
-		func(instanceState oxide.InstanceState, expectedState state.State) {
-			Expect(toRancherMachineState(instanceState)).To(Equal(expectedState))
+	Describe("unconfigured driver", func() {
+		DescribeTable("should fail with a not configured error when credentials are missing",
+			func(host, token string, missing []string) {
+				SUT.Host = host
+				SUT.Token = token
+				SUT.InstanceID = "instance-id"
+
+				for _, call := range []func() error{
+					func() error { _, err := SUT.GetState(); return err },
+					SUT.Start,
+					SUT.Stop,
+					SUT.Restart,
+					SUT.Kill,
+				} {
+					var notConfiguredErr *NotConfiguredError
+					Expect(errors.As(call(), &notConfiguredErr)).To(BeTrue())
+					Expect(notConfiguredErr.Missing).To(Equal(missing))
+				}
+			},
+			Entry("host", "", "token", []string{"host"}),
+			Entry("token", "https://silo01.oxide.example.com", "", []string{"token"}),
+			Entry("host and token", "", "", []string{"host", "token"}),
+		)
+
+		It("should report no state without creating a client before the instance is created", func() {
+			SUT.Host = ""
+			SUT.Token = ""
+
+			Expect(SUT.GetState()).To(Equal(state.None))
+			Expect(SUT.oxideClient).To(BeNil())
+		})
+
+		It("should describe the missing credentials", func() {
+			Expect(NewNotConfiguredError("host", "token").Error()).To(Equal("driver not configured: missing host/token"))
+		})
+	})
+
+	Describe("token refresh", func() {
+		var api *fakeOxideAPI
+
+		BeforeEach(func() {
+			api = newFakeOxideAPI()
+			DeferCleanup(api.Close)
+			SUT = api.driver("bob", GinkgoT().TempDir())
+			SUT.InstanceID = "instance-id"
+			SUT.Token = "expired-token"
+
+			api.handle("POST /v1/instances/{instance}/stop", func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer rotated-token" {
+					respondError(w, http.StatusUnauthorized, "Unauthorized")
+					return
+				}
+				respondJSON(w, http.StatusAccepted, oxide.Instance{Id: r.PathValue("instance")})
+			})
+		})
+
+		It("should reload the token from the token file and retry once", func() {
+			SUT.TokenFile = filepath.Join(GinkgoT().TempDir(), "token")
+			Expect(os.WriteFile(SUT.TokenFile, []byte("rotated-token\n"), 0o600)).To(Succeed())
+
+			Expect(SUT.Stop()).To(Succeed())
+			Expect(SUT.Token).To(Equal("rotated-token"))
+			Expect(api.requestsFor(http.MethodPost, "/v1/instances/instance-id/stop")).To(HaveLen(2))
+		})
+
+		It("should resend the request body and keep using the reloaded token", func() {
+			SUT.TokenFile = filepath.Join(GinkgoT().TempDir(), "token")
+			Expect(os.WriteFile(SUT.TokenFile, []byte("rotated-token\n"), 0o600)).To(Succeed())
+			SUT.AdditionalDiskIDs = []string{"data-disk-id"}
+
+			api.handle("POST /v1/instances/{instance}/stop", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusAccepted, oxide.Instance{Id: r.PathValue("instance")})
+			})
+			api.handle("GET /v1/instances/{instance}", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.Instance{Id: r.PathValue("instance"), RunState: oxide.InstanceStateStopped})
+			})
+			api.handle("POST /v1/instances/{instance}/disks/detach", func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer rotated-token" {
+					respondError(w, http.StatusUnauthorized, "Unauthorized")
+					return
+				}
+				var body oxide.DiskPath
+				Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+				Expect(body.Disk).To(Equal(oxide.NameOrId("data-disk-id")))
+				respondJSON(w, http.StatusAccepted, oxide.Disk{Id: string(body.Disk)})
+			})
+			api.handle("DELETE /v1/instances/{instance}", func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer rotated-token" {
+					respondError(w, http.StatusUnauthorized, "Unauthorized")
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			Expect(SUT.RemoveInstanceOnly()).To(Succeed())
+			Expect(SUT.Token).To(Equal("rotated-token"))
+			Expect(api.requestsFor(http.MethodPost, "/v1/instances/instance-id/disks/detach")).To(HaveLen(2))
+			Expect(api.requestsFor(http.MethodDelete, "/v1/instances/instance-id")).To(HaveLen(1))
+		})
+
+		It("should fail without retrying when there is no token file", func() {
+			Expect(SUT.Stop()).NotTo(Succeed())
+			Expect(api.requestsFor(http.MethodPost, "/v1/instances/instance-id/stop")).To(HaveLen(1))
+		})
+
+		It("should fail when the reloaded token is still unauthorized", func() {
+			SUT.TokenFile = filepath.Join(GinkgoT().TempDir(), "token")
+			Expect(os.WriteFile(SUT.TokenFile, []byte("expired-token"), 0o600)).To(Succeed())
+
+			Expect(SUT.Stop()).NotTo(Succeed())
+			Expect(api.requestsFor(http.MethodPost, "/v1/instances/instance-id/stop")).To(HaveLen(2))
+		})
+	})
+
+	Describe("GetIP", func() {
+		DescribeTable("should respect the IP preference",
+			func(preference, internalIP, externalIP, expected string) {
+				SUT.IPPreference = preference
+				SUT.InternalIPAddress = internalIP
+				SUT.ExternalIPAddress = externalIP
+				Expect(SUT.GetIP()).To(Equal(expected))
+			},
+			Entry("external preferred and attached", ipPreferenceExternal, "172.30.0.5", "203.0.113.10", "203.0.113.10"),
+			Entry("external preferred but not attached", ipPreferenceExternal, "172.30.0.5", "", "172.30.0.5"),
+			Entry("internal preferred", ipPreferenceInternal, "172.30.0.5", "203.0.113.10", "172.30.0.5"),
+		)
+
+		It("should fall back to the base driver IP address", func() {
+			SUT.IPAddress = "172.30.0.6"
+			Expect(SUT.GetIP()).To(Equal("172.30.0.6"))
+		})
+
+		It("should fail when no IP address is known", func() {
+			_, err := SUT.GetIP()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("GetExternalIPs", func() {
+		var api *fakeOxideAPI
+
+		BeforeEach(func() {
+			api = newFakeOxideAPI()
+			DeferCleanup(api.Close)
+			SUT = api.driver("bob", GinkgoT().TempDir())
+			SUT.InstanceID = "instance-id"
+		})
+
+		It("should return the ephemeral and floating IP addresses", func() {
+			api.handle("GET /v1/instances/{instance}/external-ips", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.ExternalIpResultsPage{
+					Items: []oxide.ExternalIp{
+						{Value: &oxide.ExternalIpSnat{Ip: "203.0.113.1"}},
+						{Value: &oxide.ExternalIpEphemeral{Ip: "203.0.113.10"}},
+						{Value: &oxide.ExternalIpFloating{Ip: "203.0.113.20"}},
+					},
+				})
+			})
+
+			Expect(SUT.GetExternalIPs()).To(Equal([]string{"203.0.113.10", "203.0.113.20"}))
+			Expect(api.requestsFor(http.MethodGet, "/v1/instances/instance-id/external-ips")).To(HaveLen(1))
+		})
+
+		It("should return an empty list when there are no external IP addresses", func() {
+			api.handle("GET /v1/instances/{instance}/external-ips", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.ExternalIpResultsPage{Items: []oxide.ExternalIp{}})
+			})
+
+			ips, err := SUT.GetExternalIPs()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ips).NotTo(BeNil())
+			Expect(ips).To(BeEmpty())
+		})
+
+		It("should fail when listing the external IP addresses fails", func() {
+			api.handle("GET /v1/instances/{instance}/external-ips", func(w http.ResponseWriter, r *http.Request) {
+				respondError(w, http.StatusNotFound, "ObjectNotFound")
+			})
+
+			_, err := SUT.GetExternalIPs()
+			Expect(err).To(MatchError(ContainSubstring("failed listing external IPs")))
+		})
+	})
+
+	DescribeTable("parseSize",
+		func(size string, expected uint64) {
+			Expect(parseSize(size)).To(Equal(expected))
 		},
-		Entry("creating", oxide.InstanceStateCreating, state.Starting),
-		Entry("starting", oxide.InstanceStateStarting, state.Starting),
-		Entry("running", oxide.InstanceStateRunning, state.Running),
-		Entry("stopping", oxide.InstanceStateStopping, state.Stopping),
-		Entry("stopped", oxide.InstanceStateStopped, state.Stopped),
-		Entry("repairing", oxide.InstanceStateRepairing, state.Starting),
-		Entry("rebooting", oxide.InstanceStateRebooting, state.Starting),
-		Entry("migrating", oxide.InstanceStateMigrating, state.Running),
-		Entry("failed", oxide.InstanceStateFailed, state.Error),
-		Entry("destroyed", oxide.InstanceStateDestroyed, state.NotFound),
-		Entry("unknown", oxide.InstanceState("unknown"), state.None),
+		Entry("unit suffix", "4 GiB", uint64(4<<30)),
+		Entry("decimal point", "1.5 GiB", uint64(3<<29)),
+		Entry("decimal comma", "1,5 GiB", uint64(3<<29)),
+		Entry("decimal comma without a space", "1,5GiB", uint64(3<<29)),
+		Entry("decimal comma with two digits", "0,25 GiB", uint64(1<<28)),
+		Entry("thousands separator", "1,024 MiB", uint64(1<<30)),
+		Entry("surrounding whitespace", " 512 MiB ", uint64(512<<20)),
 	)
 
-	Describe("ParseAdditionalDisk", func() {
-		DescribeTable("Success",
-			func(s string, expected AdditionalDisk) {
-				Expect(ParseAdditionalDisk(s)).To(Equal(expected))
+	It("should explain the expected size format", func() {
+		_, err := parseSize("lots")
+		Expect(err).To(MatchError(ContainSubstring(`invalid size "lots", expected a number with an optional unit suffix (e.g., 512 MiB, 4 GiB, or 1.5 TiB)`)))
+	})
+
+	DescribeTable("isUUID",
+		func(s string, expected bool) {
+			Expect(isUUID(s)).To(Equal(expected))
+		},
+		Entry("lowercase UUID", "6f1b5d4e-8a2c-4d6e-9f01-23456789abcd", true),
+		Entry("uppercase UUID", "6F1B5D4E-8A2C-4D6E-9F01-23456789ABCD", true),
+		Entry("name", "default", false),
+		Entry("name containing a UUID", "vpc-6f1b5d4e-8a2c-4d6e-9f01-23456789abcd", false),
+		Entry("misplaced hyphens", "6f1b5d4e8-a2c-4d6e-9f01-23456789abcd", false),
+		Entry("non-hex characters", "6f1b5d4e-8a2c-4d6e-9f01-23456789abcz", false),
+	)
+
+	Describe("httpClient", func() {
+		DescribeTable("should set the TLS minimum version on the transport",
+			func(minVersion string, expected uint16) {
+				SUT.TLSMinVersion = minVersion
+
+				client := SUT.httpClient()
+				Expect(client).NotTo(BeNil())
+				transport, ok := client.Transport.(*http.Transport)
+				Expect(ok).To(BeTrue())
+				Expect(transport.TLSClientConfig.MinVersion).To(Equal(expected))
 			},
-			Entry("parses integer without suffix", "21474836480", AdditionalDisk{Size: 21474836480, Label: "additional"}),
-			Entry("parses integer with suffix", "10GiB", AdditionalDisk{Size: 10737418240, Label: "additional"}),
-			Entry("parses integer with space suffix", "10 GiB", AdditionalDisk{Size: 10737418240, Label: "additional"}),
-			Entry("parses integer without suffix and label", "21474836480,data", AdditionalDisk{Size: 21474836480, Label: "data"}),
-			Entry("parses integer with suffix and label", "10GiB,data", AdditionalDisk{Size: 10737418240, Label: "data"}),
-			Entry("parses integer with space suffix and label", "10 GiB,data", AdditionalDisk{Size: 10737418240, Label: "data"}),
-			Entry("parses integer without suffix trailing comma", "21474836480,", AdditionalDisk{Size: 21474836480, Label: "additional"}),
-			Entry("parses integer with suffix trailing comma", "10GiB,", AdditionalDisk{Size: 10737418240, Label: "additional"}),
+			Entry("TLS 1.2", tlsVersion12, uint16(tls.VersionTLS12)),
+			Entry("TLS 1.3", tlsVersion13, uint16(tls.VersionTLS13)),
 		)
 
-		DescribeTable("Error",
-			func(s string) {
-				_, err := ParseAdditionalDisk(s)
-				Expect(err).To(HaveOccurred())
+		It("should use the SDK default client when unset", func() {
+			Expect(SUT.httpClient()).To(BeNil())
+		})
+
+		It("should send API requests under the base path", func() {
+			api := newFakeOxideAPI()
+			DeferCleanup(api.Close)
+			api.handle("GET /oxide/v1/projects/{project}", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.Project{Id: "project-id"})
+			})
+			SUT = api.driver("bob", GinkgoT().TempDir())
+			SUT.APIBasePath = "/oxide"
+
+			client, err := SUT.createOxideClient()
+			Expect(err).NotTo(HaveOccurred())
+			project, err := client.ProjectView(context.TODO(), oxide.ProjectViewParams{Project: "project"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(project.Id).To(Equal("project-id"))
+			Expect(api.requestsFor(http.MethodGet, "/oxide/v1/projects/project")).To(HaveLen(1))
+		})
+	})
+
+	Describe("IP families", func() {
+		v4 := oxide.PrivateIpStack{Value: &oxide.PrivateIpStackV4{Value: oxide.PrivateIpv4Stack{Ip: "172.30.0.5"}}}
+		v6 := oxide.PrivateIpStack{Value: &oxide.PrivateIpStackV6{Value: oxide.PrivateIpv6Stack{Ip: "fd00::5"}}}
+		dual := oxide.PrivateIpStack{Value: &oxide.PrivateIpStackDualStack{Value: oxide.PrivateIpStackDualStackValue{
+			V4: oxide.PrivateIpv4Stack{Ip: "172.30.0.5"},
+			V6: oxide.PrivateIpv6Stack{Ip: "fd00::5"},
+		}}}
+
+		DescribeTable("nicIPAddress",
+			func(stack oxide.PrivateIpStack, ipFamily, expected string) {
+				Expect(nicIPAddress(stack, ipFamily)).To(Equal(expected))
 			},
-			Entry("errors with empty string", ""),
-			Entry("errors with empty invalid format", ","),
-			Entry("errors with no size", ",foo"),
-			Entry("errors with invalid size unit suffix", "20 ABC,"),
+			Entry("IPv4 stack for ipv4", v4, ipFamilyIPv4, "172.30.0.5"),
+			Entry("IPv6 stack for ipv6", v6, ipFamilyIPv6, "fd00::5"),
+			Entry("dual stack for ipv4", dual, ipFamilyIPv4, "172.30.0.5"),
+			Entry("dual stack for ipv6", dual, ipFamilyIPv6, "fd00::5"),
+			Entry("dual stack for dual", dual, ipFamilyDual, "172.30.0.5"),
+		)
+
+		DescribeTable("nicIPAddress errors",
+			func(stack oxide.PrivateIpStack, ipFamily, message string) {
+				_, err := nicIPAddress(stack, ipFamily)
+				Expect(err).To(MatchError(message))
+			},
+			Entry("IPv4 stack for ipv6", v4, ipFamilyIPv6, "no IPv6 address found on network interface"),
+			Entry("IPv6 stack for ipv4", v6, ipFamilyIPv4, "no IPv4 address found on network interface"),
+			Entry("IPv6 stack for dual", v6, ipFamilyDual, "no IPv4 address found on network interface"),
+		)
+
+		DescribeTable("privateIPStackCreate",
+			func(ipFamily string, expected oxide.PrivateIpStackCreateType) {
+				SUT.IPFamily = ipFamily
+				Expect(SUT.privateIPStackCreate(nil).Type()).To(Equal(expected))
+			},
+			Entry("ipv4", ipFamilyIPv4, oxide.PrivateIpStackCreateTypeV4),
+			Entry("ipv6", ipFamilyIPv6, oxide.PrivateIpStackCreateTypeV6),
+			Entry("dual", ipFamilyDual, oxide.PrivateIpStackCreateTypeDualStack),
+		)
+
+		DescribeTable("ephemeralIPPoolSelector",
+			func(ipFamily string, expected oxide.IpVersion) {
+				selector := ephemeralIPPoolSelector("", ipFamily)
+				Expect(selector.Value).To(Equal(&oxide.PoolSelectorAuto{IpVersion: expected}))
+			},
+			Entry("ipv4", ipFamilyIPv4, oxide.IpVersionV4),
+			Entry("ipv6", ipFamilyIPv6, oxide.IpVersionV6),
+			Entry("dual", ipFamilyDual, oxide.IpVersionV4),
+		)
+
+		DescribeTable("checkIPv6Reachable",
+			func(cidrs []string, reachable bool) {
+				original := interfaceAddrs
+				DeferCleanup(func() { interfaceAddrs = original })
+				interfaceAddrs = func() ([]net.Addr, error) {
+					addrs := make([]net.Addr, len(cidrs))
+					for i, cidr := range cidrs {
+						ip, ipNet, err := net.ParseCIDR(cidr)
+						Expect(err).NotTo(HaveOccurred())
+						ipNet.IP = ip
+						addrs[i] = ipNet
+					}
+					return addrs, nil
+				}
+
+				if reachable {
+					Expect(checkIPv6Reachable()).To(Succeed())
+				} else {
+					Expect(checkIPv6Reachable()).To(MatchError(ContainSubstring("requires a global IPv6 address")))
+				}
+			},
+			Entry("global IPv6 address", []string{"10.0.0.2/24", "2001:db8::2/64"}, true),
+			Entry("IPv4 only", []string{"127.0.0.1/8", "10.0.0.2/24"}, false),
+			Entry("loopback and link-local IPv6 only", []string{"::1/128", "fe80::1/64"}, false),
+		)
+	})
+
+	Describe("viewImage", func() {
+		const imageID = "3b4c5d6e-7f80-4912-a3b4-c5d6e7f80912"
+
+		var api *fakeOxideAPI
+		var projectImageID, siloImageID string
+
+		BeforeEach(func() {
+			api = newFakeOxideAPI()
+			DeferCleanup(api.Close)
+			SUT = api.driver("bob", GinkgoT().TempDir())
+			client, err := SUT.createOxideClient()
+			Expect(err).NotTo(HaveOccurred())
+			SUT.oxideClient = client
+			projectImageID, siloImageID = "", ""
+
+			api.handle("GET /v1/images/{image}", func(w http.ResponseWriter, r *http.Request) {
+				id := siloImageID
+				switch {
+				case r.PathValue("image") == imageID:
+					id = imageID
+				case r.URL.Query().Get("project") != "":
+					id = projectImageID
+				}
+				if id == "" {
+					respondError(w, http.StatusNotFound, "ObjectNotFound")
+					return
+				}
+				respondJSON(w, http.StatusOK, oxide.Image{Id: id, Name: "ubuntu-22-04"})
+			})
+		})
+
+		It("should look up an ID directly", func() {
+			image, err := SUT.viewImage(context.TODO(), imageID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(image.Id).To(Equal(imageID))
+			Expect(api.requestsFor(http.MethodGet, "/v1/images/"+imageID)).To(HaveLen(1))
+		})
+
+		It("should resolve a project image name", func() {
+			projectImageID = "project-image-id"
+			image, err := SUT.viewImage(context.TODO(), "ubuntu-22-04")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(image.Id).To(Equal("project-image-id"))
+		})
+
+		It("should resolve a silo image name", func() {
+			siloImageID = "silo-image-id"
+			image, err := SUT.viewImage(context.TODO(), "ubuntu-22-04")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(image.Id).To(Equal("silo-image-id"))
+		})
+
+		It("should fail when the name matches a project and a silo image", func() {
+			projectImageID = "project-image-id"
+			siloImageID = "silo-image-id"
+			_, err := SUT.viewImage(context.TODO(), "ubuntu-22-04")
+			Expect(err).To(MatchError(`image name "ubuntu-22-04" is ambiguous, matching project image project-image-id and silo image silo-image-id`))
+		})
+
+		It("should fail when no image has the name", func() {
+			_, err := SUT.viewImage(context.TODO(), "ubuntu-22-04")
+			Expect(isNotFound(err)).To(BeTrue())
+		})
+
+		It("should resolve the name when creating the instance", func() {
+			api.stubCreateDependencies()
+			api.handle("GET /v1/images/{image}", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.Image{Id: imageID, Name: "ubuntu-22-04"})
+			})
+			api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+			})
+			SUT.BootDiskImageID = "ubuntu-22-04"
+			SUT.CreateInitialDelay = 0
+
+			Expect(SUT.Create()).To(Succeed())
+			Expect(SUT.BootDiskImageID).To(Equal(imageID))
+
+			var body oxide.InstanceCreate
+			Expect(json.Unmarshal(api.requestsFor(http.MethodPost, "/v1/instances")[0].Body, &body)).To(Succeed())
+			backend := body.BootDisk.Value.(*oxide.InstanceDiskAttachmentCreate).DiskBackend.Value.(*oxide.DiskBackendDistributed)
+			Expect(backend.DiskSource.Value).To(Equal(&oxide.DiskSourceImage{ImageId: imageID}))
+		})
+	})
+
+	Describe("Clone", func() {
+		BeforeEach(func() {
+			SUT.Labels = map[string]string{"team": "infra"}
+			SUT.SSHPublicKeys = []string{"operator-key"}
+			SUT.AdditionalDisks = []AdditionalDisk{{Size: 1 << 30, Label: "data"}}
+			SUT.InstanceName = "bob"
+			SUT.InstanceID = "instance-id"
+			SUT.BootDiskID = "boot-disk-id"
+			SUT.AdditionalDiskIDs = []string{"data-disk-id"}
+			SUT.SSHPublicKeyID = "ssh-key-id"
+			SUT.IPAddress = "172.30.0.5"
+			SUT.InternalIPAddress = "172.30.0.5"
+		})
+
+		It("should copy the configuration without sharing slices or maps", func() {
+			clone := SUT.Clone()
+			Expect(clone.Labels).To(Equal(SUT.Labels))
+			Expect(clone.SSHPublicKeys).To(Equal(SUT.SSHPublicKeys))
+			Expect(clone.AdditionalDisks).To(Equal(SUT.AdditionalDisks))
+
+			clone.Labels["team"] = "platform"
+			clone.SSHPublicKeys[0] = "other-key"
+			clone.AdditionalDisks[0].Label = "logs"
+			clone.MachineName = "alice"
+
+			Expect(SUT.Labels).To(HaveKeyWithValue("team", "infra"))
+			Expect(SUT.SSHPublicKeys).To(Equal([]string{"operator-key"}))
+			Expect(SUT.AdditionalDisks[0].Label).To(Equal("data"))
+			Expect(SUT.MachineName).To(Equal("bob"))
+		})
+
+		It("should reset the state of the instance", func() {
+			clone := SUT.Clone()
+			Expect(clone.InstanceName).To(BeEmpty())
+			Expect(clone.InstanceID).To(BeEmpty())
+			Expect(clone.BootDiskID).To(BeEmpty())
+			Expect(clone.AdditionalDiskIDs).To(BeEmpty())
+			Expect(clone.SSHPublicKeyID).To(BeEmpty())
+			Expect(clone.IPAddress).To(BeEmpty())
+			Expect(clone.InternalIPAddress).To(BeEmpty())
+
+			Expect(SUT.InstanceID).To(Equal("instance-id"))
+			Expect(SUT.AdditionalDiskIDs).To(Equal([]string{"data-disk-id"}))
+			Expect(SUT.IPAddress).To(Equal("172.30.0.5"))
+		})
+	})
+
+	Describe("NodeLabels", func() {
+		It("should describe the instance", func() {
+			SUT.Silo = "silo01"
+			SUT.Project = "project"
+			SUT.InstanceID = "instance-id"
+			SUT.InstanceName = "bob"
+
+			Expect(SUT.NodeLabels()).To(Equal(map[string]string{
+				"oxide.computer/silo":          "silo01",
+				"oxide.computer/project":       "project",
+				"oxide.computer/instance-id":   "instance-id",
+				"oxide.computer/instance-name": "bob",
+			}))
+		})
+
+		It("should omit unknown values", func() {
+			SUT.Project = "project"
+
+			Expect(SUT.NodeLabels()).To(Equal(map[string]string{
+				"oxide.computer/project": "project",
+			}))
+		})
+	})
+
+	Describe("Refresh", func() {
+		var api *fakeOxideAPI
+
+		BeforeEach(func() {
+			api = newFakeOxideAPI()
+			DeferCleanup(api.Close)
+			api.stubCreateDependencies()
+			SUT = api.driver("bob", GinkgoT().TempDir())
+			SUT.InstanceID = "instance-id"
+			SUT.BootDiskID = "stale-boot-disk-id"
+			SUT.AdditionalDiskIDs = []string{"stale-disk-id"}
+			SUT.IPAddress = "172.30.0.99"
+		})
+
+		It("should re-populate the instance state from the API", func() {
+			api.handle("GET /v1/instances/{instance}", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.Instance{Id: "instance-id", Name: "bob", BootDiskId: "boot-disk-id"})
+			})
+			api.handle("GET /v1/instances/{instance}/disks", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.DiskResultsPage{
+					Items: []oxide.Disk{{Id: "boot-disk-id"}, {Id: "data-disk-id"}},
+				})
+			})
+
+			Expect(SUT.Refresh()).To(Succeed())
+			Expect(SUT.BootDiskID).To(Equal("boot-disk-id"))
+			Expect(SUT.AdditionalDiskIDs).To(Equal([]string{"data-disk-id"}))
+			Expect(SUT.IPAddress).To(Equal("172.30.0.5"))
+			Expect(SUT.InstanceName).To(Equal("bob"))
+		})
+
+		It("should record the additional disks in the requested order", func() {
+			SUT.AdditionalDisks = []AdditionalDisk{
+				{Size: 1 << 30, Label: "first"},
+				{Size: 1 << 30, Label: "second"},
+				{Size: 1 << 30, Label: "third"},
+			}
+			api.handle("GET /v1/instances/{instance}", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.Instance{Id: "instance-id", Name: "bob", BootDiskId: "boot-disk-id"})
+			})
+			api.handle("GET /v1/instances/{instance}/disks", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.DiskResultsPage{
+					Items: []oxide.Disk{
+						{Id: "unrequested-disk-id", Name: "scratch"},
+						{Id: "third-disk-id", Name: "disk-02-third-bob"},
+						{Id: "boot-disk-id", Name: "bob"},
+						{Id: "first-disk-id", Name: "disk-00-first-bob"},
+						{Id: "second-disk-id", Name: "disk-01-second-bob"},
+					},
+				})
+			})
+
+			Expect(SUT.Refresh()).To(Succeed())
+			Expect(SUT.AdditionalDiskIDs).To(Equal([]string{"first-disk-id", "second-disk-id", "third-disk-id", "unrequested-disk-id"}))
+		})
+
+		It("should fail when the instance cannot be viewed", func() {
+			api.handle("GET /v1/instances/{instance}", func(w http.ResponseWriter, r *http.Request) {
+				respondError(w, http.StatusNotFound, "ObjectNotFound")
+			})
+
+			Expect(SUT.Refresh()).To(MatchError(ContainSubstring("failed viewing instance")))
+			Expect(SUT.BootDiskID).To(Equal("stale-boot-disk-id"))
+		})
+	})
+
+	Describe("Reconcile", func() {
+		var api *fakeOxideAPI
+
+		BeforeEach(func() {
+			api = newFakeOxideAPI()
+			DeferCleanup(api.Close)
+			SUT = api.driver("bob", GinkgoT().TempDir())
+			SUT.InstanceID = "instance-id"
+			SUT.InstanceName = "bob"
+			SUT.BootDiskID = "boot-disk-id"
+			SUT.VCPUS = 2
+			SUT.Memory = 4 << 30
+			SUT.AdditionalDisks = []AdditionalDisk{{Size: 1 << 30, Label: "data"}}
+
+			api.handle("GET /v1/instances/{instance}", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.Instance{Id: "instance-id", Name: "bob", Ncpus: 2, Memory: 4 << 30})
+			})
+		})
+
+		It("should succeed when the instance matches its configuration", func() {
+			api.handle("GET /v1/instances/{instance}/disks", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.DiskResultsPage{
+					Items: []oxide.Disk{{Id: "boot-disk-id", Name: "bob"}, {Id: "data-disk-id", Name: "disk-00-data-bob"}},
+				})
+			})
+
+			Expect(SUT.Reconcile()).To(Succeed())
+			Expect(api.requestsFor(http.MethodPost, "/v1/instances/instance-id/disks/attach")).To(BeEmpty())
+		})
+
+		It("should attach a missing additional disk", func() {
+			attached := false
+			api.handle("GET /v1/instances/{instance}/disks", func(w http.ResponseWriter, r *http.Request) {
+				disks := []oxide.Disk{{Id: "boot-disk-id", Name: "bob"}}
+				if attached {
+					disks = append(disks, oxide.Disk{Id: "data-disk-id", Name: "disk-00-data-bob"})
+				}
+				respondJSON(w, http.StatusOK, oxide.DiskResultsPage{Items: disks})
+			})
+			api.handle("POST /v1/instances/{instance}/disks/attach", func(w http.ResponseWriter, r *http.Request) {
+				attached = true
+				respondJSON(w, http.StatusAccepted, oxide.Disk{Id: "data-disk-id", Name: "disk-00-data-bob"})
+			})
+
+			Expect(SUT.Reconcile()).To(Succeed())
+
+			var body oxide.DiskPath
+			Expect(json.Unmarshal(api.requestsFor(http.MethodPost, "/v1/instances/instance-id/disks/attach")[0].Body, &body)).To(Succeed())
+			Expect(body.Disk).To(Equal(oxide.NameOrId("disk-00-data-bob")))
+			Expect(SUT.AdditionalDiskIDs).To(Equal([]string{"data-disk-id"}))
+		})
+
+		It("should report drift that cannot be corrected", func() {
+			api.handle("GET /v1/instances/{instance}", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.Instance{Id: "instance-id", Name: "bob", Ncpus: 4, Memory: 8 << 30})
+			})
+			api.handle("GET /v1/instances/{instance}/disks", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.DiskResultsPage{Items: []oxide.Disk{{Id: "boot-disk-id", Name: "bob"}}})
+			})
+			api.handle("POST /v1/instances/{instance}/disks/attach", func(w http.ResponseWriter, r *http.Request) {
+				respondError(w, http.StatusNotFound, "ObjectNotFound")
+			})
+
+			err := SUT.Reconcile()
+			Expect(err).To(MatchError(ContainSubstring("instance has 4 vcpus, expected 2")))
+			Expect(err).To(MatchError(ContainSubstring("instance has 8.0 GiB memory, expected 4.0 GiB")))
+			Expect(err).To(MatchError(ContainSubstring("additional disk disk-00-data-bob is not attached")))
+		})
+	})
+
+	Describe("ReattachDisksByLabel", func() {
+		var api *fakeOxideAPI
+
+		BeforeEach(func() {
+			api = newFakeOxideAPI()
+			DeferCleanup(api.Close)
+			SUT = api.driver("bob", GinkgoT().TempDir())
+			SUT.InstanceID = "new-instance-id"
+			SUT.InstanceName = "bob"
+			SUT.BootDiskID = "boot-disk-id"
+
+			var attached []oxide.Disk
+			api.handle("GET /v1/disks", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.DiskResultsPage{
+					Items: []oxide.Disk{
+						{Id: "boot-disk-id", Name: "bob", State: oxide.DiskState{Value: &oxide.DiskStateAttached{Instance: "new-instance-id"}}},
+						{Id: "data-disk-id", Name: "disk-00-data-bob", State: oxide.DiskState{Value: &oxide.DiskStateDetached{}}},
+						{Id: "data-x-disk-id", Name: "disk-02-data-x-bob", State: oxide.DiskState{Value: &oxide.DiskStateDetached{}}},
+						{Id: "logs-disk-id", Name: "disk-01-logs-bob", State: oxide.DiskState{Value: &oxide.DiskStateAttached{Instance: "alice-instance-id"}}},
+						{Id: "alice-data-disk-id", Name: "disk-00-data-alice", State: oxide.DiskState{Value: &oxide.DiskStateDetached{}}},
+					},
+				})
+			})
+			api.handle("POST /v1/instances/{instance}/disks/attach", func(w http.ResponseWriter, r *http.Request) {
+				var body oxide.DiskPath
+				Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+				attached = append(attached, oxide.Disk{Id: string(body.Disk)})
+				respondJSON(w, http.StatusAccepted, oxide.Disk{Id: string(body.Disk)})
+			})
+			api.handle("GET /v1/instances/{instance}/disks", func(w http.ResponseWriter, r *http.Request) {
+				disks := append([]oxide.Disk{{Id: "boot-disk-id", Name: "bob"}}, attached...)
+				respondJSON(w, http.StatusOK, oxide.DiskResultsPage{Items: disks})
+			})
+		})
+
+		It("should attach a detached disk with the label", func() {
+			Expect(SUT.ReattachDisksByLabel("", []string{"data"})).To(Succeed())
+
+			requests := api.requestsFor(http.MethodPost, "/v1/instances/new-instance-id/disks/attach")
+			Expect(requests).To(HaveLen(1))
+			var body oxide.DiskPath
+			Expect(json.Unmarshal(requests[0].Body, &body)).To(Succeed())
+			Expect(body.Disk).To(Equal(oxide.NameOrId("data-disk-id")))
+			Expect(SUT.AdditionalDiskIDs).To(Equal([]string{"data-disk-id"}))
+		})
+
+		It("should not attach a disk attached to another instance", func() {
+			err := SUT.ReattachDisksByLabel("", []string{"data", "logs", "missing"})
+			Expect(err).To(MatchError(ContainSubstring("disk disk-01-logs-bob is attached to another instance alice-instance-id")))
+			Expect(err).To(MatchError(ContainSubstring(`no disk with label "missing" found in project "project"`)))
+
+			Expect(api.requestsFor(http.MethodPost, "/v1/instances/new-instance-id/disks/attach")).To(HaveLen(1))
+			Expect(SUT.AdditionalDiskIDs).To(Equal([]string{"data-disk-id"}))
+		})
+
+		It("should only match disks of this machine", func() {
+			SUT = api.driver("alice", GinkgoT().TempDir())
+			SUT.InstanceID = "alice-instance-id"
+			SUT.InstanceName = "alice"
+			SUT.BootDiskID = "boot-disk-id"
+
+			Expect(SUT.ReattachDisksByLabel("", []string{"data"})).To(Succeed())
+			Expect(SUT.AdditionalDiskIDs).To(Equal([]string{"alice-data-disk-id"}))
+		})
+
+		It("should match the disks of the replaced instance when given", func() {
+			SUT = api.driver("carol", GinkgoT().TempDir())
+			SUT.InstanceID = "new-instance-id"
+			SUT.InstanceName = "carol"
+			SUT.BootDiskID = "boot-disk-id"
+
+			Expect(SUT.ReattachDisksByLabel("bob", []string{"data"})).To(Succeed())
+			Expect(SUT.AdditionalDiskIDs).To(Equal([]string{"data-disk-id"}))
+		})
+
+		It("should not record the reattached disks as created", func() {
+			SUT.AdditionalDisks = []AdditionalDisk{{Size: 1024, Label: "data"}}
+			SUT.AdditionalDiskIDs = []string{}
+			SUT.CreatedAdditionalDiskIDs = []string{}
+
+			Expect(SUT.ReattachDisksByLabel("", []string{"data"})).To(Succeed())
+			Expect(SUT.AdditionalDiskIDs).To(Equal([]string{"data-disk-id"}))
+			Expect(SUT.CreatedAdditionalDiskIDs).To(BeEmpty())
+			Expect(SUT.removableDiskIDs()).To(Equal([]string{"boot-disk-id"}))
+		})
+
+		It("should not match a label that prefixes another label", func() {
+			Expect(SUT.ReattachDisksByLabel("", []string{"data-x", "data"})).To(Succeed())
+			Expect(SUT.AdditionalDiskIDs).To(Equal([]string{"data-x-disk-id", "data-disk-id"}))
+		})
+	})
+
+	Describe("PreCreateCheck", func() {
+		var api *fakeOxideAPI
+
+		BeforeEach(func() {
+			api = newFakeOxideAPI()
+			DeferCleanup(api.Close)
+			SUT = api.driver("bob", GinkgoT().TempDir())
+
+			api.handle("GET /v1/projects/{project}", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.Project{Id: "project-id"})
+			})
+			api.handle("GET /v1/vpc-subnets/{subnet}", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.VpcSubnet{Id: "subnet-id", Ipv4Block: "172.30.0.0/29"})
+			})
+			api.handle("GET /v1/vpc-subnets/{subnet}/network-interfaces", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.InstanceNetworkInterfaceResultsPage{
+					Items: []oxide.InstanceNetworkInterface{{Id: "nic-id"}},
+				})
+			})
+			api.handle("GET /v1/images/{image}", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.Image{Id: "image-id", Size: 1 << 30})
+			})
+
+			lookups := 0
+			api.handle("GET /v1/vpcs/{vpc}", func(w http.ResponseWriter, r *http.Request) {
+				lookups++
+				if lookups == 1 {
+					respondError(w, http.StatusNotFound, "ObjectNotFound")
+					return
+				}
+				respondJSON(w, http.StatusOK, oxide.Vpc{Id: "vpc-id"})
+			})
+
+			interval := preCreateWaitInterval
+			preCreateWaitInterval = 10 * time.Millisecond
+			DeferCleanup(func() { preCreateWaitInterval = interval })
+		})
+
+		It("should detect the SSH user from the image", func() {
+			api.handle("GET /v1/images/{image}", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.Image{Id: "image-id", Name: "noble", Os: "ubuntu", Size: 1 << 30})
+			})
+			SUT.PreCreateWait = time.Second
+			SUT.SSHUser = defaultSSHUser
+			SUT.SSHUserAuto = true
+			Expect(SUT.PreCreateCheck()).To(Succeed())
+			Expect(SUT.SSHUser).To(Equal("ubuntu"))
+		})
+
+		It("should keep the given SSH user", func() {
+			api.handle("GET /v1/images/{image}", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.Image{Id: "image-id", Name: "noble", Os: "ubuntu", Size: 1 << 30})
+			})
+			SUT.PreCreateWait = time.Second
+			SUT.SSHUser = "admin"
+			Expect(SUT.PreCreateCheck()).To(Succeed())
+			Expect(SUT.SSHUser).To(Equal("admin"))
+		})
+
+		It("should resolve the project and image to IDs and the VPC and subnet to names", func() {
+			SUT.VPC = "6f1b5d4e-8a2c-4d6e-9f01-23456789abcd"
+			SUT.Subnet = "7a2c6e5f-9b3d-4e7f-a012-3456789abcde"
+			api.handle("GET /v1/vpcs/{vpc}", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.Vpc{Id: r.PathValue("vpc"), Name: "default"})
+			})
+			api.handle("GET /v1/vpc-subnets/{subnet}", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.VpcSubnet{Id: r.PathValue("subnet"), Name: "workers", Ipv4Block: "172.30.0.0/29"})
+			})
+
+			Expect(SUT.PreCreateCheck()).To(Succeed())
+			Expect(SUT.Project).To(Equal("project"))
+			Expect(SUT.ProjectID).To(Equal("project-id"))
+			Expect(api.requestsFor(http.MethodGet, "/v1/vpcs/6f1b5d4e-8a2c-4d6e-9f01-23456789abcd")[0].Query).To(Equal("project=project-id"))
+			Expect(SUT.VPC).To(Equal("default"))
+			Expect(SUT.Subnet).To(Equal("workers"))
+			Expect(SUT.BootDiskImageID).To(Equal("image-id"))
+		})
+
+		It("should fail when a derived resource name is invalid", func() {
+			SUT.AdditionalDisks = []AdditionalDisk{{Size: 1 << 30, Label: "Data"}}
+
+			Expect(SUT.PreCreateCheck()).To(MatchError(ContainSubstring(`name "disk-00-Data-bob" must only contain lowercase letters`)))
+			Expect(api.requests).To(BeEmpty())
+		})
+
+		It("should fail when the project does not exist", func() {
+			api.handle("GET /v1/projects/{project}", func(w http.ResponseWriter, r *http.Request) {
+				respondError(w, http.StatusNotFound, "ObjectNotFound")
+			})
+
+			Expect(SUT.PreCreateCheck()).To(MatchError(ContainSubstring(`project "project" not found`)))
+			Expect(api.requestsFor(http.MethodGet, "/v1/vpcs/default")).To(BeEmpty())
+			Expect(api.requestsFor(http.MethodPost, "/v1/me/ssh-keys")).To(BeEmpty())
+		})
+
+		It("should fail when the token is not allowed to access the project", func() {
+			api.handle("GET /v1/projects/{project}", func(w http.ResponseWriter, r *http.Request) {
+				respondError(w, http.StatusForbidden, "Forbidden")
+			})
+
+			Expect(SUT.PreCreateCheck()).To(MatchError(ContainSubstring(`token is not allowed to access project "project"`)))
+			Expect(api.requestsFor(http.MethodGet, "/v1/vpcs/default")).To(BeEmpty())
+		})
+
+		It("should fail when the subnet does not exist", func() {
+			SUT.PreCreateWait = time.Second
+			SUT.Subnet = "defualt"
+			api.handle("GET /v1/vpc-subnets/{subnet}", func(w http.ResponseWriter, r *http.Request) {
+				respondError(w, http.StatusNotFound, "ObjectNotFound")
+			})
+
+			Expect(SUT.PreCreateCheck()).To(MatchError(ContainSubstring(`subnet "defualt" not found in vpc "default"`)))
+		})
+
+		It("should wait for the VPC to appear", func() {
+			SUT.PreCreateWait = time.Second
+			Expect(SUT.PreCreateCheck()).To(Succeed())
+			Expect(api.requestsFor(http.MethodGet, "/v1/vpcs/default")).To(HaveLen(2))
+		})
+
+		It("should fail on the first lookup when not waiting", func() {
+			err := SUT.PreCreateCheck()
+			Expect(err).To(MatchError(ContainSubstring(`vpc "default" not found in project "project"`)))
+			Expect(api.requestsFor(http.MethodGet, "/v1/vpcs/default")).To(HaveLen(1))
+		})
+
+		It("should skip the resource validation when configured", func() {
+			SUT.SkipPreCreateValidation = true
+
+			Expect(SUT.PreCreateCheck()).To(Succeed())
+			Expect(api.requests).To(BeEmpty())
+		})
+
+		Describe("respecting maintenance", func() {
+			BeforeEach(func() {
+				SUT.RespectMaintenance = true
+				SUT.SkipPreCreateValidation = true
+			})
+
+			It("should fail when the API is unavailable for maintenance", func() {
+				api.handle("GET /v1/ping", func(w http.ResponseWriter, r *http.Request) {
+					respondError(w, http.StatusServiceUnavailable, "ServiceUnavailable")
+				})
+
+				Expect(SUT.PreCreateCheck()).To(MatchError(ContainSubstring("is unavailable, likely for maintenance")))
+			})
+
+			It("should succeed when the API is available", func() {
+				api.handle("GET /v1/ping", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusOK, oxide.Ping{Status: oxide.PingStatusOk})
+				})
+
+				Expect(SUT.PreCreateCheck()).To(Succeed())
+				Expect(api.requestsFor(http.MethodGet, "/v1/ping")).To(HaveLen(1))
+			})
+		})
+
+		It("should still size the boot disk when skipping the resource validation", func() {
+			SUT.SkipPreCreateValidation = true
+			SUT.BootDiskSizeAuto = true
+
+			Expect(SUT.PreCreateCheck()).To(Succeed())
+			Expect(SUT.BootDiskSize).To(Equal(uint64(1 << 30)))
+			Expect(api.requestsFor(http.MethodGet, "/v1/vpcs/default")).To(BeEmpty())
+		})
+
+		It("should wait for the boot disk image to be finalized", func() {
+			images := 0
+			api.handle("GET /v1/images/{image}", func(w http.ResponseWriter, r *http.Request) {
+				images++
+				if images == 1 {
+					respondError(w, http.StatusNotFound, "ObjectNotFound")
+					return
+				}
+				respondJSON(w, http.StatusOK, oxide.Image{Id: "image-id", Size: 1 << 30})
+			})
+			SUT.PreCreateWait = time.Second
+
+			Expect(SUT.PreCreateCheck()).To(Succeed())
+			Expect(api.requestsFor(http.MethodGet, "/v1/images/image")).To(HaveLen(2))
+		})
+
+		It("should fail when the boot disk image does not appear", func() {
+			api.handle("GET /v1/images/{image}", func(w http.ResponseWriter, r *http.Request) {
+				respondError(w, http.StatusNotFound, "ObjectNotFound")
+			})
+			SUT.PreCreateWait = 50 * time.Millisecond
+			api.handle("GET /v1/vpcs/{vpc}", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.Vpc{Id: "vpc-id"})
+			})
+
+			Expect(SUT.PreCreateCheck()).To(MatchError(ContainSubstring(`image "image" not found`)))
+		})
+
+		It("should fail when the subnet has no free addresses", func() {
+			api.handle("GET /v1/vpc-subnets/{subnet}/network-interfaces", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.InstanceNetworkInterfaceResultsPage{
+					Items: []oxide.InstanceNetworkInterface{{Id: "nic-a"}, {Id: "nic-b"}},
+				})
+			})
+			SUT.PreCreateWait = time.Second
+
+			err := SUT.PreCreateCheck()
+			Expect(err).To(MatchError(`subnet "default" has no free addresses: 2 of 2 addresses in 172.30.0.0/29 in use`))
+		})
+
+		DescribeTable("should fail when the ephemeral IP pool is incompatible",
+			func(pool oxide.SiloIpPool, status int, message string) {
+				api.handle("GET /v1/ip-pools/{pool}", func(w http.ResponseWriter, r *http.Request) {
+					if status != http.StatusOK {
+						respondError(w, status, "ObjectNotFound")
+						return
+					}
+					respondJSON(w, status, pool)
+				})
+				SUT.PreCreateWait = time.Second
+				SUT.EphemeralIPAttach = true
+				SUT.EphemeralIPPool = "pool"
+
+				Expect(SUT.PreCreateCheck()).To(MatchError(ContainSubstring(message)))
+			},
+			Entry("not linked to the silo", oxide.SiloIpPool{}, http.StatusNotFound, `ip pool "pool" not found or not linked to the silo`),
+			Entry("multicast", oxide.SiloIpPool{IpVersion: oxide.IpVersionV4, PoolType: oxide.IpPoolTypeMulticast}, http.StatusOK, `ip pool "pool" is a multicast pool`),
+			Entry("IPv6", oxide.SiloIpPool{IpVersion: oxide.IpVersionV6, PoolType: oxide.IpPoolTypeUnicast}, http.StatusOK, `ip pool "pool" has v6 addresses`),
+		)
+
+		DescribeTable("should check the ephemeral IP pool against the IP family",
+			func(ipFamily string, ipVersion oxide.IpVersion, message string) {
+				api.handle("GET /v1/ip-pools/{pool}", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusOK, oxide.SiloIpPool{IpVersion: ipVersion, PoolType: oxide.IpPoolTypeUnicast})
+				})
+				SUT.PreCreateWait = time.Second
+				SUT.IPFamily = ipFamily
+				SUT.EphemeralIPAttach = true
+				SUT.EphemeralIPPool = "pool"
+
+				if message == "" {
+					Expect(SUT.PreCreateCheck()).To(Succeed())
+				} else {
+					Expect(SUT.PreCreateCheck()).To(MatchError(ContainSubstring(message)))
+				}
+			},
+			Entry("IPv6 pool for ipv6", ipFamilyIPv6, oxide.IpVersionV6, ""),
+			Entry("IPv4 pool for ipv6", ipFamilyIPv6, oxide.IpVersionV4, `ip pool "pool" has v4 addresses but the network interface in subnet "default" of vpc "default" only has an ipv6 address`),
+			Entry("IPv4 pool for dual", ipFamilyDual, oxide.IpVersionV4, ""),
+			Entry("IPv6 pool for dual", ipFamilyDual, oxide.IpVersionV6, ""),
+		)
+
+		It("should not check the IPv4 addresses of the subnet for ipv6", func() {
+			api.handle("GET /v1/vpc-subnets/{subnet}/network-interfaces", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.InstanceNetworkInterfaceResultsPage{
+					Items: []oxide.InstanceNetworkInterface{{Id: "nic-a"}, {Id: "nic-b"}},
+				})
+			})
+			SUT.PreCreateWait = time.Second
+			SUT.IPFamily = ipFamilyIPv6
+
+			Expect(SUT.PreCreateCheck()).To(Succeed())
+			Expect(api.requestsFor(http.MethodGet, "/v1/vpc-subnets/default/network-interfaces")).To(BeEmpty())
+		})
+
+		It("should accept a compatible ephemeral IP pool", func() {
+			api.handle("GET /v1/ip-pools/{pool}", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.SiloIpPool{IpVersion: oxide.IpVersionV4, PoolType: oxide.IpPoolTypeUnicast})
+			})
+			SUT.PreCreateWait = time.Second
+			SUT.EphemeralIPAttach = true
+			SUT.EphemeralIPPool = "pool"
+
+			Expect(SUT.PreCreateCheck()).To(Succeed())
+		})
+
+		It("should check the pool of each ephemeral external IP", func() {
+			api.handle("GET /v1/ip-pools/{pool}", func(w http.ResponseWriter, r *http.Request) {
+				if r.PathValue("pool") == "v6-pool" {
+					respondJSON(w, http.StatusOK, oxide.SiloIpPool{IpVersion: oxide.IpVersionV6, PoolType: oxide.IpPoolTypeUnicast})
+					return
+				}
+				respondJSON(w, http.StatusOK, oxide.SiloIpPool{IpVersion: oxide.IpVersionV4, PoolType: oxide.IpPoolTypeUnicast})
+			})
+			SUT.PreCreateWait = time.Second
+			SUT.ExternalIPs = []ExternalIP{
+				{Type: externalIPTypeFloating, NameOrID: "web"},
+				{Type: externalIPTypeEphemeral},
+				{Type: externalIPTypeEphemeral, NameOrID: "v4-pool"},
+				{Type: externalIPTypeEphemeral, NameOrID: "v6-pool"},
+			}
+
+			Expect(SUT.PreCreateCheck()).To(MatchError(ContainSubstring(`ip pool "v6-pool" has v6 addresses`)))
+			Expect(api.requestsFor(http.MethodGet, "/v1/ip-pools/v4-pool")).To(HaveLen(1))
+			Expect(api.requestsFor(http.MethodGet, "/v1/ip-pools/web")).To(BeEmpty())
+		})
+
+		It("should report every missing anti-affinity group", func() {
+			api.handle("GET /v1/anti-affinity-groups/{group}", func(w http.ResponseWriter, r *http.Request) {
+				if r.PathValue("group") == "present" {
+					respondJSON(w, http.StatusOK, oxide.AntiAffinityGroup{Id: "group-id", Name: "present"})
+					return
+				}
+				respondError(w, http.StatusNotFound, "ObjectNotFound")
+			})
+			SUT.PreCreateWait = time.Second
+			SUT.AntiAffinityGroups = []string{"present", "missing-a", "ensured", "missing-b"}
+			SUT.EnsureAntiAffinityGroup = "ensured"
+
+			err := SUT.PreCreateCheck()
+			Expect(err).To(MatchError(ContainSubstring(`anti-affinity group "missing-a" not found`)))
+			Expect(err).To(MatchError(ContainSubstring(`anti-affinity group "missing-b" not found`)))
+			Expect(api.requestsFor(http.MethodGet, "/v1/anti-affinity-groups/ensured")).To(BeEmpty())
+		})
+
+		It("should report every conflicting and missing affinity group", func() {
+			api.handle("GET /v1/anti-affinity-groups/{group}", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.AntiAffinityGroup{Id: "anti-affinity-group-id", Name: oxide.Name(r.PathValue("group"))})
+			})
+			api.handle("GET /v1/affinity-groups/{group}", func(w http.ResponseWriter, r *http.Request) {
+				if r.PathValue("group") == "missing" {
+					respondError(w, http.StatusNotFound, "ObjectNotFound")
+					return
+				}
+				respondJSON(w, http.StatusOK, oxide.AffinityGroup{Id: "affinity-group-id", Name: oxide.Name(r.PathValue("group"))})
+			})
+			SUT.PreCreateWait = time.Second
+			SUT.AntiAffinityGroups = []string{"spread", "both"}
+			SUT.EnsureAntiAffinityGroup = "ensured"
+			SUT.AffinityGroups = []string{"together", "both", "ensured", "missing"}
+
+			err := SUT.PreCreateCheck()
+			Expect(err).To(MatchError(ContainSubstring(`group "both" cannot be both an affinity and an anti-affinity group`)))
+			Expect(err).To(MatchError(ContainSubstring(`group "ensured" cannot be both an affinity and an anti-affinity group`)))
+			Expect(err).To(MatchError(ContainSubstring(`affinity group "missing" not found`)))
+			Expect(err).NotTo(MatchError(ContainSubstring(`"together"`)))
+			Expect(err).NotTo(MatchError(ContainSubstring(`"spread"`)))
+			Expect(api.requestsFor(http.MethodGet, "/v1/affinity-groups/together")).To(HaveLen(1))
+		})
+
+		It("should pass when every affinity group exists", func() {
+			api.handle("GET /v1/affinity-groups/{group}", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.AffinityGroup{Id: "affinity-group-id", Name: oxide.Name(r.PathValue("group"))})
+			})
+			SUT.PreCreateWait = time.Second
+			SUT.AffinityGroups = []string{"together"}
+
+			Expect(SUT.PreCreateCheck()).To(Succeed())
+		})
+
+		Describe("automatic boot disk size", func() {
+			BeforeEach(func() {
+				api.handle("GET /v1/images/{image}", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusOK, oxide.Image{Id: r.PathValue("image"), Size: 3 * 1024 * 1024 * 1024})
+				})
+				SUT.PreCreateWait = time.Second
+				SUT.BootDiskSizeAuto = true
+			})
+
+			It("should size the boot disk to fit its image", func() {
+				Expect(SUT.PreCreateCheck()).To(Succeed())
+				Expect(SUT.BootDiskSize).To(Equal(uint64(3 * 1024 * 1024 * 1024)))
+				Expect(api.requestsFor(http.MethodGet, "/v1/images/image")).To(HaveLen(2))
+			})
+
+			It("should fail without a boot disk image", func() {
+				SUT.BootDiskImageID = ""
+				Expect(SUT.PreCreateCheck()).To(MatchError(ContainSubstring("requires a boot disk image")))
+			})
+
+			It("should size the boot disk to fit its image plus the delta", func() {
+				SUT.BootDiskSizeDelta = 10 << 30
+				Expect(SUT.PreCreateCheck()).To(Succeed())
+				Expect(SUT.BootDiskSize).To(Equal(uint64(13 << 30)))
+			})
+
+			It("should fail when the delta exceeds the maximum disk size", func() {
+				SUT.BootDiskSizeDelta = 1022 << 30
+				Expect(SUT.PreCreateCheck()).To(MatchError(ContainSubstring("exceeds the maximum disk size 1023 GiB")))
+			})
+		})
+
+		Describe("additional disk images", func() {
+			BeforeEach(func() {
+				SUT.PreCreateWait = time.Second
+				api.handle("GET /v1/images/{image}", func(w http.ResponseWriter, r *http.Request) {
+					if r.PathValue("image") == "missing-id" {
+						respondError(w, http.StatusNotFound, "ObjectNotFound")
+						return
+					}
+					respondJSON(w, http.StatusOK, oxide.Image{Id: "image-id", Size: 10 * 1024 * 1024 * 1024})
+				})
+			})
+
+			It("should succeed when the disk is at least the image size", func() {
+				SUT.AdditionalDisks = []AdditionalDisk{{Size: 10 * 1024 * 1024 * 1024, ImageID: "image-id"}}
+				Expect(SUT.PreCreateCheck()).To(Succeed())
+			})
+
+			It("should fail when the disk is smaller than the image", func() {
+				SUT.AdditionalDisks = []AdditionalDisk{{Size: 5 * 1024 * 1024 * 1024, ImageID: "image-id"}}
+				Expect(SUT.PreCreateCheck()).To(MatchError(ContainSubstring(`smaller than image "image-id"`)))
+			})
+
+			It("should look up an image name in the project and record its ID", func() {
+				SUT.AdditionalDisks = []AdditionalDisk{{Size: 10 * 1024 * 1024 * 1024, ImageID: "data-image"}}
+				Expect(SUT.PreCreateCheck()).To(Succeed())
+				Expect(SUT.AdditionalDisks[0].ImageID).To(Equal("image-id"))
+
+				requests := api.requestsFor(http.MethodGet, "/v1/images/data-image")
+				Expect(requests).NotTo(BeEmpty())
+				Expect(requests[0].Query).To(ContainSubstring("project="))
+			})
+
+			It("should fail when the image does not exist", func() {
+				SUT.AdditionalDisks = []AdditionalDisk{{Size: 10 * 1024 * 1024 * 1024, ImageID: "missing-id"}}
+				Expect(SUT.PreCreateCheck()).To(MatchError(ContainSubstring(`image "missing-id" not found`)))
+			})
+		})
+	})
+
+	Describe("Create", func() {
+		var api *fakeOxideAPI
+
+		BeforeEach(func() {
+			api = newFakeOxideAPI()
+			DeferCleanup(api.Close)
+			api.stubCreateDependencies()
+			SUT = api.driver("bob", GinkgoT().TempDir())
+		})
+
+		It("should upload the SSH public key with the configured description", func() {
+			api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+			})
+			SUT.SSHKeyDescription = "cluster-a node bob"
+
+			Expect(SUT.Create()).To(Succeed())
+
+			var body oxide.SshKeyCreate
+			Expect(json.Unmarshal(api.requestsFor(http.MethodPost, "/v1/me/ssh-keys")[0].Body, &body)).To(Succeed())
+			Expect(body.Description).To(Equal("cluster-a node bob"))
+		})
+
+		It("should record no additional disks when the boot disk is the only disk", func() {
+			api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+			})
+			api.handle("GET /v1/instances/{instance}/disks", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.DiskResultsPage{Items: []oxide.Disk{{Id: "boot-disk-id", Name: "disk-bob"}}})
+			})
+
+			Expect(SUT.Create()).To(Succeed())
+			Expect(SUT.AdditionalDiskIDs).NotTo(BeNil())
+			Expect(SUT.AdditionalDiskIDs).To(BeEmpty())
+
+			encoded, err := json.Marshal(SUT)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(encoded)).To(ContainSubstring(`"AdditionalDiskIDs":[]`))
+		})
+
+		It("should fit the derived resource names of a long suffixed machine name", func() {
+			api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+			})
+			SUT = api.driver(strings.Repeat("a", 62), GinkgoT().TempDir())
+			SUT.NameSuffixAlways = true
+			SUT.AdditionalDisks = []AdditionalDisk{{Size: 1 << 30, Label: "data"}}
+
+			Expect(SUT.Create()).To(Succeed())
+
+			var body struct {
+				Name     string `json:"name"`
+				BootDisk struct {
+					Name string `json:"name"`
+				} `json:"boot_disk"`
+				Disks []struct {
+					Name string `json:"name"`
+				} `json:"disks"`
+				NetworkInterfaces struct {
+					Params []struct {
+						Name string `json:"name"`
+					} `json:"params"`
+				} `json:"network_interfaces"`
+			}
+			Expect(json.Unmarshal(api.requestsFor(http.MethodPost, "/v1/instances")[0].Body, &body)).To(Succeed())
+			Expect(body.Name).To(Equal(SUT.InstanceName))
+			Expect(body.BootDisk.Name).To(Equal(derivedName("disk-", SUT.InstanceName)))
+			Expect(body.Disks).To(HaveLen(1))
+			Expect(body.Disks[0].Name).To(Equal(AdditionalDisk{Label: "data"}.Name(SUT.InstanceName, 0)))
+			Expect(body.NetworkInterfaces.Params).To(HaveLen(1))
+			Expect(body.NetworkInterfaces.Params[0].Name).To(Equal(derivedName("nic-", SUT.InstanceName)))
+
+			for _, name := range []string{body.Name, body.BootDisk.Name, body.Disks[0].Name, body.NetworkInterfaces.Params[0].Name} {
+				Expect(validateName(name)).To(Succeed())
+			}
+			Expect(body.BootDisk.Name).To(HavePrefix("disk-aaaa"))
+			Expect(body.Disks[0].Name).To(HavePrefix("disk-00-data-aaaa"))
+			Expect(body.NetworkInterfaces.Params[0].Name).To(HavePrefix("nic-aaaa"))
+		})
+
+		Describe("multiple network interfaces", func() {
+			nic := func(name, ip string, primary bool) oxide.InstanceNetworkInterface {
+				return oxide.InstanceNetworkInterface{
+					Name:    oxide.Name(name),
+					Primary: &primary,
+					IpStack: oxide.PrivateIpStack{
+						Value: &oxide.PrivateIpStackV4{
+							Value: oxide.PrivateIpv4Stack{Ip: ip},
+						},
+					},
+				}
+			}
+
+			BeforeEach(func() {
+				api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+				})
+				api.handle("GET /v1/network-interfaces", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusOK, oxide.InstanceNetworkInterfaceResultsPage{
+						Items: []oxide.InstanceNetworkInterface{
+							nic("storage", "172.31.0.5", true),
+							nic("nic-bob", "172.30.0.5", false),
+							nic("management", "172.32.0.5", false),
+						},
+					})
+				})
+				api.stubRemoveDependencies()
+			})
+
+			It("should use the network interface the driver created by default", func() {
+				Expect(SUT.Create()).To(Succeed())
+				Expect(SUT.GetIP()).To(Equal("172.30.0.5"))
+				Expect(SUT.IPAddress).To(Equal("172.30.0.5"))
+			})
+
+			It("should use the named network interface", func() {
+				SUT.PrimaryNIC = "management"
+
+				Expect(SUT.Create()).To(Succeed())
+				Expect(SUT.GetIP()).To(Equal("172.32.0.5"))
+				Expect(SUT.IPAddress).To(Equal("172.32.0.5"))
+			})
+
+			It("should list the available network interfaces when the named one is missing", func() {
+				SUT.PrimaryNIC = "public"
+
+				err := SUT.Create()
+				Expect(err).To(MatchError(ContainSubstring(`network interface "public" not found`)))
+				Expect(err).To(MatchError(ContainSubstring("storage, nic-bob, management")))
+			})
+		})
+
+		Describe("user data as a seed ISO", func() {
+			BeforeEach(func() {
+				SUT.UserDataAsSeedISO = true
+				SUT.UserDataFile = filepath.Join(GinkgoT().TempDir(), "user-data")
+				Expect(os.WriteFile(SUT.UserDataFile, []byte("#cloud-config\nhostname: bob\n"), 0o600)).To(Succeed())
+
+				api.handle("POST /v1/disks", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusCreated, oxide.Disk{Id: "seed-disk-id", Name: "seed-bob"})
+				})
+				for _, action := range []string{"bulk-write-start", "bulk-write", "bulk-write-stop", "finalize"} {
+					api.handle("POST /v1/disks/{disk}/"+action, func(w http.ResponseWriter, r *http.Request) {
+						w.WriteHeader(http.StatusNoContent)
+					})
+				}
+				api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+				})
+				api.handle("GET /v1/instances/{instance}/disks", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusOK, oxide.DiskResultsPage{Items: []oxide.Disk{
+						{Id: "boot-disk-id", Name: "disk-bob"},
+						{Id: "seed-disk-id", Name: "seed-bob"},
+					}})
+				})
+				api.stubRemoveDependencies()
+			})
+
+			It("should write the user data to a seed disk and attach it", func() {
+				Expect(SUT.Create()).To(Succeed())
+				Expect(SUT.SeedDiskID).To(Equal("seed-disk-id"))
+				Expect(SUT.AdditionalDiskIDs).To(BeEmpty())
+
+				var diskBody struct {
+					Name        string `json:"name"`
+					Size        int    `json:"size"`
+					DiskBackend struct {
+						DiskSource struct {
+							Type      string `json:"type"`
+							BlockSize int    `json:"block_size"`
+						} `json:"disk_source"`
+					} `json:"disk_backend"`
+				}
+				Expect(json.Unmarshal(api.requestsFor(http.MethodPost, "/v1/disks")[0].Body, &diskBody)).To(Succeed())
+				Expect(diskBody.Name).To(Equal("seed-bob"))
+				Expect(diskBody.Size).To(Equal(seedDiskSize))
+				Expect(diskBody.DiskBackend.DiskSource.Type).To(Equal("importing_blocks"))
+				Expect(diskBody.DiskBackend.DiskSource.BlockSize).To(Equal(seedDiskBlockSize))
+
+				// The ISO is written from the start of the disk, which is then
+				// finalized before the instance is created.
+				Expect(api.requestsFor(http.MethodPost, "/v1/disks/seed-disk-id/bulk-write-start")).To(HaveLen(1))
+				writes := api.requestsFor(http.MethodPost, "/v1/disks/seed-disk-id/bulk-write")
+				Expect(writes).To(HaveLen(1))
+				var writeBody oxide.ImportBlocksBulkWrite
+				Expect(json.Unmarshal(writes[0].Body, &writeBody)).To(Succeed())
+				Expect(writeBody.Offset).To(HaveValue(BeZero()))
+				image, err := base64.StdEncoding.DecodeString(writeBody.Base64EncodedData)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(readISOFiles(image)).To(HaveKeyWithValue("USER-DATA;1", []byte("#cloud-config\nhostname: bob\n")))
+				Expect(readISOFiles(image)).To(HaveKeyWithValue("META-DATA;1", []byte("instance-id: bob\nlocal-hostname: bob\n")))
+				Expect(api.requestsFor(http.MethodPost, "/v1/disks/seed-disk-id/bulk-write-stop")).To(HaveLen(1))
+				Expect(api.requestsFor(http.MethodPost, "/v1/disks/seed-disk-id/finalize")).To(HaveLen(1))
+
+				var body oxide.InstanceCreate
+				Expect(json.Unmarshal(api.requestsFor(http.MethodPost, "/v1/instances")[0].Body, &body)).To(Succeed())
+				Expect(body.Disks).To(HaveLen(1))
+				Expect(body.Disks[0].Value).To(Equal(&oxide.InstanceDiskAttachmentAttach{Name: "seed-bob"}))
+				Expect(body.UserData).To(BeEmpty())
+			})
+
+			It("should delete the seed disk when creating the instance fails", func() {
+				api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+					respondError(w, http.StatusBadRequest, "InvalidRequest")
+				})
+
+				Expect(SUT.Create()).NotTo(Succeed())
+				Expect(api.requestsFor(http.MethodDelete, "/v1/disks/seed-disk-id")).To(HaveLen(1))
+				Expect(SUT.SeedDiskID).To(BeEmpty())
+			})
+
+			It("should stop the import and delete the seed disk when a write fails", func() {
+				var stopped bool
+				api.handle("POST /v1/disks/{disk}/bulk-write", func(w http.ResponseWriter, r *http.Request) {
+					respondError(w, http.StatusServiceUnavailable, "ServiceUnavailable")
+				})
+				api.handle("POST /v1/disks/{disk}/bulk-write-stop", func(w http.ResponseWriter, r *http.Request) {
+					stopped = true
+					w.WriteHeader(http.StatusNoContent)
+				})
+				api.handle("DELETE /v1/disks/{disk}", func(w http.ResponseWriter, r *http.Request) {
+					// Disks in the importing state cannot be deleted.
+					if !stopped {
+						respondError(w, http.StatusBadRequest, "InvalidRequest")
+						return
+					}
+					w.WriteHeader(http.StatusNoContent)
+				})
+
+				Expect(SUT.Create()).To(MatchError(ContainSubstring("failed writing seed disk seed-bob")))
+				Expect(api.requestsFor(http.MethodPost, "/v1/disks/seed-disk-id/bulk-write-stop")).To(HaveLen(1))
+				Expect(api.requestsFor(http.MethodPost, "/v1/disks/seed-disk-id/finalize")).To(BeEmpty())
+				Expect(api.requestsFor(http.MethodDelete, "/v1/disks/seed-disk-id")).To(HaveLen(1))
+				Expect(api.requestsFor(http.MethodPost, "/v1/instances")).To(BeEmpty())
+				Expect(SUT.SeedDiskID).To(BeEmpty())
+			})
+
+			It("should delete the seed disk on removal", func() {
+				Expect(SUT.Create()).To(Succeed())
+				Expect(SUT.Remove()).To(Succeed())
+				Expect(api.requestsFor(http.MethodDelete, "/v1/disks/seed-disk-id")).To(HaveLen(1))
+			})
+		})
+
+		It("should distinguish the disks it created from disks attached out of band", func() {
+			api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+			})
+			api.handle("GET /v1/instances/{instance}/disks", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.DiskResultsPage{Items: []oxide.Disk{
+					{Id: "boot-disk-id", Name: "disk-bob"},
+					{Id: "user-disk-id", Name: "scratch"},
+					{Id: "data-disk-id", Name: "disk-00-data-bob"},
+				}})
+			})
+			SUT.AdditionalDisks = []AdditionalDisk{{Size: 1024, Label: "data"}}
+
+			Expect(SUT.Create()).To(Succeed())
+			Expect(SUT.AdditionalDiskIDs).To(Equal([]string{"data-disk-id", "user-disk-id"}))
+			Expect(SUT.CreatedAdditionalDiskIDs).To(Equal([]string{"data-disk-id"}))
+		})
+
+		DescribeTable("should request an ephemeral IP and connect over it",
+			func(externalIP string, poolSelector map[string]string) {
+				api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+				})
+				api.handle("GET /v1/instances/{instance}/external-ips", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusOK, oxide.ExternalIpResultsPage{
+						Items: []oxide.ExternalIp{
+							{Value: &oxide.ExternalIpSnat{Ip: "203.0.113.1"}},
+							{Value: &oxide.ExternalIpEphemeral{Ip: "203.0.113.10"}},
+						},
+					})
+				})
+				ephemeralIP, err := ParseExternalIP(externalIP)
+				Expect(err).NotTo(HaveOccurred())
+				SUT.ExternalIPs = []ExternalIP{ephemeralIP}
+
+				Expect(SUT.Create()).To(Succeed())
+
+				var body struct {
+					ExternalIps []struct {
+						Type         string            `json:"type"`
+						PoolSelector map[string]string `json:"pool_selector"`
+					} `json:"external_ips"`
+				}
+				Expect(json.Unmarshal(api.requestsFor(http.MethodPost, "/v1/instances")[0].Body, &body)).To(Succeed())
+				Expect(body.ExternalIps).To(HaveLen(1))
+				Expect(body.ExternalIps[0].Type).To(Equal("ephemeral"))
+				Expect(body.ExternalIps[0].PoolSelector).To(Equal(poolSelector))
+
+				Expect(SUT.ExternalIPAddress).To(Equal("203.0.113.10"))
+				Expect(SUT.GetSSHHostname()).To(Equal("203.0.113.10"))
+			},
+			Entry("named pool", "ephemeral,public", map[string]string{"type": "explicit", "pool": "public"}),
+			Entry("default pool", "ephemeral", map[string]string{"type": "auto", "ip_version": "v4"}),
+		)
+
+		It("should attach floating IPs and connect over them", func() {
+			api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+			})
+			api.handle("GET /v1/instances/{instance}/external-ips", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.ExternalIpResultsPage{
+					Items: []oxide.ExternalIp{
+						{Value: &oxide.ExternalIpEphemeral{Ip: "203.0.113.10"}},
+						{Value: &oxide.ExternalIpFloating{Id: "floating-ip-id", Ip: "203.0.113.20"}},
+					},
+				})
+			})
+			SUT.EphemeralIPAttach = true
+			SUT.ExternalIPs = []ExternalIP{{Type: externalIPTypeFloating, NameOrID: "web"}}
+
+			Expect(SUT.Create()).To(Succeed())
+
+			var body struct {
+				ExternalIps []struct {
+					Type       string `json:"type"`
+					FloatingIp string `json:"floating_ip"`
+				} `json:"external_ips"`
+			}
+			Expect(json.Unmarshal(api.requestsFor(http.MethodPost, "/v1/instances")[0].Body, &body)).To(Succeed())
+			Expect(body.ExternalIps).To(HaveLen(2))
+			Expect(body.ExternalIps[0].Type).To(Equal("floating"))
+			Expect(body.ExternalIps[0].FloatingIp).To(Equal("web"))
+			Expect(body.ExternalIps[1].Type).To(Equal("ephemeral"))
+
+			Expect(SUT.FloatingIPIDs).To(Equal([]string{"floating-ip-id"}))
+			Expect(SUT.ExternalIPAddress).To(Equal("203.0.113.20"))
+			Expect(SUT.GetIP()).To(Equal("203.0.113.20"))
+		})
+
+		DescribeTable("should create the instance in the requested initial state",
+			func(initialState string, start bool) {
+				api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+				})
+				SUT.InitialState = initialState
+
+				Expect(SUT.Create()).To(Succeed())
+
+				var body oxide.InstanceCreate
+				Expect(json.Unmarshal(api.requestsFor(http.MethodPost, "/v1/instances")[0].Body, &body)).To(Succeed())
+				Expect(body.Start).To(HaveValue(Equal(start)))
+			},
+			Entry("running", initialStateRunning, true),
+			Entry("stopped", initialStateStopped, false),
+		)
+
+		DescribeTable("should add the instance to its affinity groups before starting it",
+			func(initialState string, starts int) {
+				api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+				})
+				var members int
+				api.handle("POST /v1/affinity-groups/{group}/members/instance/{instance}", func(w http.ResponseWriter, r *http.Request) {
+					members++
+					respondJSON(w, http.StatusCreated, oxide.AffinityGroupMember{})
+				})
+				api.handle("POST /v1/instances/{instance}/start", func(w http.ResponseWriter, r *http.Request) {
+					// The instance cannot be added to affinity groups once running.
+					Expect(members).To(Equal(2))
+					respondJSON(w, http.StatusAccepted, oxide.Instance{Id: r.PathValue("instance")})
+				})
+				SUT.InitialState = initialState
+				SUT.AffinityGroups = []string{"together", "close"}
+
+				Expect(SUT.Create()).To(Succeed())
+
+				var body oxide.InstanceCreate
+				Expect(json.Unmarshal(api.requestsFor(http.MethodPost, "/v1/instances")[0].Body, &body)).To(Succeed())
+				Expect(body.Start).To(HaveValue(BeFalse()))
+				Expect(api.requestsFor(http.MethodPost, "/v1/affinity-groups/together/members/instance/instance-id")).To(HaveLen(1))
+				Expect(api.requestsFor(http.MethodPost, "/v1/affinity-groups/close/members/instance/instance-id")).To(HaveLen(1))
+				Expect(api.requestsFor(http.MethodPost, "/v1/instances/instance-id/start")).To(HaveLen(starts))
+			},
+			Entry("running", initialStateRunning, 1),
+			Entry("stopped", initialStateStopped, 0),
+		)
+
+		It("should fail when the instance cannot be added to an affinity group", func() {
+			api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+			})
+			api.handle("POST /v1/affinity-groups/{group}/members/instance/{instance}", func(w http.ResponseWriter, r *http.Request) {
+				respondError(w, http.StatusBadRequest, "InvalidRequest")
+			})
+			SUT.AffinityGroups = []string{"together"}
+
+			Expect(SUT.Create()).To(MatchError(ContainSubstring(`failed adding instance to affinity group "together"`)))
+			Expect(SUT.InstanceID).To(Equal("instance-id"))
+			Expect(api.requestsFor(http.MethodPost, "/v1/instances/instance-id/start")).To(BeEmpty())
+		})
+
+		It("should embed the owning instance in the default disk descriptions", func() {
+			api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+			})
+			SUT.BootDiskDescription = defaultDescription
+			SUT.AdditionalDisks = []AdditionalDisk{{Size: 1024, Label: "data", Description: "data"}}
+
+			Expect(SUT.Create()).To(Succeed())
+
+			var body struct {
+				BootDisk struct {
+					Description string `json:"description"`
+				} `json:"boot_disk"`
+				Disks []struct {
+					Description string `json:"description"`
+				} `json:"disks"`
+			}
+			Expect(json.Unmarshal(api.requestsFor(http.MethodPost, "/v1/instances")[0].Body, &body)).To(Succeed())
+			Expect(body.BootDisk.Description).To(Equal(defaultDescription + " owner=bob"))
+			Expect(body.Disks).To(HaveLen(1))
+			Expect(body.Disks[0].Description).To(Equal("data"))
+		})
+
+		Describe("failing after the instance was created", func() {
+			BeforeEach(func() {
+				api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+				})
+				api.handle("GET /v1/network-interfaces", func(w http.ResponseWriter, r *http.Request) {
+					respondError(w, http.StatusBadRequest, "InvalidRequest")
+				})
+				api.stubRemoveDependencies()
+			})
+
+			It("should preserve the instance when requested", func() {
+				SUT.PreserveOnFailure = true
+
+				Expect(SUT.Create()).NotTo(Succeed())
+				Expect(SUT.InstancePreserved).To(BeTrue())
+
+				Expect(SUT.Remove()).To(Succeed())
+				Expect(api.requestsFor(http.MethodPost, "/v1/instances/instance-id/stop")).To(BeEmpty())
+				Expect(api.requestsFor(http.MethodDelete, "/v1/instances/instance-id")).To(BeEmpty())
+				Expect(api.requestsFor(http.MethodDelete, "/v1/disks/boot-disk-id")).To(BeEmpty())
+			})
+
+			It("should remove the instance by default", func() {
+				Expect(SUT.Create()).NotTo(Succeed())
+				Expect(SUT.InstancePreserved).To(BeFalse())
+
+				Expect(SUT.Remove()).To(Succeed())
+				Expect(api.requestsFor(http.MethodDelete, "/v1/instances/instance-id")).To(HaveLen(1))
+			})
+		})
+
+		Describe("ambiguous instance create failures", func() {
+			var creates int
+
+			BeforeEach(func() {
+				creates = 0
+				api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+					creates++
+					if creates == 1 {
+						// Drop the connection so the outcome is unknown to the driver.
+						conn, _, err := w.(http.Hijacker).Hijack()
+						Expect(err).NotTo(HaveOccurred())
+						Expect(conn.Close()).To(Succeed())
+						return
+					}
+					respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+				})
+			})
+
+			It("should adopt the instance when it was created", func() {
+				api.handle("GET /v1/instances/{instance}", func(w http.ResponseWriter, r *http.Request) {
+					Expect(r.PathValue("instance")).To(Equal("bob"))
+					respondJSON(w, http.StatusOK, oxide.Instance{Id: "adopted-instance-id", BootDiskId: "boot-disk-id", TimeCreated: oxide.NewPointer(time.Now())})
+				})
+
+				Expect(SUT.Create()).To(Succeed())
+				Expect(creates).To(Equal(1))
+				Expect(SUT.InstanceID).To(Equal("adopted-instance-id"))
+			})
+
+			It("should not adopt an instance created before the create started", func() {
+				api.handle("GET /v1/instances/{instance}", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusOK, oxide.Instance{Id: "other-instance-id", TimeCreated: oxide.NewPointer(time.Now().Add(-time.Hour))})
+				})
+
+				Expect(SUT.Create()).To(MatchError(errInstanceNotOwned))
+				Expect(creates).To(Equal(1))
+				Expect(SUT.InstanceID).To(BeEmpty())
+			})
+
+			It("should retry with a suffixed name when the instance was not created by the driver", func() {
+				api.handle("GET /v1/instances/{instance}", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusOK, oxide.Instance{Id: "other-instance-id", TimeCreated: oxide.NewPointer(time.Now().Add(-time.Hour))})
+				})
+				SUT.NameConflictRetry = true
+
+				Expect(SUT.Create()).To(Succeed())
+				Expect(creates).To(Equal(2))
+				Expect(SUT.InstanceID).To(Equal("instance-id"))
+				Expect(SUT.InstanceName).To(MatchRegexp(`^bob-[a-z0-9]{5}$`))
+			})
+
+			It("should retry the create when the instance was not created", func() {
+				api.handle("GET /v1/instances/{instance}", func(w http.ResponseWriter, r *http.Request) {
+					respondError(w, http.StatusNotFound, "ObjectNotFound")
+				})
+
+				Expect(SUT.Create()).To(Succeed())
+				Expect(creates).To(Equal(2))
+				Expect(SUT.InstanceID).To(Equal("instance-id"))
+			})
+		})
+
+		Describe("creating additional disks beforehand", func() {
+			BeforeEach(func() {
+				SUT.AdditionalDisks = []AdditionalDisk{
+					{Size: 1024, Label: "a"},
+					{Size: 1024, Label: "b"},
+					{Size: 1024, Label: "c"},
+				}
+				SUT.DiskCreateConcurrency = 2
+				api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+				})
+			})
+
+			It("should attach the created disks by name", func() {
+				api.handle("POST /v1/disks", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusCreated, oxide.Disk{Id: "disk-id"})
+				})
+
+				Expect(SUT.Create()).To(Succeed())
+				Expect(api.requestsFor(http.MethodPost, "/v1/disks")).To(HaveLen(3))
+
+				var body map[string]any
+				Expect(json.Unmarshal(api.requestsFor(http.MethodPost, "/v1/instances")[0].Body, &body)).To(Succeed())
+				Expect(body["disks"]).To(Equal([]any{
+					map[string]any{"type": "attach", "name": "disk-00-a-bob"},
+					map[string]any{"type": "attach", "name": "disk-01-b-bob"},
+					map[string]any{"type": "attach", "name": "disk-02-c-bob"},
+				}))
+			})
+
+			It("should delete the created disks when the instance create fails", func() {
+				api.handle("POST /v1/disks", func(w http.ResponseWriter, r *http.Request) {
+					var body oxide.DiskCreate
+					Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+					respondJSON(w, http.StatusCreated, oxide.Disk{Id: "id-" + string(body.Name)})
+				})
+				api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+					respondError(w, http.StatusInternalServerError, "Internal")
+				})
+				api.handle("DELETE /v1/disks/{disk}", func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusNoContent)
+				})
+
+				Expect(SUT.Create()).To(MatchError(ContainSubstring("Internal")))
+				Expect(api.requestsFor(http.MethodPost, "/v1/instances")).To(HaveLen(1))
+				Expect(api.requestsFor(http.MethodDelete, "/v1/disks/id-disk-00-a-bob")).To(HaveLen(1))
+				Expect(api.requestsFor(http.MethodDelete, "/v1/disks/id-disk-01-b-bob")).To(HaveLen(1))
+				Expect(api.requestsFor(http.MethodDelete, "/v1/disks/id-disk-02-c-bob")).To(HaveLen(1))
+			})
+
+			Describe("when one fails", func() {
+				BeforeEach(func() {
+					api.handle("POST /v1/disks", func(w http.ResponseWriter, r *http.Request) {
+						var body oxide.DiskCreate
+						Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+						if body.Name == "disk-01-b-bob" {
+							respondError(w, http.StatusInsufficientStorage, "InsufficientCapacity")
+							return
+						}
+						respondJSON(w, http.StatusCreated, oxide.Disk{Id: "id-" + string(body.Name)})
+					})
+					api.handle("DELETE /v1/disks/{disk}", func(w http.ResponseWriter, r *http.Request) {
+						w.WriteHeader(http.StatusNoContent)
+					})
+				})
+
+				It("should attempt every disk and roll back the created disks by default", func() {
+					Expect(SUT.Create()).To(MatchError(ContainSubstring("failed creating disk disk-01-b-bob")))
+					Expect(api.requestsFor(http.MethodPost, "/v1/disks")).To(HaveLen(3))
+					Expect(api.requestsFor(http.MethodPost, "/v1/instances")).To(BeEmpty())
+					Expect(api.requestsFor(http.MethodDelete, "/v1/disks/id-disk-00-a-bob")).To(HaveLen(1))
+					Expect(api.requestsFor(http.MethodDelete, "/v1/disks/id-disk-02-c-bob")).To(HaveLen(1))
+				})
+
+				It("should create the instance without the failed disk when warning", func() {
+					SUT.AdditionalDiskFailurePolicy = additionalDiskFailurePolicyWarn
+
+					Expect(SUT.Create()).To(Succeed())
+					Expect(api.requestsFor(http.MethodDelete, "/v1/disks/id-disk-00-a-bob")).To(BeEmpty())
+
+					var body map[string]any
+					Expect(json.Unmarshal(api.requestsFor(http.MethodPost, "/v1/instances")[0].Body, &body)).To(Succeed())
+					Expect(body["disks"]).To(Equal([]any{
+						map[string]any{"type": "attach", "name": "disk-00-a-bob"},
+						map[string]any{"type": "attach", "name": "disk-02-c-bob"},
+					}))
+				})
+			})
+		})
+
+		It("should wait the initial delay before returning", func() {
+			api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+			})
+			SUT.CreateInitialDelay = 200 * time.Millisecond
+
+			start := time.Now()
+			Expect(SUT.Create()).To(Succeed())
+			Expect(time.Since(start)).To(BeNumerically(">=", SUT.CreateInitialDelay))
+		})
+
+		It("should not verify SSH authentication when the instance is left stopped", func() {
+			api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+			})
+			SUT.InitialState = initialStateStopped
+			SUT.VerifySSHAuth = true
+
+			Expect(SUT.Create()).To(Succeed())
+		})
+
+		It("should configure the transit IPs on the network interface", func() {
+			api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+			})
+			SUT.TransitIPs = []string{"10.42.0.0/16"}
+
+			Expect(SUT.Create()).To(Succeed())
+
+			var body oxide.InstanceCreate
+			Expect(json.Unmarshal(api.requestsFor(http.MethodPost, "/v1/instances")[0].Body, &body)).To(Succeed())
+			nics := body.NetworkInterfaces.Value.(*oxide.InstanceNetworkInterfaceAttachmentCreate)
+			ipConfig := nics.Params[0].IpConfig.Value.(*oxide.PrivateIpStackCreateV4)
+			Expect(ipConfig.Value.TransitIps).To(Equal([]oxide.Ipv4Net{"10.42.0.0/16"}))
+		})
+
+		DescribeTable("should pass the SSH public keys in the configured order",
+			func(order string, expected []oxide.NameOrId) {
+				api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+				})
+				SUT.SSHPublicKeys = []string{"operator-a", "operator-b"}
+				SUT.SSHKeyOrder = order
+
+				Expect(SUT.Create()).To(Succeed())
+
+				var body oxide.InstanceCreate
+				Expect(json.Unmarshal(api.requestsFor(http.MethodPost, "/v1/instances")[0].Body, &body)).To(Succeed())
+				Expect(body.SshPublicKeys).To(Equal(expected))
+			},
+			Entry("generated first", sshKeyOrderGeneratedFirst, []oxide.NameOrId{"ssh-key-id", "operator-a", "operator-b"}),
+			Entry("additional first", sshKeyOrderAdditionalFirst, []oxide.NameOrId{"operator-a", "operator-b", "ssh-key-id"}),
+		)
+
+		It("should create the network interface with the static IP address", func() {
+			api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+			})
+			SUT.StaticIP = "172.30.0.10/22"
+			SUT.Gateway = "172.30.0.1"
+
+			Expect(SUT.Create()).To(Succeed())
+
+			var body oxide.InstanceCreate
+			Expect(json.Unmarshal(api.requestsFor(http.MethodPost, "/v1/instances")[0].Body, &body)).To(Succeed())
+			nics := body.NetworkInterfaces.Value.(*oxide.InstanceNetworkInterfaceAttachmentCreate)
+			ipConfig := nics.Params[0].IpConfig.Value.(*oxide.PrivateIpStackCreateV4)
+			Expect(ipConfig.Value.Ip.Value).To(Equal(&oxide.Ipv4AssignmentExplicit{Value: "172.30.0.10"}))
+
+			userData, err := base64.StdEncoding.DecodeString(body.UserData)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(userData)).To(ContainSubstring("path: /etc/netplan/90-oxide-static.yaml"))
+			Expect(string(userData)).To(ContainSubstring("via: 172.30.0.1"))
+		})
+
+		It("should add the generated SSH public key to the user data", func() {
+			api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+			})
+			SUT.InjectKeyViaUserData = true
+			SUT.UserDataFile = filepath.Join(GinkgoT().TempDir(), "user-data")
+			Expect(os.WriteFile(SUT.UserDataFile, []byte("#cloud-config\npackages:\n  - curl\n"), 0o600)).To(Succeed())
+
+			Expect(SUT.Create()).To(Succeed())
+
+			publicKey, err := os.ReadFile(SUT.GetSSHKeyPath() + ".pub")
+			Expect(err).NotTo(HaveOccurred())
+
+			var body oxide.InstanceCreate
+			Expect(json.Unmarshal(api.requestsFor(http.MethodPost, "/v1/instances")[0].Body, &body)).To(Succeed())
+			userData, err := base64.StdEncoding.DecodeString(body.UserData)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(userData)).To(HavePrefix("#cloud-config\n"))
+			Expect(string(userData)).To(ContainSubstring("- curl"))
+			Expect(string(userData)).To(ContainSubstring("ssh_authorized_keys:\n    - " + strings.TrimSpace(string(publicKey))))
+		})
+
+		It("should create additional disks from their images", func() {
+			api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+			})
+			SUT.AdditionalDisks = []AdditionalDisk{
+				{Size: 10 * 1024 * 1024 * 1024, Label: "data", ImageID: "image-id"},
+				{Size: 10 * 1024 * 1024 * 1024, Label: "scratch"},
+			}
+
+			Expect(SUT.Create()).To(Succeed())
+
+			var body oxide.InstanceCreate
+			Expect(json.Unmarshal(api.requestsFor(http.MethodPost, "/v1/instances")[0].Body, &body)).To(Succeed())
+			Expect(body.Disks).To(HaveLen(2))
+
+			diskSource := func(disk oxide.InstanceDiskAttachment) any {
+				backend := disk.Value.(*oxide.InstanceDiskAttachmentCreate).DiskBackend.Value.(*oxide.DiskBackendDistributed)
+				return backend.DiskSource.Value
+			}
+			Expect(diskSource(body.Disks[0])).To(Equal(&oxide.DiskSourceImage{ImageId: "image-id"}))
+			Expect(diskSource(body.Disks[1])).To(Equal(&oxide.DiskSourceBlank{BlockSize: oxide.BlockSize(4096)}))
+		})
+
+		It("should create additional disks on their backends", func() {
+			api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+			})
+			SUT.AdditionalDisks = []AdditionalDisk{
+				{Size: 10 * 1024 * 1024 * 1024, Label: "etcd", Backend: diskBackendLocal},
+				{Size: 10 * 1024 * 1024 * 1024, Label: "data", Backend: diskBackendDistributed},
+			}
+
+			Expect(SUT.Create()).To(Succeed())
+
+			var body oxide.InstanceCreate
+			Expect(json.Unmarshal(api.requestsFor(http.MethodPost, "/v1/instances")[0].Body, &body)).To(Succeed())
+			Expect(body.Disks).To(HaveLen(2))
+
+			diskBackendType := func(disk oxide.InstanceDiskAttachment) oxide.DiskBackendType {
+				return disk.Value.(*oxide.InstanceDiskAttachmentCreate).DiskBackend.Type()
+			}
+			Expect(diskBackendType(body.Disks[0])).To(Equal(oxide.DiskBackendTypeLocal))
+			Expect(diskBackendType(body.Disks[1])).To(Equal(oxide.DiskBackendTypeDistributed))
+		})
+
+		Describe("existing additional disks", func() {
+			BeforeEach(func() {
+				api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+				})
+				api.handle("GET /v1/disks", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusOK, oxide.DiskResultsPage{
+						Items: []oxide.Disk{{Id: "leftover-id", Name: "disk-00-data-bob"}},
+					})
+				})
+				SUT.AdditionalDisks = []AdditionalDisk{
+					{Size: 10 * 1024 * 1024 * 1024, Label: "data"},
+					{Size: 10 * 1024 * 1024 * 1024, Label: "scratch"},
+				}
+			})
+
+			It("should fail before creating the instance", func() {
+				err := SUT.Create()
+				Expect(err).To(MatchError(errAdditionalDisksExist))
+				Expect(err).To(MatchError(ContainSubstring("disk-00-data-bob")))
+				Expect(api.requestsFor(http.MethodPost, "/v1/instances")).To(BeEmpty())
+			})
+
+			It("should attach the existing disks when adopting them", func() {
+				SUT.AdoptExistingDisks = true
+
+				Expect(SUT.Create()).To(Succeed())
+
+				var body oxide.InstanceCreate
+				Expect(json.Unmarshal(api.requestsFor(http.MethodPost, "/v1/instances")[0].Body, &body)).To(Succeed())
+				Expect(body.Disks[0].Value).To(Equal(&oxide.InstanceDiskAttachmentAttach{Name: "disk-00-data-bob"}))
+				Expect(body.Disks[1].Value).To(BeAssignableToTypeOf(&oxide.InstanceDiskAttachmentCreate{}))
+			})
+
+			It("should only record the disks it created as created when adopting", func() {
+				api.handle("GET /v1/instances/{instance}/disks", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusOK, oxide.DiskResultsPage{Items: []oxide.Disk{
+						{Id: "boot-disk-id", Name: "disk-bob"},
+						{Id: "data-disk-id", Name: "disk-00-data-bob"},
+						{Id: "scratch-disk-id", Name: "disk-01-scratch-bob"},
+					}})
+				})
+				SUT.AdoptExistingDisks = true
+
+				Expect(SUT.Create()).To(Succeed())
+				Expect(SUT.AdditionalDiskIDs).To(Equal([]string{"data-disk-id", "scratch-disk-id"}))
+				Expect(SUT.CreatedAdditionalDiskIDs).To(Equal([]string{"scratch-disk-id"}))
+			})
+
+			It("should retry with a suffixed name when name conflict retry is set", func() {
+				SUT.NameConflictRetry = true
+
+				Expect(SUT.Create()).To(Succeed())
+				Expect(SUT.InstanceName).To(MatchRegexp(`^bob-[a-z0-9]{5}$`))
+			})
+		})
+
+		Describe("ensure anti-affinity group", func() {
+			BeforeEach(func() {
+				SUT.EnsureAntiAffinityGroup = "pool"
+				api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+				})
+				api.handle("POST /v1/anti-affinity-groups", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusCreated, oxide.AntiAffinityGroup{Id: "group-id", Name: "pool"})
+				})
+			})
+
+			It("should create the group when absent", func() {
+				api.handle("GET /v1/anti-affinity-groups/{group}", func(w http.ResponseWriter, r *http.Request) {
+					respondError(w, http.StatusNotFound, "ObjectNotFound")
+				})
+
+				Expect(SUT.Create()).To(Succeed())
+				Expect(api.requestsFor(http.MethodPost, "/v1/anti-affinity-groups")).To(HaveLen(1))
+				Expect(SUT.CreatedAntiAffinityGroupID).To(Equal("group-id"))
+
+				var body oxide.InstanceCreate
+				Expect(json.Unmarshal(api.requestsFor(http.MethodPost, "/v1/instances")[0].Body, &body)).To(Succeed())
+				Expect(body.AntiAffinityGroups).To(ConsistOf(oxide.NameOrId("pool")))
+			})
+
+			It("should reference the existing group when present", func() {
+				api.handle("GET /v1/anti-affinity-groups/{group}", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusOK, oxide.AntiAffinityGroup{Id: "existing-id", Name: "pool"})
+				})
+
+				Expect(SUT.Create()).To(Succeed())
+				Expect(api.requestsFor(http.MethodPost, "/v1/anti-affinity-groups")).To(BeEmpty())
+				Expect(SUT.CreatedAntiAffinityGroupID).To(BeEmpty())
+			})
+
+			It("should fail when the group lookup fails", func() {
+				api.handle("GET /v1/anti-affinity-groups/{group}", func(w http.ResponseWriter, r *http.Request) {
+					respondError(w, http.StatusForbidden, "Forbidden")
+				})
+
+				Expect(SUT.Create()).NotTo(Succeed())
+				Expect(api.requestsFor(http.MethodPost, "/v1/instances")).To(BeEmpty())
+			})
+		})
+
+		Describe("name conflicts", func() {
+			BeforeEach(func() {
+				attempts := 0
+				api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+					attempts++
+					if attempts == 1 {
+						respondError(w, http.StatusBadRequest, "ObjectAlreadyExists")
+						return
+					}
+					respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+				})
+			})
+
+			It("should retry with a suffixed name when enabled", func() {
+				SUT.NameConflictRetry = true
+				Expect(SUT.Create()).To(Succeed())
+
+				requests := api.requestsFor(http.MethodPost, "/v1/instances")
+				Expect(requests).To(HaveLen(2))
+
+				var body oxide.InstanceCreate
+				Expect(json.Unmarshal(requests[1].Body, &body)).To(Succeed())
+				Expect(string(body.Name)).To(MatchRegexp(`^bob-[a-z0-9]{5}$`))
+				Expect(SUT.InstanceName).To(Equal(string(body.Name)))
+				Expect(SUT.InstanceID).To(Equal("instance-id"))
+
+				bootDisk := body.BootDisk.Value.(*oxide.InstanceDiskAttachmentCreate)
+				Expect(string(bootDisk.Name)).To(Equal("disk-" + SUT.InstanceName))
+			})
+
+			It("should retry with a timestamp suffix when requested", func() {
+				SUT.NameConflictRetry = true
+				SUT.NameSuffixStrategy = nameSuffixStrategyTimestamp
+				before := timestampNameSuffix(time.Now())
+
+				Expect(SUT.Create()).To(Succeed())
+				Expect(api.requestsFor(http.MethodPost, "/v1/instances")).To(HaveLen(2))
+				Expect(SUT.InstanceName).To(MatchRegexp(`^bob-[a-z0-9]{8}$`))
+				Expect(strings.TrimPrefix(SUT.InstanceName, "bob-") >= before).To(BeTrue())
+			})
+
+			It("should delete the disks created under the conflicting name before retrying", func() {
+				api.handle("POST /v1/disks", func(w http.ResponseWriter, r *http.Request) {
+					var body oxide.DiskCreate
+					Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+					respondJSON(w, http.StatusCreated, oxide.Disk{Id: "id-" + string(body.Name)})
+				})
+				api.handle("DELETE /v1/disks/{disk}", func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusNoContent)
+				})
+				SUT.NameConflictRetry = true
+				SUT.AdditionalDisks = []AdditionalDisk{{Size: 1024, Label: "data"}}
+				SUT.DiskCreateConcurrency = 1
+
+				Expect(SUT.Create()).To(Succeed())
+				Expect(api.requestsFor(http.MethodPost, "/v1/disks")).To(HaveLen(2))
+				Expect(api.requestsFor(http.MethodDelete, "/v1/disks/id-disk-00-data-bob")).To(HaveLen(1))
+				Expect(api.requestsFor(http.MethodDelete, "/v1/disks/id-disk-00-data-"+SUT.InstanceName)).To(BeEmpty())
+			})
+
+			It("should fail without retrying when the suffix strategy is none", func() {
+				SUT.NameConflictRetry = true
+				SUT.NameSuffixStrategy = nameSuffixStrategyNone
+
+				Expect(SUT.Create()).NotTo(Succeed())
+				Expect(api.requestsFor(http.MethodPost, "/v1/instances")).To(HaveLen(1))
+			})
+
+			It("should fail without retrying when disabled", func() {
+				Expect(SUT.Create()).NotTo(Succeed())
+				Expect(api.requestsFor(http.MethodPost, "/v1/instances")).To(HaveLen(1))
+				Expect(SUT.InstanceName).To(BeEmpty())
+			})
+		})
+
+		DescribeTable("should always append the name suffix when requested",
+			func(strategy, pattern string) {
+				api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+					respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+				})
+				SUT.NameSuffixAlways = true
+				SUT.NameSuffixStrategy = strategy
+
+				Expect(SUT.Create()).To(Succeed())
+
+				var body oxide.InstanceCreate
+				Expect(json.Unmarshal(api.requestsFor(http.MethodPost, "/v1/instances")[0].Body, &body)).To(Succeed())
+				Expect(string(body.Name)).To(MatchRegexp(pattern))
+				Expect(SUT.InstanceName).To(Equal(string(body.Name)))
+			},
+			Entry("none", nameSuffixStrategyNone, `^bob$`),
+			Entry("random", nameSuffixStrategyRandom, `^bob-[a-z0-9]{5}$`),
+			Entry("timestamp", nameSuffixStrategyTimestamp, `^bob-[a-z0-9]{8}$`),
+		)
+	})
+
+	Describe("Create cloning the boot disk", func() {
+		var api *fakeOxideAPI
+		var snapshotStates []oxide.SnapshotState
+
+		BeforeEach(func() {
+			api = newFakeOxideAPI()
+			DeferCleanup(api.Close)
+			api.stubCreateDependencies()
+			SUT = api.driver("bob", GinkgoT().TempDir())
+			SUT.BootDiskImageID = ""
+			SUT.CloneBootDiskFrom = "golden-disk"
+			snapshotStates = []oxide.SnapshotState{oxide.SnapshotStateCreating, oxide.SnapshotStateReady}
+
+			api.handle("POST /v1/snapshots", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusCreated, oxide.Snapshot{Id: "snapshot-id", State: oxide.SnapshotStateCreating})
+			})
+			api.handle("GET /v1/snapshots/{snapshot}", func(w http.ResponseWriter, r *http.Request) {
+				state := snapshotStates[0]
+				if len(snapshotStates) > 1 {
+					snapshotStates = snapshotStates[1:]
+				}
+				respondJSON(w, http.StatusOK, oxide.Snapshot{Id: "snapshot-id", State: state})
+			})
+			api.handle("DELETE /v1/snapshots/{snapshot}", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+			api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+			})
+
+			interval := snapshotPollInterval
+			snapshotPollInterval = 10 * time.Millisecond
+			DeferCleanup(func() { snapshotPollInterval = interval })
+		})
+
+		It("should create the boot disk from a snapshot of the source disk and delete the snapshot", func() {
+			Expect(SUT.Create()).To(Succeed())
+
+			var snapshotBody oxide.SnapshotCreate
+			Expect(json.Unmarshal(api.requestsFor(http.MethodPost, "/v1/snapshots")[0].Body, &snapshotBody)).To(Succeed())
+			Expect(snapshotBody.Disk).To(Equal(oxide.NameOrId("golden-disk")))
+			Expect(api.requestsFor(http.MethodGet, "/v1/snapshots/snapshot-id")).To(HaveLen(2))
+
+			var body oxide.InstanceCreate
+			Expect(json.Unmarshal(api.requestsFor(http.MethodPost, "/v1/instances")[0].Body, &body)).To(Succeed())
+			bootDisk := body.BootDisk.Value.(*oxide.InstanceDiskAttachmentCreate)
+			backend := bootDisk.DiskBackend.Value.(*oxide.DiskBackendDistributed)
+			Expect(backend.DiskSource.Value).To(Equal(&oxide.DiskSourceSnapshot{SnapshotId: "snapshot-id"}))
+
+			Expect(api.requestsFor(http.MethodDelete, "/v1/snapshots/snapshot-id")).To(HaveLen(1))
+			Expect(SUT.CloneSnapshotID).To(BeEmpty())
+		})
+
+		It("should keep the snapshot when configured", func() {
+			SUT.CloneKeepSnapshot = true
+
+			Expect(SUT.Create()).To(Succeed())
+			Expect(api.requestsFor(http.MethodDelete, "/v1/snapshots/snapshot-id")).To(BeEmpty())
+			Expect(SUT.CloneSnapshotID).To(Equal("snapshot-id"))
+		})
+
+		It("should delete the snapshot when creating the instance fails", func() {
+			SUT.CloneKeepSnapshot = true
+			api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+				respondError(w, http.StatusBadRequest, "InvalidRequest")
+			})
+
+			Expect(SUT.Create()).NotTo(Succeed())
+			Expect(api.requestsFor(http.MethodDelete, "/v1/snapshots/snapshot-id")).To(HaveLen(1))
+			Expect(SUT.CloneSnapshotID).To(BeEmpty())
+		})
+
+		It("should fail and delete the snapshot when it faults", func() {
+			snapshotStates = []oxide.SnapshotState{oxide.SnapshotStateFaulted}
+
+			Expect(SUT.Create()).To(MatchError(ContainSubstring("snapshot snapshot-id is faulted")))
+			Expect(api.requestsFor(http.MethodPost, "/v1/instances")).To(BeEmpty())
+			Expect(api.requestsFor(http.MethodDelete, "/v1/snapshots/snapshot-id")).To(HaveLen(1))
+		})
+	})
+
+	Describe("Create network interface IP wait", func() {
+		var api *fakeOxideAPI
+
+		BeforeEach(func() {
+			api = newFakeOxideAPI()
+			DeferCleanup(api.Close)
+			SUT = api.driver("bob", GinkgoT().TempDir())
+
+			api.handle("GET /v1/images/{image}", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.Image{Id: "image-id"})
+			})
+			api.handle("POST /v1/me/ssh-keys", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusCreated, oxide.SshKey{Id: "ssh-key-id"})
+			})
+			api.handle("POST /v1/instances", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusCreated, oxide.Instance{Id: "instance-id", BootDiskId: "boot-disk-id"})
+			})
+			api.handle("GET /v1/instances/{instance}/disks", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.DiskResultsPage{Items: []oxide.Disk{}})
+			})
+
+			lists := 0
+			api.handle("GET /v1/network-interfaces", func(w http.ResponseWriter, r *http.Request) {
+				lists++
+				ip := ""
+				if lists > 1 {
+					ip = "172.30.0.5"
+				}
+				respondJSON(w, http.StatusOK, oxide.InstanceNetworkInterfaceResultsPage{
+					Items: []oxide.InstanceNetworkInterface{
+						{
+							Id:   "nic-id",
+							Name: "nic",
+							IpStack: oxide.PrivateIpStack{
+								Value: &oxide.PrivateIpStackV4{
+									Value: oxide.PrivateIpv4Stack{Ip: ip},
+								},
+							},
+						},
+					},
+				})
+			})
+
+			interval := nicIPPollInterval
+			nicIPPollInterval = 10 * time.Millisecond
+			DeferCleanup(func() { nicIPPollInterval = interval })
+		})
+
+		It("should wait for the IP address to be assigned", func() {
+			SUT.NICIPWait = time.Second
+
+			Expect(SUT.Create()).To(Succeed())
+			Expect(SUT.IPAddress).To(Equal("172.30.0.5"))
+			Expect(api.requestsFor(http.MethodGet, "/v1/network-interfaces")).To(HaveLen(2))
+		})
+
+		It("should abort when the operation timeout expires while waiting", func() {
+			nicIPPollInterval = time.Minute
+			SUT.NICIPWait = time.Hour
+			SUT.OperationTimeout = time.Second
+
+			Expect(SUT.Create()).To(MatchError(ContainSubstring("create exceeded the operation timeout of 1s")))
+			Expect(api.requestsFor(http.MethodGet, "/v1/network-interfaces")).To(HaveLen(1))
+		})
+
+		It("should not wait for the IP address to be assigned by default", func() {
+			api.handle("GET /v1/instances/{instance}/external-ips", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.ExternalIpResultsPage{
+					Items: []oxide.ExternalIp{{Value: &oxide.ExternalIpEphemeral{Ip: "203.0.113.10"}}},
+				})
+			})
+			SUT.EphemeralIPAttach = true
+
+			Expect(SUT.Create()).To(Succeed())
+			Expect(SUT.InternalIPAddress).To(BeEmpty())
+			Expect(SUT.IPAddress).To(Equal("203.0.113.10"))
+			Expect(api.requestsFor(http.MethodGet, "/v1/network-interfaces")).To(HaveLen(1))
+		})
+
+		It("should fail when the IP address is not assigned after waiting", func() {
+			nicIPPollInterval = 50 * time.Millisecond
+			SUT.NICIPWait = 75 * time.Millisecond
+			api.handle("GET /v1/network-interfaces", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.InstanceNetworkInterfaceResultsPage{
+					Items: []oxide.InstanceNetworkInterface{
+						{
+							Id:   "nic-id",
+							Name: "nic",
+							IpStack: oxide.PrivateIpStack{
+								Value: &oxide.PrivateIpStackV4{Value: oxide.PrivateIpv4Stack{}},
+							},
+						},
+					},
+				})
+			})
+
+			Expect(SUT.Create()).To(MatchError(ContainSubstring("network interface has no IP address after waiting 75ms")))
+			Expect(api.requestsFor(http.MethodGet, "/v1/network-interfaces")).To(HaveLen(2))
+		})
+	})
+
+	Describe("Start", func() {
+		var api *fakeOxideAPI
+		var runStates []oxide.InstanceState
+
+		BeforeEach(func() {
+			api = newFakeOxideAPI()
+			DeferCleanup(api.Close)
+			SUT = api.driver("bob", GinkgoT().TempDir())
+			SUT.InstanceID = "instance-id"
+			SUT.WaitOnStart = true
+
+			api.handle("POST /v1/instances/{instance}/start", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusAccepted, oxide.Instance{Id: "instance-id"})
+			})
+			api.handle("GET /v1/instances/{instance}", func(w http.ResponseWriter, r *http.Request) {
+				runState := runStates[0]
+				if len(runStates) > 1 {
+					runStates = runStates[1:]
+				}
+				respondJSON(w, http.StatusOK, oxide.Instance{Id: "instance-id", RunState: runState})
+			})
+
+			interval, maxInterval, timeout := runningPollInterval, runningPollMaxInterval, runningWaitTimeout
+			runningPollInterval, runningPollMaxInterval, runningWaitTimeout = time.Millisecond, 5*time.Millisecond, 100*time.Millisecond
+			DeferCleanup(func() {
+				runningPollInterval, runningPollMaxInterval, runningWaitTimeout = interval, maxInterval, timeout
+			})
+		})
+
+		It("should wait for the instance to be running", func() {
+			runStates = []oxide.InstanceState{oxide.InstanceStateStopped, oxide.InstanceStateStarting, oxide.InstanceStateRunning}
+			Expect(SUT.Start()).To(Succeed())
+			Expect(api.requestsFor(http.MethodGet, "/v1/instances/instance-id")).To(HaveLen(3))
+		})
+
+		It("should return the last observed state on timeout", func() {
+			runStates = []oxide.InstanceState{oxide.InstanceStateStarting}
+			err := SUT.Start()
+
+			var driverErr *DriverError
+			Expect(errors.As(err, &driverErr)).To(BeTrue())
+			Expect(driverErr.State).To(Equal(state.Starting))
+		})
+
+		It("should not wait when disabled", func() {
+			SUT.WaitOnStart = false
+			Expect(SUT.Start()).To(Succeed())
+			Expect(api.requestsFor(http.MethodGet, "/v1/instances/instance-id")).To(BeEmpty())
+		})
+
+		Describe("retrying", func() {
+			BeforeEach(func() {
+				SUT.WaitOnStart = false
+				SUT.StartMaxRetries = 2
+
+				interval := transientRetryInterval
+				transientRetryInterval = time.Millisecond
+				DeferCleanup(func() { transientRetryInterval = interval })
+			})
+
+			It("should retry a transient failure", func() {
+				starts := 0
+				api.handle("POST /v1/instances/{instance}/start", func(w http.ResponseWriter, r *http.Request) {
+					starts++
+					if starts == 1 {
+						respondError(w, http.StatusServiceUnavailable, "ServiceUnavailable")
+						return
+					}
+					respondJSON(w, http.StatusAccepted, oxide.Instance{Id: "instance-id"})
+				})
+
+				Expect(SUT.Start()).To(Succeed())
+				Expect(starts).To(Equal(2))
+			})
+
+			It("should not retry when the instance cannot be started", func() {
+				api.handle("POST /v1/instances/{instance}/start", func(w http.ResponseWriter, r *http.Request) {
+					respondError(w, http.StatusBadRequest, "InvalidRequest")
+				})
+
+				Expect(SUT.Start()).NotTo(Succeed())
+				Expect(api.requestsFor(http.MethodPost, "/v1/instances/instance-id/start")).To(HaveLen(1))
+			})
+
+			It("should give up after the maximum retries", func() {
+				api.handle("POST /v1/instances/{instance}/start", func(w http.ResponseWriter, r *http.Request) {
+					respondError(w, http.StatusServiceUnavailable, "ServiceUnavailable")
+				})
+
+				Expect(SUT.Start()).NotTo(Succeed())
+				Expect(api.requestsFor(http.MethodPost, "/v1/instances/instance-id/start")).To(HaveLen(3))
+			})
+		})
+	})
+
+	Describe("Remove", func() {
+		var api *fakeOxideAPI
+
+		BeforeEach(func() {
+			api = newFakeOxideAPI()
+			DeferCleanup(api.Close)
+			api.stubRemoveDependencies()
+			SUT = api.driver("bob", GinkgoT().TempDir())
+			SUT.InstanceID = "instance-id"
+			SUT.BootDiskID = "boot-disk-id"
+			SUT.AdditionalDiskIDs = []string{"data-disk-id"}
+			SUT.SSHPublicKeyID = "ssh-key-id"
+		})
+
+		Describe("disks attached out of band", func() {
+			BeforeEach(func() {
+				SUT.AdditionalDiskIDs = []string{"data-disk-id", "user-disk-id"}
+				SUT.CreatedAdditionalDiskIDs = []string{"data-disk-id"}
+			})
+
+			It("should only delete the disks the driver created", func() {
+				Expect(SUT.Remove()).To(Succeed())
+				Expect(api.requestsFor(http.MethodDelete, "/v1/disks/boot-disk-id")).To(HaveLen(1))
+				Expect(api.requestsFor(http.MethodDelete, "/v1/disks/data-disk-id")).To(HaveLen(1))
+				Expect(api.requestsFor(http.MethodDelete, "/v1/disks/user-disk-id")).To(BeEmpty())
+			})
+
+			It("should delete every disk when requested", func() {
+				SUT.DeleteAllDisks = true
+
+				Expect(SUT.Remove()).To(Succeed())
+				Expect(api.requestsFor(http.MethodDelete, "/v1/disks/data-disk-id")).To(HaveLen(1))
+				Expect(api.requestsFor(http.MethodDelete, "/v1/disks/user-disk-id")).To(HaveLen(1))
+			})
+
+			It("should delete every disk of machines saved before created disks were tracked", func() {
+				SUT.CreatedAdditionalDiskIDs = nil
+
+				Expect(SUT.Remove()).To(Succeed())
+				Expect(api.requestsFor(http.MethodDelete, "/v1/disks/data-disk-id")).To(HaveLen(1))
+				Expect(api.requestsFor(http.MethodDelete, "/v1/disks/user-disk-id")).To(HaveLen(1))
+			})
+		})
+
+		It("should detach floating IPs without deleting them", func() {
+			SUT.FloatingIPIDs = []string{"floating-ip-id"}
+			api.handle("POST /v1/floating-ips/{floating_ip}/detach", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusAccepted, oxide.FloatingIp{Id: r.PathValue("floating_ip")})
+			})
+
+			Expect(SUT.Remove()).To(Succeed())
+			Expect(api.requestsFor(http.MethodPost, "/v1/floating-ips/floating-ip-id/detach")).To(HaveLen(1))
+			Expect(api.requestsFor(http.MethodDelete, "/v1/floating-ips/floating-ip-id")).To(BeEmpty())
+			Expect(api.requestsFor(http.MethodDelete, "/v1/instances/instance-id")).To(HaveLen(1))
+		})
+
+		It("should leave ephemeral IPs to be released with the instance", func() {
+			SUT.ExternalIPs = []ExternalIP{{Type: externalIPTypeEphemeral, NameOrID: "public"}}
+			SUT.ExternalIPAddress = "203.0.113.10"
+
+			Expect(SUT.Remove()).To(Succeed())
+			Expect(api.requestsFor(http.MethodDelete, "/v1/instances/instance-id")).To(HaveLen(1))
+			for _, r := range api.requests {
+				Expect(r.Path).NotTo(ContainSubstring("ip"), "%s %s", r.Method, r.Path)
+			}
+		})
+
+		It("should not delete the instance when detaching a floating IP fails", func() {
+			SUT.FloatingIPIDs = []string{"floating-ip-id"}
+			api.handle("POST /v1/floating-ips/{floating_ip}/detach", func(w http.ResponseWriter, r *http.Request) {
+				respondError(w, http.StatusInternalServerError, "InternalError")
+			})
+
+			Expect(SUT.Remove()).To(MatchError(ContainSubstring("failed detaching floating ip floating-ip-id")))
+			Expect(api.requestsFor(http.MethodDelete, "/v1/instances/instance-id")).To(BeEmpty())
+		})
+
+		It("should delete the instance and disks when ssh key deletion fails", func() {
+			api.handle("DELETE /v1/me/ssh-keys/{key}", func(w http.ResponseWriter, r *http.Request) {
+				respondError(w, http.StatusInternalServerError, "InternalError")
+			})
+
+			err := SUT.Remove()
+			Expect(err).To(MatchError(ContainSubstring("failed deleting ssh key ssh-key-id")))
+			Expect(api.requestsFor(http.MethodDelete, "/v1/instances/instance-id")).To(HaveLen(1))
+			Expect(api.requestsFor(http.MethodDelete, "/v1/disks/boot-disk-id")).To(HaveLen(1))
+			Expect(api.requestsFor(http.MethodDelete, "/v1/disks/data-disk-id")).To(HaveLen(1))
+		})
+
+		It("should delete every resource once the instance is deleted", func() {
+			SUT.AdditionalDiskIDs = []string{"data-disk-1", "data-disk-2", "data-disk-3"}
+			SUT.AntiAffinityGroupCleanup = true
+			SUT.CreatedAntiAffinityGroupID = "group-id"
+			SUT.CloneSnapshotID = "snapshot-id"
+			api.handle("GET /v1/anti-affinity-groups/{group}/members", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.AntiAffinityGroupMemberResultsPage{Items: []oxide.AntiAffinityGroupMember{}})
+			})
+			api.handle("DELETE /v1/anti-affinity-groups/{group}", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+			api.handle("DELETE /v1/snapshots/{snapshot}", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			Expect(SUT.Remove()).To(Succeed())
+
+			instanceDeleted := false
+			for _, r := range api.requests {
+				switch {
+				case r.Method == http.MethodDelete && r.Path == "/v1/instances/instance-id":
+					instanceDeleted = true
+				case r.Method == http.MethodDelete && r.Path != "/v1/me/ssh-keys/ssh-key-id":
+					Expect(instanceDeleted).To(BeTrue(), "%s deleted before the instance", r.Path)
+				}
+			}
+			for _, diskID := range append([]string{"boot-disk-id"}, SUT.AdditionalDiskIDs...) {
+				Expect(api.requestsFor(http.MethodDelete, "/v1/disks/"+diskID)).To(HaveLen(1))
+			}
+			Expect(api.requestsFor(http.MethodDelete, "/v1/anti-affinity-groups/group-id")).To(HaveLen(1))
+			Expect(api.requestsFor(http.MethodDelete, "/v1/snapshots/snapshot-id")).To(HaveLen(1))
+			Expect(SUT.CloneSnapshotID).To(BeEmpty())
+		})
+
+		It("should delete the other resources when deleting the boot disk fails", func() {
+			api.handle("DELETE /v1/disks/{disk}", func(w http.ResponseWriter, r *http.Request) {
+				if r.PathValue("disk") == "boot-disk-id" {
+					respondError(w, http.StatusInternalServerError, "InternalError")
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			Expect(SUT.Remove()).To(MatchError(ContainSubstring("failed deleting disk boot-disk-id")))
+			Expect(api.requestsFor(http.MethodDelete, "/v1/disks/data-disk-id")).To(HaveLen(1))
+		})
+
+		Describe("verifying removal", func() {
+			var remainingDisk string
+
+			BeforeEach(func() {
+				SUT.VerifyRemove = true
+				remainingDisk = ""
+
+				instanceDeleted := false
+				api.handle("DELETE /v1/instances/{instance}", func(w http.ResponseWriter, r *http.Request) {
+					instanceDeleted = true
+					w.WriteHeader(http.StatusNoContent)
+				})
+				api.handle("GET /v1/instances/{instance}", func(w http.ResponseWriter, r *http.Request) {
+					if instanceDeleted {
+						respondError(w, http.StatusNotFound, "ObjectNotFound")
+						return
+					}
+					respondJSON(w, http.StatusOK, oxide.Instance{Id: r.PathValue("instance"), RunState: oxide.InstanceStateStopped})
+				})
+				api.handle("GET /v1/disks/{disk}", func(w http.ResponseWriter, r *http.Request) {
+					if r.PathValue("disk") == remainingDisk {
+						respondJSON(w, http.StatusOK, oxide.Disk{Id: remainingDisk, State: oxide.DiskState{Value: &oxide.DiskStateDetached{}}})
+						return
+					}
+					respondError(w, http.StatusNotFound, "ObjectNotFound")
+				})
+				api.handle("GET /v1/me/ssh-keys/{key}", func(w http.ResponseWriter, r *http.Request) {
+					respondError(w, http.StatusNotFound, "ObjectNotFound")
+				})
+			})
+
+			It("should succeed when every resource is gone", func() {
+				Expect(SUT.Remove()).To(Succeed())
+				Expect(api.requestsFor(http.MethodGet, "/v1/disks/boot-disk-id")).To(HaveLen(1))
+				Expect(api.requestsFor(http.MethodGet, "/v1/disks/data-disk-id")).To(HaveLen(1))
+				Expect(api.requestsFor(http.MethodGet, "/v1/me/ssh-keys/ssh-key-id")).To(HaveLen(1))
+			})
+
+			It("should fail listing a disk that still exists", func() {
+				remainingDisk = "data-disk-id"
+				Expect(SUT.Remove()).To(MatchError("resources still exist after removal: disk data-disk-id"))
+			})
+		})
+
+		Describe("waiting for disk deletion", func() {
+			BeforeEach(func() {
+				views := map[string]int{}
+				api.handle("GET /v1/disks/{disk}", func(w http.ResponseWriter, r *http.Request) {
+					disk := r.PathValue("disk")
+					views[disk]++
+					if views[disk] == 1 {
+						respondJSON(w, http.StatusOK, oxide.Disk{Id: disk, State: oxide.DiskState{Value: &oxide.DiskStateDestroyed{}}})
+						return
+					}
+					respondError(w, http.StatusNotFound, "ObjectNotFound")
+				})
+
+				interval := diskDeletionPollInterval
+				diskDeletionPollInterval = time.Millisecond
+				DeferCleanup(func() { diskDeletionPollInterval = interval })
+			})
+
+			It("should wait until each disk is gone", func() {
+				SUT.WaitForDiskDeletion = true
+				Expect(SUT.Remove()).To(Succeed())
+				Expect(api.requestsFor(http.MethodGet, "/v1/disks/boot-disk-id")).To(HaveLen(2))
+				Expect(api.requestsFor(http.MethodGet, "/v1/disks/data-disk-id")).To(HaveLen(2))
+			})
+
+			It("should not wait when disabled", func() {
+				Expect(SUT.Remove()).To(Succeed())
+				Expect(api.requestsFor(http.MethodDelete, "/v1/disks/data-disk-id")).To(HaveLen(1))
+				Expect(api.requestsFor(http.MethodGet, "/v1/disks/data-disk-id")).To(BeEmpty())
+			})
+		})
+
+		It("should check the instance state at most once per poll interval while it stops", func() {
+			var views []time.Time
+			api.handle("GET /v1/instances/{instance}", func(w http.ResponseWriter, r *http.Request) {
+				views = append(views, time.Now())
+				runState := oxide.InstanceStateStopping
+				if len(views) > 4 {
+					runState = oxide.InstanceStateStopped
+				}
+				respondJSON(w, http.StatusOK, oxide.Instance{Id: r.PathValue("instance"), RunState: runState})
+			})
+			SUT.StopPollInterval = 20 * time.Millisecond
+			SUT.StopPollMaxInterval = 20 * time.Millisecond
+
+			Expect(SUT.Remove()).To(Succeed())
+			Expect(views).To(HaveLen(5))
+			for i := 1; i < len(views); i++ {
+				Expect(views[i].Sub(views[i-1])).To(BeNumerically(">=", SUT.StopPollInterval))
+			}
+		})
+
+		Describe("instance stuck stopping", func() {
+			BeforeEach(func() {
+				stops := 0
+				api.handle("POST /v1/instances/{instance}/stop", func(w http.ResponseWriter, r *http.Request) {
+					stops++
+					respondJSON(w, http.StatusAccepted, oxide.Instance{Id: r.PathValue("instance")})
+				})
+				api.handle("GET /v1/instances/{instance}", func(w http.ResponseWriter, r *http.Request) {
+					runState := oxide.InstanceStateStopping
+					if stops > 1 {
+						runState = oxide.InstanceStateStopped
+					}
+					respondJSON(w, http.StatusOK, oxide.Instance{Id: r.PathValue("instance"), RunState: runState})
+				})
+				SUT.StopPollInterval = time.Millisecond
+				SUT.StopPollMaxInterval = time.Millisecond
+			})
+
+			It("should re-issue the stop once the threshold elapses", func() {
+				SUT.StopReissueAfter = 20 * time.Millisecond
+
+				Expect(SUT.Remove()).To(Succeed())
+				Expect(api.requestsFor(http.MethodPost, "/v1/instances/instance-id/stop")).To(HaveLen(2))
+				Expect(api.requestsFor(http.MethodDelete, "/v1/instances/instance-id")).To(HaveLen(1))
+			})
+
+			It("should give up once the remove timeout elapses", func() {
+				SUT.StopReissueAfter = 0
+				SUT.RemoveTimeout = 50 * time.Millisecond
+
+				start := time.Now()
+				Expect(SUT.Remove()).To(MatchError(ContainSubstring("timed out waiting for instance to stop")))
+				Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+				Expect(api.requestsFor(http.MethodDelete, "/v1/instances/instance-id")).To(BeEmpty())
+			})
+		})
+
+		Describe("deleting additional disks", func() {
+			BeforeEach(func() {
+				SUT.AdditionalDiskIDs = []string{"disk-1", "disk-2", "disk-3", "disk-4", "disk-5"}
+			})
+
+			It("should attempt every disk when one fails", func() {
+				api.handle("DELETE /v1/disks/{disk}", func(w http.ResponseWriter, r *http.Request) {
+					if r.PathValue("disk") == "disk-2" {
+						respondError(w, http.StatusInternalServerError, "InternalError")
+						return
+					}
+					w.WriteHeader(http.StatusNoContent)
+				})
+				SUT.DiskDeleteConcurrency = 2
+
+				err := SUT.Remove()
+				Expect(err).To(MatchError(ContainSubstring("failed deleting disk disk-2")))
+				for _, diskID := range SUT.AdditionalDiskIDs {
+					Expect(api.requestsFor(http.MethodDelete, "/v1/disks/"+diskID)).To(HaveLen(1))
+				}
+			})
+
+			It("should delete every disk when unset", func() {
+				SUT.DiskDeleteConcurrency = 0
+
+				Expect(SUT.Remove()).To(Succeed())
+				for _, diskID := range SUT.AdditionalDiskIDs {
+					Expect(api.requestsFor(http.MethodDelete, "/v1/disks/"+diskID)).To(HaveLen(1))
+				}
+			})
+		})
+	})
+
+	Describe("RemoveInstanceOnly", func() {
+		var api *fakeOxideAPI
+
+		BeforeEach(func() {
+			api = newFakeOxideAPI()
+			DeferCleanup(api.Close)
+			SUT = api.driver("bob", GinkgoT().TempDir())
+			SUT.InstanceID = "instance-id"
+			SUT.BootDiskID = "boot-disk-id"
+			SUT.AdditionalDiskIDs = []string{"data-disk-id"}
+			SUT.SSHPublicKeyID = "ssh-key-id"
+
+			api.handle("POST /v1/instances/{instance}/stop", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusAccepted, oxide.Instance{Id: "instance-id"})
+			})
+			api.handle("GET /v1/instances/{instance}", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.Instance{Id: "instance-id", RunState: oxide.InstanceStateStopped})
+			})
+			api.handle("POST /v1/instances/{instance}/disks/detach", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusAccepted, oxide.Disk{Id: "data-disk-id"})
+			})
+			api.handle("DELETE /v1/instances/{instance}", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+		})
+
+		It("should delete the instance and keep the disks", func() {
+			Expect(SUT.RemoveInstanceOnly()).To(Succeed())
+
+			Expect(api.requestsFor(http.MethodPost, "/v1/instances/instance-id/disks/detach")).To(HaveLen(1))
+			Expect(api.requestsFor(http.MethodDelete, "/v1/instances/instance-id")).To(HaveLen(1))
+			Expect(api.requestsFor(http.MethodDelete, "/v1/disks/boot-disk-id")).To(BeEmpty())
+			Expect(api.requestsFor(http.MethodDelete, "/v1/disks/data-disk-id")).To(BeEmpty())
+
+			Expect(SUT.InstanceID).To(BeEmpty())
+			Expect(SUT.BootDiskID).To(Equal("boot-disk-id"))
+			Expect(SUT.AdditionalDiskIDs).To(Equal([]string{"data-disk-id"}))
+			Expect(SUT.SSHPublicKeyID).To(Equal("ssh-key-id"))
+		})
+
+		It("should try to detach every disk and keep the instance when one fails", func() {
+			SUT.AdditionalDiskIDs = []string{"data-disk-id", "logs-disk-id", "cache-disk-id"}
+			api.handle("POST /v1/instances/{instance}/disks/detach", func(w http.ResponseWriter, r *http.Request) {
+				var body oxide.DiskPath
+				Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+				if body.Disk == "logs-disk-id" {
+					respondError(w, http.StatusInternalServerError, "InternalError")
+					return
+				}
+				respondJSON(w, http.StatusAccepted, oxide.Disk{Id: string(body.Disk)})
+			})
+
+			err := SUT.RemoveInstanceOnly()
+			Expect(err).To(MatchError(ContainSubstring("failed detaching disk logs-disk-id")))
+			Expect(err).NotTo(MatchError(ContainSubstring("data-disk-id")))
+
+			Expect(api.requestsFor(http.MethodPost, "/v1/instances/instance-id/disks/detach")).To(HaveLen(3))
+			Expect(api.requestsFor(http.MethodDelete, "/v1/instances/instance-id")).To(BeEmpty())
+			Expect(SUT.InstanceID).To(Equal("instance-id"))
+		})
+
+		It("should stop at the operation timeout", func() {
+			SUT.OperationTimeout = 50 * time.Millisecond
+			api.handle("GET /v1/instances/{instance}", func(w http.ResponseWriter, r *http.Request) {
+				respondJSON(w, http.StatusOK, oxide.Instance{Id: "instance-id", RunState: oxide.InstanceStateStopping})
+			})
+
+			Expect(SUT.RemoveInstanceOnly()).To(MatchError(ContainSubstring("remove instance exceeded the operation timeout of 50ms")))
+			Expect(api.requestsFor(http.MethodDelete, "/v1/instances/instance-id")).To(BeEmpty())
+		})
+	})
+
+	Describe("create semaphore", func() {
+		BeforeEach(func() {
+			semaphore := createSemaphore
+			DeferCleanup(func() { createSemaphore = semaphore })
+		})
+
+		It("should bound the number of concurrent creates", func() {
+			createSemaphore = newCreateSemaphore("2")
+
+			var inFlight, maxInFlight atomic.Int32
+			var wg sync.WaitGroup
+			for range 6 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_ = withCreateSlot(func() error {
+						n := inFlight.Add(1)
+						for {
+							m := maxInFlight.Load()
+							if n <= m || maxInFlight.CompareAndSwap(m, n) {
+								break
+							}
+						}
+						time.Sleep(10 * time.Millisecond)
+						inFlight.Add(-1)
+						return nil
+					})
+				}()
+			}
+			wg.Wait()
+
+			Expect(maxInFlight.Load()).To(BeNumerically("<=", 2))
+		})
+
+		DescribeTable("should be unbounded when the size is invalid",
+			func(size string) {
+				Expect(newCreateSemaphore(size)).To(BeNil())
+			},
+			Entry("empty", ""),
+			Entry("zero", "0"),
+			Entry("negative", "-1"),
+			Entry("garbage", "many"),
+		)
+	})
+
+	DescribeTable("RancherMachineState mapping is correct",
+		func(instanceState oxide.InstanceState, expectedState state.State) {
+			Expect(toRancherMachineState(instanceState)).To(Equal(expectedState))
+		},
+		Entry("creating", oxide.InstanceStateCreating, state.Starting),
+		Entry("starting", oxide.InstanceStateStarting, state.Starting),
+		Entry("running", oxide.InstanceStateRunning, state.Running),
+		Entry("stopping", oxide.InstanceStateStopping, state.Stopping),
+		Entry("stopped", oxide.InstanceStateStopped, state.Stopped),
+		Entry("repairing", oxide.InstanceStateRepairing, state.Starting),
+		Entry("rebooting", oxide.InstanceStateRebooting, state.Starting),
+		Entry("migrating", oxide.InstanceStateMigrating, state.Running),
+		Entry("failed", oxide.InstanceStateFailed, state.Error),
+		Entry("destroyed", oxide.InstanceStateDestroyed, state.NotFound),
+		Entry("unknown", oxide.InstanceState("unknown"), state.None),
+	)
+
+	DescribeTable("primaryNetworkInterface",
+		func(primary []*bool, expected string) {
+			networkInterfaces := make([]oxide.InstanceNetworkInterface, len(primary))
+			for i, p := range primary {
+				networkInterfaces[i] = oxide.InstanceNetworkInterface{Name: oxide.Name(fmt.Sprintf("nic-%d", i)), Primary: p}
+			}
+			Expect(primaryNetworkInterface(networkInterfaces).Name).To(Equal(oxide.Name(expected)))
+		},
+		Entry("selects the primary interface", []*bool{oxide.NewPointer(false), oxide.NewPointer(true)}, "nic-1"),
+		Entry("selects the only interface", []*bool{oxide.NewPointer(true)}, "nic-0"),
+		Entry("falls back to the first interface when none is primary", []*bool{nil, oxide.NewPointer(false)}, "nic-0"),
+	)
+
+	Describe("instanceDescription", func() {
+		It("should append the labels as a JSON object", func() {
+			SUT.InstanceDescription = "worker node"
+			SUT.Labels = map[string]string{"team": "infra", "cost-center": "42"}
+
+			description := SUT.instanceDescription()
+			Expect(description).To(Equal(`worker node labels={"cost-center":"42","team":"infra"}`))
+
+			var labels map[string]string
+			Expect(json.Unmarshal([]byte(strings.TrimPrefix(description, "worker node "+labelsDescriptionPrefix)), &labels)).To(Succeed())
+			Expect(labels).To(Equal(SUT.Labels))
+		})
+	})
+
+	Describe("suffixName", func() {
+		It("should append the suffix", func() {
+			Expect(suffixName("bob", "abcde")).To(Equal("bob-abcde"))
+		})
+
+		It("should shorten long names to fit", func() {
+			name := suffixName(strings.Repeat("a", 56)+"-"+strings.Repeat("b", 20), "abcde")
+			Expect(name).To(Equal(strings.Repeat("a", 56) + "-abcde"))
+			Expect(len(name)).To(BeNumerically("<=", maxNameLength))
+			Expect(validateName(name)).To(Succeed())
+		})
+
+		It("should fit long machine names with a generated suffix", func() {
+			SUT = newDriver(strings.Repeat("a", maxNameLength), "path")
+			for _, strategy := range []string{nameSuffixStrategyRandom, nameSuffixStrategyTimestamp} {
+				SUT.NameSuffixStrategy = strategy
+				name, err := SUT.suffixedInstanceName()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(validateName(name)).To(Succeed())
+			}
+		})
+	})
+
+	Describe("derivedName", func() {
+		It("should prefix the name", func() {
+			Expect(derivedName("disk-", "bob")).To(Equal("disk-bob"))
+		})
+
+		It("should shorten long names to fit and keep the prefix", func() {
+			name := derivedName("disk-", strings.Repeat("a", maxNameLength))
+			Expect(name).To(MatchRegexp(`^disk-a{49}-[0-9a-f]{8}$`))
+			Expect(validateName(name)).To(Succeed())
+			Expect(derivedName("disk-", strings.Repeat("a", maxNameLength-1)+"b")).NotTo(Equal(name))
+		})
+
+		It("should be used for the additional disk names", func() {
+			name := AdditionalDisk{Label: "data"}.Name(strings.Repeat("a", maxNameLength), 0)
+			Expect(name).To(HavePrefix("disk-00-data-"))
+			Expect(validateName(name)).To(Succeed())
+		})
+	})
+
+	Describe("seededInstanceName", func() {
+		It("should derive the same name from the same seed", func() {
+			name := seededInstanceName("bob", "seed")
+			Expect(name).To(MatchRegexp(`^bob-[0-9a-f]{8}$`))
+			Expect(seededInstanceName("bob", "seed")).To(Equal(name))
+			Expect(seededInstanceName("bob", "other-seed")).NotTo(Equal(name))
+			Expect(validateName(name)).To(Succeed())
+		})
+
+		It("should shorten long machine names to fit", func() {
+			name := seededInstanceName(strings.Repeat("a", 53)+"-"+strings.Repeat("b", 20), "seed")
+			Expect(len(name)).To(BeNumerically("<=", maxNameLength))
+			Expect(name).To(MatchRegexp(`^a{53}-[0-9a-f]{8}$`))
+			Expect(validateName(name)).To(Succeed())
+		})
+
+		It("should be used as the instance name", func() {
+			SUT.InstanceNameSeed = "seed"
+			Expect(SUT.baseInstanceName()).To(Equal(seededInstanceName("bob", "seed")))
+		})
+	})
+
+	DescribeTable("sshUserForImage",
+		func(image oxide.Image, user string) {
+			Expect(sshUserForImage(image)).To(Equal(user))
+		},
+		Entry("Ubuntu", oxide.Image{Os: "Ubuntu", Version: "24.04"}, "ubuntu"),
+		Entry("Debian", oxide.Image{Os: "debian", Version: "12"}, "debian"),
+		Entry("Fedora CoreOS", oxide.Image{Os: "Fedora CoreOS"}, "core"),
+		Entry("Fedora", oxide.Image{Os: "fedora"}, "fedora"),
+		Entry("Flatcar", oxide.Image{Os: "flatcar"}, "core"),
+		Entry("family from the name", oxide.Image{Name: "rocky-9-cloud"}, "rocky"),
+		Entry("operating system over the name", oxide.Image{Os: "debian", Name: "ubuntu-compatible"}, "debian"),
+		Entry("unknown", oxide.Image{Os: "helios", Name: "helios-2.0"}, ""),
+	)
+
+	DescribeTable("validateName errors",
+		func(name, message string) {
+			Expect(validateName(name)).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("empty", "", "must be between 1 and 63 characters"),
+		Entry("too long", strings.Repeat("a", maxNameLength+1), "must be between 1 and 63 characters"),
+		Entry("leading digit", "1bob", "must start with a lowercase letter"),
+		Entry("trailing hyphen", "bob-", "must not end with a hyphen"),
+		Entry("uppercase", "boB", "must only contain lowercase letters"),
+	)
+
+	DescribeTable("parseLabels errors",
+		func(labels, message string) {
+			_, err := parseLabels(labels)
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("invalid JSON", `{"team":`, "invalid labels JSON object"),
+		Entry("not an object", `["infra"]`, "invalid labels JSON object"),
+		Entry("non-string values", `{"replicas":3}`, "invalid labels JSON object"),
+	)
+
+	Describe("ParseAdditionalDisk", func() {
+		DescribeTable("Success",
+			func(s string, expected AdditionalDisk) {
+				Expect(ParseAdditionalDisk(s)).To(Equal(expected))
+			},
+			Entry("parses integer without suffix", "21474836480", AdditionalDisk{Size: 21474836480, Label: "additional"}),
+			Entry("parses integer with suffix", "10GiB", AdditionalDisk{Size: 10737418240, Label: "additional"}),
+			Entry("parses integer with space suffix", "10 GiB", AdditionalDisk{Size: 10737418240, Label: "additional"}),
+			Entry("parses integer without suffix and label", "21474836480,data", AdditionalDisk{Size: 21474836480, Label: "data"}),
+			Entry("parses integer with suffix and label", "10GiB,data", AdditionalDisk{Size: 10737418240, Label: "data"}),
+			Entry("parses integer with space suffix and label", "10 GiB,data", AdditionalDisk{Size: 10737418240, Label: "data"}),
+			Entry("parses integer without suffix trailing comma", "21474836480,", AdditionalDisk{Size: 21474836480, Label: "additional"}),
+			Entry("parses integer with suffix trailing comma", "10GiB,", AdditionalDisk{Size: 10737418240, Label: "additional"}),
+			Entry("parses label and description", "10GiB,data,Data for etcd", AdditionalDisk{Size: 10737418240, Label: "data", Description: "Data for etcd"}),
+			Entry("parses description without label", "10GiB,,Data for etcd", AdditionalDisk{Size: 10737418240, Label: "additional", Description: "Data for etcd"}),
+			Entry("parses description containing commas", "10GiB,data,etcd, fast", AdditionalDisk{Size: 10737418240, Label: "data", Description: "etcd, fast"}),
+			Entry("parses empty description", "10GiB,data,", AdditionalDisk{Size: 10737418240, Label: "data"}),
+			Entry("parses image and size", "image,image-id,10GiB", AdditionalDisk{Size: 10737418240, Label: "additional", ImageID: "image-id"}),
+			Entry("parses image, size, label, and description", "image,image-id,10GiB,data,Data for etcd", AdditionalDisk{Size: 10737418240, Label: "data", Description: "Data for etcd", ImageID: "image-id"}),
+			Entry("parses local backend and size", "backend,local,10GiB,etcd", AdditionalDisk{Size: 10737418240, Label: "etcd", Backend: "local"}),
+			Entry("parses image and distributed backend", "image,image-id,backend,distributed,10GiB", AdditionalDisk{Size: 10737418240, Label: "additional", ImageID: "image-id", Backend: "distributed"}),
+		)
+
+		DescribeTable("Error",
+			func(s string) {
+				_, err := ParseAdditionalDisk(s)
+				Expect(err).To(HaveOccurred())
+			},
+			Entry("errors with empty string", ""),
+			Entry("errors with empty invalid format", ","),
+			Entry("errors with no size", ",foo"),
+			Entry("errors with invalid size unit suffix", "20 ABC,"),
+			Entry("errors with no size and a description", ",data,description"),
+			Entry("errors with image and no image ID", "image,,10GiB"),
+			Entry("errors with image and no size", "image,image-id"),
+			Entry("errors with image and invalid size", "image,image-id,data"),
+			Entry("errors with unknown backend", "backend,fast,10GiB"),
+			Entry("errors with backend and no size", "backend,local"),
+			Entry("errors with image and local backend", "image,image-id,backend,local,10GiB"),
+		)
+	})
+
+	Describe("ParseExternalIP", func() {
+		DescribeTable("Success",
+			func(s string, expected ExternalIP) {
+				Expect(ParseExternalIP(s)).To(Equal(expected))
+			},
+			Entry("parses a floating IP", "floating,web", ExternalIP{Type: "floating", NameOrID: "web"}),
+			Entry("parses an ephemeral IP with a pool", "ephemeral,public", ExternalIP{Type: "ephemeral", NameOrID: "public"}),
+			Entry("parses an ephemeral IP from the default pool", "ephemeral", ExternalIP{Type: "ephemeral"}),
+			Entry("parses an ephemeral IP with a trailing comma", "ephemeral,", ExternalIP{Type: "ephemeral"}),
+		)
+
+		DescribeTable("Error",
+			func(s string) {
+				_, err := ParseExternalIP(s)
+				Expect(err).To(HaveOccurred())
+			},
+			Entry("errors with empty string", ""),
+			Entry("errors with no name or ID", "floating,"),
+			Entry("errors with no type", "web"),
+			Entry("errors with unknown type", "snat,web"),
 		)
 	})
 })
@@ -0,0 +1,206 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Copyright 2024 Oxide Computer Company
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// isoSectorSize is the size of an ISO 9660 logical sector and block.
+const isoSectorSize = 2048
+
+// Sectors of the seed ISO's fixed structures. The first 16 sectors are the
+// unused system area.
+const (
+	isoPrimaryVolumeDescriptorSector = 16
+	isoTerminatorSector              = 17
+	isoLPathTableSector              = 18
+	isoMPathTableSector              = 19
+	isoRootDirectorySector           = 20
+	isoFirstFileSector               = 21
+)
+
+// seedISOVolumeID is the volume identifier that cloud-init's NoCloud data
+// source looks for.
+const seedISOVolumeID = "CIDATA"
+
+// seedISOFile is a file in the root directory of a seed ISO.
+type seedISOFile struct {
+	Name    string
+	Content []byte
+}
+
+// buildSeedISO builds an ISO 9660 image labeled `cidata` whose root directory
+// holds files, as read by cloud-init's NoCloud data source. File names are
+// recorded in upper case as ISO 9660 requires, and as given in Rock Ridge
+// alternate names so that they're presented as given (e.g., `user-data`).
+func buildSeedISO(files []seedISOFile) ([]byte, error) {
+	files = slices.Clone(files)
+	slices.SortFunc(files, func(a, b seedISOFile) int {
+		return strings.Compare(isoFileIdentifier(a.Name), isoFileIdentifier(b.Name))
+	})
+
+	// The root directory records must fit within its single sector. The
+	// first record announces the Rock Ridge extensions, which every record
+	// then carries.
+	rootDirectory := isoDirectoryRecord([]byte{0}, isoRootDirectorySector, isoSectorSize, true,
+		append(isoSUSPIndicator(), isoRockRidgeName("", isoRockRidgeNameCurrent)...))
+	rootDirectory = append(rootDirectory, isoDirectoryRecord([]byte{1}, isoRootDirectorySector, isoSectorSize, true,
+		isoRockRidgeName("", isoRockRidgeNameParent))...)
+
+	sector := uint32(isoFirstFileSector)
+	for _, file := range files {
+		rootDirectory = append(rootDirectory, isoDirectoryRecord([]byte(isoFileIdentifier(file.Name)), sector, uint32(len(file.Content)), false,
+			isoRockRidgeName(file.Name, 0))...)
+		sector += isoSectors(len(file.Content))
+	}
+	if len(rootDirectory) > isoSectorSize {
+		return nil, fmt.Errorf("too many files for seed ISO: %d", len(files))
+	}
+
+	image := make([]byte, int(sector)*isoSectorSize)
+	copy(image[isoPrimaryVolumeDescriptorSector*isoSectorSize:], isoPrimaryVolumeDescriptor(sector))
+	copy(image[isoTerminatorSector*isoSectorSize:], isoVolumeDescriptorSetTerminator())
+	copy(image[isoLPathTableSector*isoSectorSize:], isoPathTable(binary.LittleEndian))
+	copy(image[isoMPathTableSector*isoSectorSize:], isoPathTable(binary.BigEndian))
+	copy(image[isoRootDirectorySector*isoSectorSize:], rootDirectory)
+
+	offset := isoFirstFileSector * isoSectorSize
+	for _, file := range files {
+		copy(image[offset:], file.Content)
+		offset += int(isoSectors(len(file.Content))) * isoSectorSize
+	}
+
+	return image, nil
+}
+
+// isoFileIdentifier returns the ISO 9660 file identifier for name: the name in
+// upper case with the version number `1`.
+func isoFileIdentifier(name string) string {
+	return strings.ToUpper(name) + ";1"
+}
+
+// isoSectors returns the number of sectors needed to hold size bytes.
+func isoSectors(size int) uint32 {
+	return uint32((size + isoSectorSize - 1) / isoSectorSize)
+}
+
+// isoPrimaryVolumeDescriptor returns the primary volume descriptor of an image
+// spanning volumeSectors sectors.
+func isoPrimaryVolumeDescriptor(volumeSectors uint32) []byte {
+	descriptor := make([]byte, isoSectorSize)
+	descriptor[0] = 1
+	copy(descriptor[1:6], "CD001")
+	descriptor[6] = 1
+	isoPadded(descriptor[8:40], "")
+	isoPadded(descriptor[40:72], seedISOVolumeID)
+	isoBothEndian32(descriptor[80:88], volumeSectors)
+	isoBothEndian16(descriptor[120:124], 1)
+	isoBothEndian16(descriptor[124:128], 1)
+	isoBothEndian16(descriptor[128:132], isoSectorSize)
+	isoBothEndian32(descriptor[132:140], uint32(len(isoPathTable(binary.LittleEndian))))
+	binary.LittleEndian.PutUint32(descriptor[140:144], isoLPathTableSector)
+	binary.BigEndian.PutUint32(descriptor[148:152], isoMPathTableSector)
+	copy(descriptor[156:190], isoDirectoryRecord([]byte{0}, isoRootDirectorySector, isoSectorSize, true, nil))
+	isoPadded(descriptor[190:813], "")
+
+	// The creation, modification, expiration, and effective dates are left
+	// unspecified.
+	for date := 813; date < 881; date += 17 {
+		isoPadded(descriptor[date:date+16], strings.Repeat("0", 16))
+	}
+	descriptor[881] = 1
+
+	return descriptor
+}
+
+// isoVolumeDescriptorSetTerminator returns the descriptor that ends the volume
+// descriptors.
+func isoVolumeDescriptorSetTerminator() []byte {
+	descriptor := make([]byte, isoSectorSize)
+	descriptor[0] = 255
+	copy(descriptor[1:6], "CD001")
+	descriptor[6] = 1
+	return descriptor
+}
+
+// isoPathTable returns a path table holding only the root directory, encoded
+// with order.
+func isoPathTable(order binary.ByteOrder) []byte {
+	table := make([]byte, 10)
+	table[0] = 1
+	order.PutUint32(table[2:6], isoRootDirectorySector)
+	order.PutUint16(table[6:8], 1)
+	return table
+}
+
+// isoDirectoryRecord returns the directory record for the file or directory
+// with identifier whose data is size bytes starting at sector. systemUse is
+// appended to the record for the System Use Sharing Protocol.
+func isoDirectoryRecord(identifier []byte, sector, size uint32, directory bool, systemUse []byte) []byte {
+	// The identifier and the record are each padded to an even length.
+	length := 33 + len(identifier)
+	if len(identifier)%2 == 0 {
+		length++
+	}
+	systemUseOffset := length
+	length += len(systemUse) + len(systemUse)%2
+
+	record := make([]byte, length)
+	record[0] = byte(length)
+	isoBothEndian32(record[2:10], sector)
+	isoBothEndian32(record[10:18], size)
+	if directory {
+		record[25] = 2
+	}
+	isoBothEndian16(record[28:32], 1)
+	record[32] = byte(len(identifier))
+	copy(record[33:], identifier)
+	copy(record[systemUseOffset:], systemUse)
+	return record
+}
+
+// isoSUSPIndicator returns the System Use Sharing Protocol `SP` entry that
+// indicates the image's directory records carry Rock Ridge entries.
+func isoSUSPIndicator() []byte {
+	return []byte{'S', 'P', 7, 1, 0xbe, 0xef, 0}
+}
+
+// Flags of the Rock Ridge `NM` entry for the records of a directory itself and
+// its parent, which have no name.
+const (
+	isoRockRidgeNameCurrent = 0x02
+	isoRockRidgeNameParent  = 0x04
+)
+
+// isoRockRidgeName returns the Rock Ridge `NM` entry recording name as the
+// file's alternate name, with flags.
+func isoRockRidgeName(name string, flags byte) []byte {
+	return append([]byte{'N', 'M', byte(5 + len(name)), 1, flags}, name...)
+}
+
+// isoBothEndian16 writes v to b in little-endian followed by big-endian order.
+func isoBothEndian16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b[0:2], v)
+	binary.BigEndian.PutUint16(b[2:4], v)
+}
+
+// isoBothEndian32 writes v to b in little-endian followed by big-endian order.
+func isoBothEndian32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b[0:4], v)
+	binary.BigEndian.PutUint32(b[4:8], v)
+}
+
+// isoPadded writes s to b padded with spaces.
+func isoPadded(b []byte, s string) {
+	n := copy(b, s)
+	for i := n; i < len(b); i++ {
+		b[i] = ' '
+	}
+}
@@ -0,0 +1,91 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Copyright 2024 Oxide Computer Company
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// readISOFiles returns the files in the root directory of an ISO 9660 image,
+// keyed by file identifier, by following the primary volume descriptor.
+func readISOFiles(image []byte) map[string][]byte {
+	descriptor := image[isoPrimaryVolumeDescriptorSector*isoSectorSize:]
+	rootSector := binary.LittleEndian.Uint32(descriptor[156+2:])
+	rootSize := binary.LittleEndian.Uint32(descriptor[156+10:])
+	directory := image[rootSector*isoSectorSize : rootSector*isoSectorSize+rootSize]
+
+	files := make(map[string][]byte)
+	for offset := 0; offset < len(directory) && directory[offset] != 0; offset += int(directory[offset]) {
+		record := directory[offset:]
+		if record[25]&2 != 0 {
+			continue
+		}
+		sector := binary.LittleEndian.Uint32(record[2:])
+		size := binary.LittleEndian.Uint32(record[10:])
+		identifier := string(record[33 : 33+record[32]])
+		files[identifier] = image[sector*isoSectorSize : sector*isoSectorSize+size]
+	}
+	return files
+}
+
+var _ = Describe("buildSeedISO", func() {
+	It("should label the volume for NoCloud", func() {
+		image, err := buildSeedISO(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(len(image) % isoSectorSize).To(BeZero())
+
+		descriptor := image[isoPrimaryVolumeDescriptorSector*isoSectorSize:]
+		Expect(descriptor[0]).To(Equal(byte(1)))
+		Expect(string(descriptor[1:6])).To(Equal("CD001"))
+		Expect(strings.TrimRight(string(descriptor[40:72]), " ")).To(Equal("CIDATA"))
+		Expect(binary.LittleEndian.Uint32(descriptor[80:])).To(BeEquivalentTo(len(image) / isoSectorSize))
+
+		terminator := image[isoTerminatorSector*isoSectorSize:]
+		Expect(terminator[0]).To(Equal(byte(255)))
+		Expect(string(terminator[1:6])).To(Equal("CD001"))
+	})
+
+	It("should store the files in the root directory", func() {
+		userData := []byte("#cloud-config\nhostname: bob\n")
+		largeFile := bytes.Repeat([]byte("x"), 3*isoSectorSize+1)
+
+		image, err := buildSeedISO([]seedISOFile{
+			{Name: "user-data", Content: userData},
+			{Name: "vendor-data", Content: largeFile},
+			{Name: "meta-data", Content: []byte("instance-id: bob\n")},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(readISOFiles(image)).To(Equal(map[string][]byte{
+			"META-DATA;1":   []byte("instance-id: bob\n"),
+			"USER-DATA;1":   userData,
+			"VENDOR-DATA;1": largeFile,
+		}))
+	})
+
+	It("should record the file names as given with Rock Ridge", func() {
+		image, err := buildSeedISO([]seedISOFile{{Name: "user-data"}, {Name: "meta-data"}})
+		Expect(err).NotTo(HaveOccurred())
+
+		rootDirectory := image[isoRootDirectorySector*isoSectorSize : (isoRootDirectorySector+1)*isoSectorSize]
+		// The system use area of the first record follows its single byte
+		// identifier and padding.
+		Expect(rootDirectory[34:41]).To(Equal(isoSUSPIndicator()))
+		Expect(bytes.Contains(rootDirectory, isoRockRidgeName("user-data", 0))).To(BeTrue())
+		Expect(bytes.Contains(rootDirectory, isoRockRidgeName("meta-data", 0))).To(BeTrue())
+	})
+
+	It("should store empty files", func() {
+		image, err := buildSeedISO([]seedISOFile{{Name: "user-data"}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(readISOFiles(image)).To(HaveKeyWithValue("USER-DATA;1", BeEmpty()))
+	})
+})
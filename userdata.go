@@ -0,0 +1,344 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Copyright 2024 Oxide Computer Company
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// cloudConfigHeader is the first line of cloud-init user data in the
+// `#cloud-config` format.
+const cloudConfigHeader = "#cloud-config"
+
+// userDataFileStdin is the `oxide-user-data-file` value that reads the user
+// data from stdin.
+const userDataFileStdin = "-"
+
+// stdin is where user data is read from when `UserDataFile` is `-`. It's a
+// variable so tests can substitute a pipe.
+var stdin = os.Stdin
+
+// readStdinUserData reads the user data piped to stdin, keeping it for later
+// calls since stdin can only be read once. Rancher runs the driver as an RPC
+// plugin without anything on stdin, so stdin is only read when it's a pipe or
+// a redirected file, which is the case when running the driver from the
+// command line or other tooling.
+func (d *Driver) readStdinUserData() ([]byte, error) {
+	if d.stdinUserData != nil {
+		return d.stdinUserData, nil
+	}
+
+	info, err := stdin.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed inspecting stdin: %w", err)
+	}
+	if info.Mode()&os.ModeNamedPipe == 0 && !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("user data file %q requires user data to be piped to stdin", userDataFileStdin)
+	}
+
+	b, err := io.ReadAll(stdin)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading user data from stdin: %w", err)
+	}
+	if len(b) == 0 {
+		return nil, errors.New("no user data was piped to stdin")
+	}
+
+	d.stdinUserData = b
+	return b, nil
+}
+
+// injectSSHAuthorizedKey adds publicKey to `ssh_authorized_keys` in the
+// cloud-init user data, preserving any existing configuration.
+func injectSSHAuthorizedKey(userData []byte, publicKey string) ([]byte, error) {
+	return mergeCloudConfig(userData, func(root *yaml.Node) error {
+		keys := mappingValue(root, "ssh_authorized_keys")
+		if keys == nil {
+			keys = &yaml.Node{Kind: yaml.SequenceNode}
+			setMappingValue(root, "ssh_authorized_keys", keys)
+		}
+
+		if keys.Kind != yaml.SequenceNode {
+			return errors.New("user data ssh_authorized_keys is not a list")
+		}
+
+		if !slices.ContainsFunc(keys.Content, func(n *yaml.Node) bool { return n.Value == publicKey }) {
+			keys.Content = append(keys.Content, scalarNode(publicKey))
+		}
+
+		return nil
+	})
+}
+
+// injectResolvConf configures the instance DNS servers and search domains via
+// the cloud-init `resolv_conf` module, preserving any other configuration in
+// the user data. Existing `resolv_conf` settings other than the name servers
+// and search domains are kept.
+func injectResolvConf(userData []byte, nameservers, searchDomains []string) ([]byte, error) {
+	return mergeCloudConfig(userData, func(root *yaml.Node) error {
+		setMappingValue(root, "manage_resolv_conf", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"})
+
+		resolvConf := mappingValue(root, "resolv_conf")
+		if resolvConf == nil {
+			resolvConf = &yaml.Node{Kind: yaml.MappingNode}
+			setMappingValue(root, "resolv_conf", resolvConf)
+		}
+
+		if resolvConf.Kind != yaml.MappingNode {
+			return errors.New("user data resolv_conf is not a mapping")
+		}
+
+		if len(nameservers) > 0 {
+			setMappingValue(resolvConf, "nameservers", sequenceNode(nameservers))
+		}
+
+		if len(searchDomains) > 0 {
+			setMappingValue(resolvConf, "searchdomains", sequenceNode(searchDomains))
+		}
+
+		return nil
+	})
+}
+
+// networkConfigPath is where `injectNetworkConfig` writes the instance network
+// config on the instance.
+const networkConfigPath = "/etc/netplan/90-oxide-static.yaml"
+
+// networkConfig is a cloud-init network config version 2 document, which is
+// also a valid netplan configuration.
+type networkConfig struct {
+	Network struct {
+		Version   int                              `yaml:"version"`
+		Ethernets map[string]networkConfigEthernet `yaml:"ethernets"`
+	} `yaml:"network"`
+}
+
+// networkConfigEthernet is the configuration of an ethernet interface in a
+// `networkConfig`.
+type networkConfigEthernet struct {
+	Match       map[string]string         `yaml:"match"`
+	DHCP4       bool                      `yaml:"dhcp4"`
+	Addresses   []string                  `yaml:"addresses"`
+	Routes      []networkConfigRoute      `yaml:"routes,omitempty"`
+	Nameservers *networkConfigNameservers `yaml:"nameservers,omitempty"`
+}
+
+// networkConfigRoute is a route in a `networkConfigEthernet`.
+type networkConfigRoute struct {
+	To  string `yaml:"to"`
+	Via string `yaml:"via"`
+}
+
+// networkConfigNameservers is the DNS configuration in a
+// `networkConfigEthernet`.
+type networkConfigNameservers struct {
+	Addresses []string `yaml:"addresses,omitempty"`
+	Search    []string `yaml:"search,omitempty"`
+}
+
+// renderNetworkConfig renders a network config that statically configures the
+// instance's network interface with address, in CIDR notation, and the
+// optional gateway, name servers, and search domains.
+func renderNetworkConfig(address, gateway string, nameservers, searchDomains []string) ([]byte, error) {
+	ethernet := networkConfigEthernet{
+		// The instance has a single network interface whose name depends on
+		// the image.
+		Match:     map[string]string{"name": "en*"},
+		Addresses: []string{address},
+	}
+
+	if gateway != "" {
+		ethernet.Routes = []networkConfigRoute{{To: "default", Via: gateway}}
+	}
+
+	if len(nameservers) > 0 || len(searchDomains) > 0 {
+		ethernet.Nameservers = &networkConfigNameservers{
+			Addresses: nameservers,
+			Search:    searchDomains,
+		}
+	}
+
+	var config networkConfig
+	config.Network.Version = 2
+	config.Network.Ethernets = map[string]networkConfigEthernet{"primary": ethernet}
+
+	return yaml.Marshal(&config)
+}
+
+// injectNetworkConfig adds a `write_files` entry writing the rendered network
+// config to `networkConfigPath` and a `runcmd` entry applying it, preserving
+// any existing configuration in the user data.
+func injectNetworkConfig(userData []byte, address, gateway string, nameservers, searchDomains []string) ([]byte, error) {
+	config, err := renderNetworkConfig(address, gateway, nameservers, searchDomains)
+	if err != nil {
+		return nil, fmt.Errorf("failed rendering network config: %w", err)
+	}
+
+	return mergeCloudConfig(userData, func(root *yaml.Node) error {
+		writeFile := &yaml.Node{Kind: yaml.MappingNode}
+		setMappingValue(writeFile, "path", scalarNode(networkConfigPath))
+		// The permissions are quoted so they aren't read as an octal integer.
+		setMappingValue(writeFile, "permissions", &yaml.Node{Kind: yaml.ScalarNode, Style: yaml.DoubleQuotedStyle, Value: "0600"})
+		setMappingValue(writeFile, "content", scalarNode(string(config)))
+
+		if err := appendSequenceValue(root, "write_files", writeFile); err != nil {
+			return err
+		}
+
+		return appendSequenceValue(root, "runcmd", sequenceNode([]string{"netplan", "apply"}))
+	})
+}
+
+// consoleLoggingConfigPath is where `injectConsoleLogging` writes the journald
+// configuration on the instance.
+const consoleLoggingConfigPath = "/etc/systemd/journald.conf.d/90-oxide-console.conf"
+
+// consoleLoggingConfig is a journald configuration that keeps the journal
+// across reboots and forwards it to the serial console.
+const consoleLoggingConfig = `[Journal]
+Storage=persistent
+ForwardToConsole=yes
+TTYPath=/dev/ttyS0
+MaxLevelConsole=info
+`
+
+// consoleLoggingOutput is the cloud-init `output` configuration that copies the
+// output of cloud-init to the serial console in addition to its log file.
+const consoleLoggingOutput = "| tee -a /var/log/cloud-init-output.log /dev/ttyS0"
+
+// injectConsoleLogging adds a `write_files` entry configuring journald to keep
+// the journal and forward it to the serial console, a `runcmd` entry applying
+// it, and sends the cloud-init output to the serial console, preserving any
+// existing configuration in the user data. An existing `output` configuration
+// is kept as is.
+func injectConsoleLogging(userData []byte) ([]byte, error) {
+	return mergeCloudConfig(userData, func(root *yaml.Node) error {
+		if mappingValue(root, "output") == nil {
+			output := &yaml.Node{Kind: yaml.MappingNode}
+			setMappingValue(output, "all", scalarNode(consoleLoggingOutput))
+			setMappingValue(root, "output", output)
+		}
+
+		writeFile := &yaml.Node{Kind: yaml.MappingNode}
+		setMappingValue(writeFile, "path", scalarNode(consoleLoggingConfigPath))
+		setMappingValue(writeFile, "content", scalarNode(consoleLoggingConfig))
+
+		if err := appendSequenceValue(root, "write_files", writeFile); err != nil {
+			return err
+		}
+
+		return appendSequenceValue(root, "runcmd", sequenceNode([]string{"systemctl", "restart", "systemd-journald"}))
+	})
+}
+
+// appendSequenceValue appends value to the YAML sequence node for key in the
+// mapping node, creating the sequence when key is not present.
+func appendSequenceValue(mapping *yaml.Node, key string, value *yaml.Node) error {
+	sequence := mappingValue(mapping, key)
+	if sequence == nil {
+		sequence = &yaml.Node{Kind: yaml.SequenceNode}
+		setMappingValue(mapping, key, sequence)
+	}
+
+	if sequence.Kind != yaml.SequenceNode {
+		return fmt.Errorf("user data %s is not a list", key)
+	}
+
+	sequence.Content = append(sequence.Content, value)
+	return nil
+}
+
+// mergeCloudConfig calls merge with the top-level mapping of the cloud-init
+// user data and returns the updated user data. Empty user data results in a
+// new `#cloud-config` document. User data in any other format (e.g., a shell
+// script) cannot be merged and returns an error.
+func mergeCloudConfig(userData []byte, merge func(root *yaml.Node) error) ([]byte, error) {
+	if len(bytes.TrimSpace(userData)) == 0 {
+		userData = []byte(cloudConfigHeader + "\n")
+	}
+
+	if !bytes.HasPrefix(userData, []byte(cloudConfigHeader)) {
+		return nil, fmt.Errorf("cannot merge into user data that is not %s", cloudConfigHeader)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(userData, &doc); err != nil {
+		return nil, fmt.Errorf("failed parsing user data: %w", err)
+	}
+
+	// A document containing only comments has no content.
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{
+			Kind:    yaml.DocumentNode,
+			Content: []*yaml.Node{{Kind: yaml.MappingNode}},
+		}
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.New("user data is not a cloud-config mapping")
+	}
+
+	if err := merge(root); err != nil {
+		return nil, err
+	}
+
+	b, err := yaml.Marshal(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed encoding user data: %w", err)
+	}
+
+	// The header is a comment that may not survive encoding.
+	if !bytes.HasPrefix(b, []byte(cloudConfigHeader)) {
+		b = append([]byte(cloudConfigHeader+"\n"), b...)
+	}
+
+	return b, nil
+}
+
+// mappingValue returns the value node for key in the YAML mapping node, or nil
+// if key is not present.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue sets the value node for key in the YAML mapping node,
+// replacing the existing value or appending key when not present.
+func setMappingValue(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, scalarNode(key), value)
+}
+
+// scalarNode returns a YAML string node.
+func scalarNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+}
+
+// sequenceNode returns a YAML sequence node of strings.
+func sequenceNode(values []string) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, value := range values {
+		node.Content = append(node.Content, scalarNode(value))
+	}
+	return node
+}
@@ -0,0 +1,198 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Copyright 2024 Oxide Computer Company
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("injectSSHAuthorizedKey", func() {
+	const publicKey = "ssh-ed25519 AAAA generated"
+
+	DescribeTable("Success",
+		func(userData, expected string) {
+			Expect(injectSSHAuthorizedKey([]byte(userData), publicKey)).To(BeEquivalentTo(expected))
+		},
+		Entry("empty user data", "",
+			"#cloud-config\nssh_authorized_keys:\n    - ssh-ed25519 AAAA generated\n"),
+		Entry("header only", "#cloud-config\n",
+			"#cloud-config\nssh_authorized_keys:\n    - ssh-ed25519 AAAA generated\n"),
+		Entry("existing configuration",
+			"#cloud-config\npackages:\n  - curl\n",
+			"#cloud-config\npackages:\n    - curl\nssh_authorized_keys:\n    - ssh-ed25519 AAAA generated\n"),
+		Entry("existing authorized keys",
+			"#cloud-config\nssh_authorized_keys:\n  - ssh-ed25519 BBBB operator\n",
+			"#cloud-config\nssh_authorized_keys:\n    - ssh-ed25519 BBBB operator\n    - ssh-ed25519 AAAA generated\n"),
+		Entry("key already authorized",
+			"#cloud-config\nssh_authorized_keys:\n  - ssh-ed25519 AAAA generated\n",
+			"#cloud-config\nssh_authorized_keys:\n    - ssh-ed25519 AAAA generated\n"),
+	)
+
+	DescribeTable("Error",
+		func(userData string) {
+			_, err := injectSSHAuthorizedKey([]byte(userData), publicKey)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("shell script", "#!/bin/sh\necho hello\n"),
+		Entry("not a mapping", "#cloud-config\n- one\n"),
+		Entry("authorized keys not a list", "#cloud-config\nssh_authorized_keys: ssh-ed25519 BBBB operator\n"),
+		Entry("invalid YAML", "#cloud-config\npackages: [\n"),
+	)
+})
+
+var _ = Describe("injectResolvConf", func() {
+	DescribeTable("Success",
+		func(userData string, nameservers, searchDomains []string, expected string) {
+			Expect(injectResolvConf([]byte(userData), nameservers, searchDomains)).To(BeEquivalentTo(expected))
+		},
+		Entry("empty user data", "", []string{"10.0.0.2", "10.0.0.3"}, []string{"corp.example.com"},
+			"#cloud-config\nmanage_resolv_conf: true\nresolv_conf:\n    nameservers:\n        - 10.0.0.2\n        - 10.0.0.3\n    searchdomains:\n        - corp.example.com\n"),
+		Entry("name servers only", "", []string{"10.0.0.2"}, nil,
+			"#cloud-config\nmanage_resolv_conf: true\nresolv_conf:\n    nameservers:\n        - 10.0.0.2\n"),
+		Entry("existing configuration",
+			"#cloud-config\npackages:\n  - curl\n", nil, []string{"corp.example.com"},
+			"#cloud-config\npackages:\n    - curl\nmanage_resolv_conf: true\nresolv_conf:\n    searchdomains:\n        - corp.example.com\n"),
+		Entry("existing resolv_conf",
+			"#cloud-config\nmanage_resolv_conf: false\nresolv_conf:\n  nameservers: [8.8.8.8]\n  domain: example.com\n", []string{"10.0.0.2"}, nil,
+			"#cloud-config\nmanage_resolv_conf: true\nresolv_conf:\n    nameservers:\n        - 10.0.0.2\n    domain: example.com\n"),
+	)
+
+	DescribeTable("Error",
+		func(userData string) {
+			_, err := injectResolvConf([]byte(userData), []string{"10.0.0.2"}, nil)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("shell script", "#!/bin/sh\necho hello\n"),
+		Entry("resolv_conf not a mapping", "#cloud-config\nresolv_conf: nameserver 8.8.8.8\n"),
+	)
+})
+
+var _ = Describe("renderNetworkConfig", func() {
+	DescribeTable("Success",
+		func(gateway string, nameservers, searchDomains []string, expected string) {
+			Expect(renderNetworkConfig("172.30.0.10/22", gateway, nameservers, searchDomains)).To(BeEquivalentTo(expected))
+		},
+		Entry("address only", "", nil, nil,
+			"network:\n    version: 2\n    ethernets:\n        primary:\n            match:\n                name: en*\n            dhcp4: false\n            addresses:\n                - 172.30.0.10/22\n"),
+		Entry("gateway and DNS", "172.30.0.1", []string{"10.0.0.2"}, []string{"corp.example.com"},
+			"network:\n    version: 2\n    ethernets:\n        primary:\n            match:\n                name: en*\n            dhcp4: false\n            addresses:\n                - 172.30.0.10/22\n            routes:\n                - to: default\n                  via: 172.30.0.1\n            nameservers:\n                addresses:\n                    - 10.0.0.2\n                search:\n                    - corp.example.com\n"),
+	)
+})
+
+var _ = Describe("injectNetworkConfig", func() {
+	It("should write and apply the network config", func() {
+		userData, err := injectNetworkConfig([]byte("#cloud-config\nruncmd:\n  - [echo, hello]\n"), "172.30.0.10/22", "", nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(userData)).To(Equal("#cloud-config\n" +
+			"runcmd:\n    - [echo, hello]\n    - - netplan\n      - apply\n" +
+			"write_files:\n    - path: /etc/netplan/90-oxide-static.yaml\n      permissions: \"0600\"\n" +
+			"      content: |\n        network:\n            version: 2\n            ethernets:\n                primary:\n" +
+			"                    match:\n                        name: en*\n                    dhcp4: false\n" +
+			"                    addresses:\n                        - 172.30.0.10/22\n"))
+	})
+
+	DescribeTable("Error",
+		func(userData string) {
+			_, err := injectNetworkConfig([]byte(userData), "172.30.0.10/22", "", nil, nil)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("shell script", "#!/bin/sh\necho hello\n"),
+		Entry("write_files not a list", "#cloud-config\nwrite_files: /etc/motd\n"),
+		Entry("runcmd not a list", "#cloud-config\nruncmd: reboot\n"),
+	)
+})
+
+var _ = Describe("injectConsoleLogging", func() {
+	It("should configure journald and the cloud-init output", func() {
+		userData, err := injectConsoleLogging([]byte("#cloud-config\nruncmd:\n  - [echo, hello]\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(userData)).To(Equal("#cloud-config\n" +
+			"runcmd:\n    - [echo, hello]\n    - - systemctl\n      - restart\n      - systemd-journald\n" +
+			"output:\n    all: '| tee -a /var/log/cloud-init-output.log /dev/ttyS0'\n" +
+			"write_files:\n    - path: /etc/systemd/journald.conf.d/90-oxide-console.conf\n" +
+			"      content: |\n        [Journal]\n        Storage=persistent\n        ForwardToConsole=yes\n" +
+			"        TTYPath=/dev/ttyS0\n        MaxLevelConsole=info\n"))
+	})
+
+	It("should keep an existing output configuration", func() {
+		userData, err := injectConsoleLogging([]byte("#cloud-config\noutput:\n  all: '>> /var/log/custom.log'\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(userData)).To(HavePrefix("#cloud-config\noutput:\n    all: '>> /var/log/custom.log'\nwrite_files:\n"))
+	})
+
+	DescribeTable("Error",
+		func(userData string) {
+			_, err := injectConsoleLogging([]byte(userData))
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("shell script", "#!/bin/sh\necho hello\n"),
+		Entry("write_files not a list", "#cloud-config\nwrite_files: /etc/motd\n"),
+	)
+})
+
+var _ = Describe("readStdinUserData", func() {
+	var SUT *Driver
+
+	BeforeEach(func() {
+		SUT = newDriver("bob", "path")
+		SUT.UserDataFile = userDataFileStdin
+		SUT.SkipPreCreateValidation = true
+	})
+
+	setStdin := func(f *os.File) {
+		original := stdin
+		stdin = f
+		DeferCleanup(func() { stdin = original })
+	}
+
+	It("should read the user data piped to stdin once", func() {
+		r, w, err := os.Pipe()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(r.Close)
+		setStdin(r)
+
+		_, err = w.Write([]byte("#cloud-config\npackages:\n  - curl\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(w.Close()).To(Succeed())
+
+		Expect(SUT.PreCreateCheck()).To(Succeed())
+		Expect(SUT.readUserData()).To(BeEquivalentTo("#cloud-config\npackages:\n  - curl\n"))
+	})
+
+	It("should fail when nothing is piped to stdin", func() {
+		r, w, err := os.Pipe()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(r.Close)
+		setStdin(r)
+		Expect(w.Close()).To(Succeed())
+
+		Expect(SUT.PreCreateCheck()).To(MatchError("no user data was piped to stdin"))
+	})
+
+	It("should fail when stdin is not a pipe or file", func() {
+		devNull, err := os.Open(os.DevNull)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(devNull.Close)
+		setStdin(devNull)
+
+		Expect(SUT.PreCreateCheck()).To(MatchError(ContainSubstring("requires user data to be piped to stdin")))
+	})
+
+	It("should read user data redirected from a file", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "user-data")
+		Expect(os.WriteFile(path, []byte("#cloud-config\n"), 0o600)).To(Succeed())
+		f, err := os.Open(path)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(f.Close)
+		setStdin(f)
+
+		Expect(SUT.readUserData()).To(BeEquivalentTo("#cloud-config\n"))
+	})
+})
@@ -0,0 +1,60 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Copyright 2024 Oxide Computer Company
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"runtime/debug"
+)
+
+// version is the driver version. It's set at build time using
+// `-ldflags "-X main.version=..."` and falls back to the module version from
+// the build information.
+var version = ""
+
+// versionFlag is the command line argument that prints the driver version and
+// capabilities instead of serving the driver over RPC.
+const versionFlag = "--version"
+
+// VersionInfo describes the driver version and the capabilities it supports.
+type VersionInfo struct {
+	Driver  string   `json:"driver"`
+	Version string   `json:"version"`
+	Flags   []string `json:"flags"`
+}
+
+// driverVersion returns the driver version.
+func driverVersion() string {
+	if version != "" {
+		return version
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+
+	return "unknown"
+}
+
+// writeVersionInfo writes the driver version and the names of the flags it
+// supports to w as JSON.
+func writeVersionInfo(w io.Writer, d *Driver) error {
+	createFlags := d.GetCreateFlags()
+
+	info := VersionInfo{
+		Driver:  d.DriverName(),
+		Version: driverVersion(),
+		Flags:   make([]string, len(createFlags)),
+	}
+	for i, flag := range createFlags {
+		info.Flags[i] = flag.String()
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(info)
+}
@@ -0,0 +1,31 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Copyright 2024 Oxide Computer Company
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("writeVersionInfo", func() {
+	It("should write the version and supported flags as JSON", func() {
+		v := version
+		version = "v1.2.3"
+		DeferCleanup(func() { version = v })
+
+		var buf bytes.Buffer
+		Expect(writeVersionInfo(&buf, newDriver("", ""))).To(Succeed())
+
+		var info VersionInfo
+		Expect(json.Unmarshal(buf.Bytes(), &info)).To(Succeed())
+		Expect(info.Driver).To(Equal("oxide"))
+		Expect(info.Version).To(Equal("v1.2.3"))
+		Expect(info.Flags).To(ContainElements(flagHost, flagToken, flagProject, flagBootDiskImageID))
+	})
+})
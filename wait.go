@@ -0,0 +1,385 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Copyright 2024 Oxide Computer Company
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/oxidecomputer/oxide.go/oxide"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/ssh"
+	"github.com/rancher/machine/libmachine/state"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+// preCreateWaitInterval is how often `PreCreateCheck` looks up a resource that
+// was not found while waiting up to `oxide-precreate-wait` for it to appear.
+var preCreateWaitInterval = 2 * time.Second
+
+// nicIPPollInterval is how often `Create` lists the instance network
+// interfaces while waiting up to `oxide-nic-ip-wait` for an IP address.
+var nicIPPollInterval = time.Second
+
+// Polling configuration for `waitForSSHAuth`.
+var (
+	sshAuthPollInterval = 5 * time.Second
+	sshAuthDialTimeout  = 10 * time.Second
+	sshAuthWaitTimeout  = 5 * time.Minute
+
+	// Consecutive connection timeouts after which a hint about the VPC
+	// firewall rules is logged, since refused connections would indicate
+	// the instance is reachable but not yet listening.
+	sshAuthTimeoutsBeforeHint = 3
+)
+
+// Backoff configuration for `retryTransient`.
+var (
+	transientRetryInterval    = time.Second
+	transientRetryMaxInterval = 10 * time.Second
+)
+
+// Polling configuration for `waitForRunning`.
+var (
+	runningPollInterval    = time.Second
+	runningPollMaxInterval = 10 * time.Second
+	runningWaitTimeout     = 5 * time.Minute
+)
+
+// Polling configuration for `waitForSnapshotReady`.
+var (
+	snapshotPollInterval = 2 * time.Second
+	snapshotWaitTimeout  = 10 * time.Minute
+)
+
+// Polling configuration for `waitForDiskDeleted`.
+var (
+	diskDeletionPollInterval = time.Second
+	diskDeletionWaitTimeout  = 2 * time.Minute
+)
+
+// waitForStopped waits up to `RemoveTimeout` for the instance to stop. The
+// instance state is checked with an exponential backoff starting at
+// `StopPollInterval` and capped at `StopPollMaxInterval`. When the instance has
+// been stopping for longer than `StopReissueAfter`, the stop is re-issued since
+// it may be stuck.
+func (d *Driver) waitForStopped(ctx context.Context) error {
+	stopCtx, cancel := context.WithTimeout(ctx, cmp.Or(d.RemoveTimeout, defaultRemoveTimeout))
+	defer cancel()
+
+	stoppingSince := time.Now()
+	reissueStuckStop := func(currentState state.State) error {
+		if currentState != state.Stopping {
+			stoppingSince = time.Now()
+			return nil
+		}
+
+		if d.StopReissueAfter <= 0 || time.Since(stoppingSince) < d.StopReissueAfter {
+			return nil
+		}
+
+		log.Warnf("Instance %s has been stopping for over %s, re-issuing stop", d.InstanceID, d.StopReissueAfter)
+		stoppingSince = time.Now()
+		return d.stop(ctx)
+	}
+
+	if _, err := d.waitForState(stopCtx, state.Stopped, d.StopPollInterval, d.StopPollMaxInterval, reissueStuckStop); err != nil {
+		return fmt.Errorf("timed out waiting for instance to stop: %w", err)
+	}
+	return nil
+}
+
+// waitForRunning waits for the instance to be running. A `DriverError`
+// including the last observed instance state is returned on timeout.
+func (d *Driver) waitForRunning(ctx context.Context, op string) error {
+	runningCtx, cancel := context.WithTimeout(ctx, runningWaitTimeout)
+	defer cancel()
+
+	lastState, err := d.waitForState(runningCtx, state.Running, runningPollInterval, runningPollMaxInterval, nil)
+	if err != nil {
+		return NewDriverError(op, lastState, fmt.Errorf("timed out waiting for instance to be running: %w", err))
+	}
+	return nil
+}
+
+// waitForSnapshotReady waits for the snapshot taken to clone the boot disk to
+// be ready to create a disk from.
+func (d *Driver) waitForSnapshotReady(ctx context.Context) error {
+	snapshotCtx, cancel := context.WithTimeout(ctx, snapshotWaitTimeout)
+	defer cancel()
+
+	for {
+		snapshot, err := d.oxideClient.SnapshotView(snapshotCtx, oxide.SnapshotViewParams{
+			Snapshot: oxide.NameOrId(d.CloneSnapshotID),
+		})
+		if err != nil {
+			return fmt.Errorf("failed viewing snapshot %s: %w", d.CloneSnapshotID, err)
+		}
+
+		switch snapshot.State {
+		case oxide.SnapshotStateReady:
+			return nil
+		case oxide.SnapshotStateCreating:
+		default:
+			return fmt.Errorf("snapshot %s is %s", d.CloneSnapshotID, snapshot.State)
+		}
+
+		select {
+		case <-snapshotCtx.Done():
+			return fmt.Errorf("timed out waiting for snapshot %s to be ready: %w", d.CloneSnapshotID, snapshotCtx.Err())
+		case <-time.After(snapshotPollInterval):
+		}
+	}
+}
+
+// waitForDiskDeleted waits for the disk to no longer exist.
+func (d *Driver) waitForDiskDeleted(ctx context.Context, diskID string) error {
+	deleteCtx, cancel := context.WithTimeout(ctx, diskDeletionWaitTimeout)
+	defer cancel()
+
+	for {
+		_, err := d.oxideClient.DiskView(deleteCtx, oxide.DiskViewParams{
+			Disk: oxide.NameOrId(diskID),
+		})
+		if isNotFound(err) {
+			return nil
+		}
+		if err != nil && deleteCtx.Err() == nil {
+			return fmt.Errorf("failed viewing disk %s: %w", diskID, err)
+		}
+
+		select {
+		case <-deleteCtx.Done():
+			return fmt.Errorf("timed out waiting for disk %s to be deleted: %w", diskID, deleteCtx.Err())
+		case <-time.After(diskDeletionPollInterval):
+		}
+	}
+}
+
+// waitForInternalIPAddress returns the address of the instance's network
+// interface used to reach it. The network interfaces are listed every
+// `nicIPPollInterval` until the address is assigned or `NICIPWait` elapses.
+// Without a wait, the address is returned as first listed, even when it's not
+// assigned yet.
+func (d *Driver) waitForInternalIPAddress(ctx context.Context) (string, error) {
+	deadline := time.Now().Add(d.NICIPWait)
+
+	for {
+		ip, err := d.internalIPAddress(ctx)
+		if err != nil || ip != "" || d.NICIPWait <= 0 {
+			return ip, err
+		}
+
+		if time.Now().Add(nicIPPollInterval).After(deadline) {
+			return "", fmt.Errorf("network interface has no IP address after waiting %s", d.NICIPWait)
+		}
+
+		if err := d.sleep(ctx, nicIPPollInterval); err != nil {
+			return "", err
+		}
+	}
+}
+
+// waitForSSHAuth waits for the instance to accept SSH authentication with the
+// generated private key. Connections are attempted every `sshAuthPollInterval`
+// until one succeeds or `sshAuthWaitTimeout` elapses, since the instance may
+// still be booting.
+func (d *Driver) waitForSSHAuth(ctx context.Context) error {
+	host, err := d.GetSSHHostname()
+	if err != nil {
+		return err
+	}
+
+	port, err := d.GetSSHPort()
+	if err != nil {
+		return err
+	}
+
+	config, err := ssh.NewNativeConfig(d.GetSSHUsername(), &ssh.Auth{Keys: []string{d.GetSSHKeyPath()}})
+	if err != nil {
+		return err
+	}
+	config.Timeout = sshAuthDialTimeout
+
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	deadline := time.Now().Add(sshAuthWaitTimeout)
+
+	timeouts := 0
+	for {
+		conn, err := cryptossh.Dial("tcp", addr, &config)
+		if err == nil {
+			// Authentication succeeded. The instance may close the connection
+			// first so the error closing it is irrelevant.
+			_ = conn.Close()
+			return nil
+		}
+
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			timeouts++
+			if timeouts == sshAuthTimeoutsBeforeHint {
+				d.logFirewallHint(ctx, addr, port)
+			}
+		} else {
+			timeouts = 0
+		}
+
+		if time.Now().Add(sshAuthPollInterval).After(deadline) {
+			return fmt.Errorf("timed out verifying ssh authentication to %s as %q: %w", addr, config.User, err)
+		}
+
+		if err := d.sleep(ctx, sshAuthPollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// logFirewallHint logs a hint to check the VPC firewall rules when SSH
+// connections to addr time out, along with the enabled inbound rules when the
+// token is permitted to view them.
+func (d *Driver) logFirewallHint(ctx context.Context, addr string, port int) {
+	log.Warnf("SSH connections to %s are timing out rather than being refused; check that the firewall rules of vpc %q allow inbound TCP port %d", addr, d.VPC, port)
+
+	rules, err := d.oxideClient.VpcFirewallRulesView(ctx, oxide.VpcFirewallRulesViewParams{
+		Project: oxide.NameOrId(d.Project),
+		Vpc:     oxide.NameOrId(d.VPC),
+	})
+	if err != nil {
+		log.Debugf("Failed listing firewall rules of vpc %q: %v", d.VPC, err)
+		return
+	}
+
+	for _, rule := range rules.Rules {
+		if rule.Direction != oxide.VpcFirewallRuleDirectionInbound || rule.Status != oxide.VpcFirewallRuleStatusEnabled {
+			continue
+		}
+
+		ports := "all ports"
+		if len(rule.Filters.Ports) > 0 {
+			ports = fmt.Sprintf("ports %v", rule.Filters.Ports)
+		}
+		log.Warnf("Inbound firewall rule %s of vpc %q: %s %s", rule.Name, d.VPC, rule.Action, ports)
+	}
+}
+
+// waitForState polls the instance state with an exponential backoff until it
+// matches want or ctx is done. The optional observe is called with every other
+// observed state and can act on it (e.g., re-issue a stuck operation). The last
+// observed state is returned.
+func (d *Driver) waitForState(ctx context.Context, want state.State, initial, maxInterval time.Duration, observe func(state.State) error) (state.State, error) {
+	lastState := state.None
+	for attempt := 0; ; attempt++ {
+		// The state lookup fails when ctx is done while it's in flight, so the
+		// state observed before is returned.
+		currentState, err := d.getState(ctx)
+		if err != nil {
+			return lastState, err
+		}
+		lastState = currentState
+
+		if currentState == want {
+			return currentState, nil
+		}
+
+		if observe != nil {
+			if err := observe(currentState); err != nil {
+				return currentState, err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return currentState, ctx.Err()
+		case <-time.After(backoffInterval(initial, maxInterval, attempt)):
+		}
+	}
+}
+
+// waitForResource calls view until it succeeds. A not found error is retried
+// every `preCreateWaitInterval` until `PreCreateWait` elapses since resources
+// may be created concurrently with the machine. Any other error is returned
+// immediately.
+func (d *Driver) waitForResource(ctx context.Context, view func() error) error {
+	deadline := time.Now().Add(d.PreCreateWait)
+
+	for {
+		err := view()
+		if err == nil || !isNotFound(err) || time.Now().Add(preCreateWaitInterval).After(deadline) {
+			return err
+		}
+
+		if err := d.sleep(ctx, preCreateWaitInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// retryTransient calls fn, retrying it up to maxRetries times with an
+// exponential backoff while it fails with a transient error.
+func (d *Driver) retryTransient(ctx context.Context, op string, maxRetries int, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= maxRetries || !isTransient(err) {
+			return err
+		}
+
+		interval := backoffInterval(transientRetryInterval, transientRetryMaxInterval, attempt)
+		log.Infof("Retrying %s in %s after transient error: %v", op, interval, err)
+		if err := d.sleep(ctx, interval); err != nil {
+			return err
+		}
+	}
+}
+
+// sleep waits for interval, returning early with the context error when ctx
+// is done (e.g., the operation in progress runs out of time).
+func (d *Driver) sleep(ctx context.Context, interval time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(interval):
+		return nil
+	}
+}
+
+// backoffInterval returns how long to wait before the next check when polling
+// with an exponential backoff. The interval starts at initial, doubles after
+// every attempt, and is capped at maxInterval.
+func backoffInterval(initial, maxInterval time.Duration, attempt int) time.Duration {
+	if initial <= 0 {
+		initial = defaultStopPollInterval
+	}
+	if maxInterval < initial {
+		maxInterval = initial
+	}
+
+	interval := initial
+	for range attempt {
+		interval *= 2
+		if interval >= maxInterval {
+			return maxInterval
+		}
+	}
+	return interval
+}
+
+// parsePositiveDuration parses a duration string (e.g., 30s) that must be
+// greater than zero.
+func parsePositiveDuration(s string) (time.Duration, error) {
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if duration <= 0 {
+		return 0, errors.New("duration must be greater than zero")
+	}
+	return duration, nil
+}
@@ -0,0 +1,185 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Copyright 2024 Oxide Computer Company
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/oxidecomputer/oxide.go/oxide"
+	"github.com/rancher/machine/libmachine/log"
+	"github.com/rancher/machine/libmachine/ssh"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+var _ = Describe("Wait", func() {
+	DescribeTable("backoffInterval follows an exponential schedule",
+		func(initial, maxInterval time.Duration, expected []time.Duration) {
+			schedule := make([]time.Duration, len(expected))
+			for attempt := range expected {
+				schedule[attempt] = backoffInterval(initial, maxInterval, attempt)
+			}
+			Expect(schedule).To(Equal(expected))
+		},
+		Entry("doubles up to the cap", time.Second, 10*time.Second,
+			[]time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}),
+		Entry("stays constant when the cap equals the initial interval", time.Second, time.Second,
+			[]time.Duration{time.Second, time.Second, time.Second}),
+		Entry("raises a cap below the initial interval", 5*time.Second, time.Second,
+			[]time.Duration{5 * time.Second, 5 * time.Second}),
+		Entry("uses the default initial interval when unset", time.Duration(0), 4*time.Second,
+			[]time.Duration{defaultStopPollInterval, 2 * defaultStopPollInterval, 4 * time.Second}),
+	)
+
+	DescribeTable("parsePositiveDuration",
+		func(s string, expected time.Duration, succeeds bool) {
+			d, err := parsePositiveDuration(s)
+			if !succeeds {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(d).To(Equal(expected))
+		},
+		Entry("parses seconds", "30s", 30*time.Second, true),
+		Entry("parses minutes", "5m", 5*time.Minute, true),
+		Entry("rejects zero", "0s", time.Duration(0), false),
+		Entry("rejects negative", "-1s", time.Duration(0), false),
+		Entry("rejects garbage", "soon", time.Duration(0), false),
+	)
+})
+
+var _ = Describe("waitForSSHAuth", func() {
+	var SUT *Driver
+
+	BeforeEach(func() {
+		storePath := GinkgoT().TempDir()
+		Expect(os.MkdirAll(filepath.Join(storePath, "machines", "bob"), 0o700)).To(Succeed())
+
+		SUT = newDriver("bob", storePath)
+		SUT.SSHUser = "ubuntu"
+		SUT.InternalIPAddress = "127.0.0.1"
+		SUT.SSHKeyPath = SUT.GetSSHKeyPath()
+		Expect(ssh.GenerateSSHKey(SUT.SSHKeyPath)).To(Succeed())
+
+		interval, timeout := sshAuthPollInterval, sshAuthWaitTimeout
+		sshAuthPollInterval, sshAuthWaitTimeout = 10*time.Millisecond, 100*time.Millisecond
+		DeferCleanup(func() { sshAuthPollInterval, sshAuthWaitTimeout = interval, timeout })
+	})
+
+	It("should succeed when the instance accepts the generated key", func() {
+		authorizedKey, err := os.ReadFile(SUT.SSHKeyPath + ".pub")
+		Expect(err).NotTo(HaveOccurred())
+		SUT.SSHPort = startFakeSSHServer(authorizedKey)
+
+		Expect(SUT.waitForSSHAuth(context.TODO())).To(Succeed())
+	})
+
+	It("should time out when the instance rejects the generated key", func() {
+		otherKeyPath := filepath.Join(GinkgoT().TempDir(), "other")
+		Expect(ssh.GenerateSSHKey(otherKeyPath)).To(Succeed())
+		authorizedKey, err := os.ReadFile(otherKeyPath + ".pub")
+		Expect(err).NotTo(HaveOccurred())
+		SUT.SSHPort = startFakeSSHServer(authorizedKey)
+
+		Expect(SUT.waitForSSHAuth(context.TODO())).To(MatchError(ContainSubstring(`timed out verifying ssh authentication`)))
+	})
+})
+
+// startFakeSSHServer starts an SSH server on the loopback interface that only
+// accepts authentication with authorizedKey and returns its port. The server
+// is stopped when the spec finishes.
+func startFakeSSHServer(authorizedKey []byte) int {
+	pub, _, _, _, err := cryptossh.ParseAuthorizedKey(authorizedKey)
+	Expect(err).NotTo(HaveOccurred())
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	hostSigner, err := cryptossh.NewSignerFromKey(hostKey)
+	Expect(err).NotTo(HaveOccurred())
+
+	config := &cryptossh.ServerConfig{
+		PublicKeyCallback: func(_ cryptossh.ConnMetadata, key cryptossh.PublicKey) (*cryptossh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), pub.Marshal()) {
+				return nil, errors.New("unauthorized key")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	DeferCleanup(listener.Close)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if sshConn, _, _, err := cryptossh.NewServerConn(conn, config); err == nil {
+					sshConn.Close()
+				}
+			}()
+		}
+	}()
+
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	Expect(err).NotTo(HaveOccurred())
+	p, err := strconv.Atoi(port)
+	Expect(err).NotTo(HaveOccurred())
+	return p
+}
+
+var _ = Describe("logFirewallHint", func() {
+	It("should log the enabled inbound firewall rules of the VPC", func() {
+		api := newFakeOxideAPI()
+		DeferCleanup(api.Close)
+		SUT := api.driver("bob", GinkgoT().TempDir())
+		client, err := SUT.createOxideClient()
+		Expect(err).NotTo(HaveOccurred())
+		SUT.oxideClient = client
+
+		api.handle("GET /v1/vpc-firewall-rules", func(w http.ResponseWriter, r *http.Request) {
+			respondJSON(w, http.StatusOK, oxide.VpcFirewallRules{
+				Rules: []oxide.VpcFirewallRule{
+					{Name: "allow-icmp", Action: oxide.VpcFirewallRuleActionAllow, Direction: oxide.VpcFirewallRuleDirectionInbound, Status: oxide.VpcFirewallRuleStatusEnabled},
+					{Name: "allow-ssh", Action: oxide.VpcFirewallRuleActionAllow, Direction: oxide.VpcFirewallRuleDirectionInbound, Status: oxide.VpcFirewallRuleStatusDisabled, Filters: oxide.VpcFirewallRuleFilter{Ports: []oxide.L4PortRange{"22"}}},
+					{Name: "allow-egress", Action: oxide.VpcFirewallRuleActionAllow, Direction: oxide.VpcFirewallRuleDirectionOutbound, Status: oxide.VpcFirewallRuleStatusEnabled},
+				},
+			})
+		})
+
+		var buf bytes.Buffer
+		log.SetOutWriter(&buf)
+		log.SetErrWriter(&buf)
+		DeferCleanup(func() {
+			log.SetOutWriter(os.Stdout)
+			log.SetErrWriter(os.Stderr)
+		})
+
+		SUT.logFirewallHint(context.TODO(), "172.30.0.5:22", 22)
+
+		Expect(buf.String()).To(ContainSubstring(`check that the firewall rules of vpc "default" allow inbound TCP port 22`))
+		Expect(buf.String()).To(ContainSubstring(`Inbound firewall rule allow-icmp of vpc "default": allow all ports`))
+		Expect(buf.String()).NotTo(ContainSubstring("allow-ssh"))
+		Expect(buf.String()).NotTo(ContainSubstring("allow-egress"))
+	})
+})